@@ -1,6 +1,8 @@
 package cmd
 
 import (
+	"strings"
+
 	"github.com/lacework-dev/scripts/lw-inventory/cmd/lwaws"
 	"github.com/lacework-dev/scripts/lw-inventory/helpers"
 	"github.com/spf13/cobra"
@@ -11,18 +13,64 @@ var awsCmd = &cobra.Command{
 	Short: "Grab AWS Inventory",
 	Long:  `Grab AWS Inventory`,
 	Run: func(cmd *cobra.Command, args []string) {
-		regions := lwaws.ParseRegions(cmd)
-		profiles := lwaws.ParseProfiles(cmd)
-		tags := lwaws.ParseTags(cmd)
-		debug := helpers.ParseDebug(cmd)
-		lwaws.Run(profiles, regions, debug, tags)
+		output := helpers.ParseOutput(cmd)
+		opts := lwaws.Options{
+			Regions:           lwaws.ParseRegions(cmd),
+			ExcludeRegions:    lwaws.ParseExcludeRegions(cmd),
+			Profiles:          lwaws.ParseProfiles(cmd),
+			Credentials:       lwaws.ParseCredentials(cmd),
+			Partition:         lwaws.ParsePartition(cmd),
+			K8sTags:           lwaws.ParseTags(cmd),
+			Org:               lwaws.ParseOrg(cmd),
+			Debug:             helpers.ParseDebug(cmd),
+			Concurrency:       helpers.ParseConcurrency(cmd),
+			Services:          lwaws.ParseServices(cmd),
+			LambdaVCPUs:       lwaws.ParseLambdaVCPUs(cmd),
+			Hosts:             helpers.ParseHosts(cmd),
+			States:            helpers.ParseStates(cmd),
+			Tags:              helpers.ParseTagFilter(cmd),
+			GroupBy:           helpers.ParseGroupByTag(cmd),
+			ImageLookbackDays: helpers.ParseImageLookback(cmd),
+			Progress:          output.Progress(),
+		}
+
+		ctx, cancel := helpers.ScanContext(helpers.ParseTimeout(cmd))
+		defer cancel()
+
+		inventory := lwaws.Run(ctx, opts)
+		helpers.WriteReport(output, inventory)
+		exitCode = helpers.ExitCode(inventory)
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(awsCmd)
 	awsCmd.Flags().StringP("profile", "p", "", "AWS Profile(s) to inventory")
-	awsCmd.Flags().StringP("region", "r", "", "AWS Region(s) to inventory")
+	awsCmd.Flags().String("all-profiles", "", "Inventory every profile of the AWS config files, or those matching a glob such as --all-profiles='sso-*'")
+	awsCmd.Flags().Lookup("all-profiles").NoOptDefVal = "*"
+	awsCmd.Flags().StringP("region", "r", "", "AWS Region(s) to inventory, glob patterns such as us-* and !ap-east-1 are allowed")
+	awsCmd.Flags().String("exclude-region", "", "AWS Region(s) to skip, glob patterns are allowed")
 	awsCmd.Flags().BoolP("debug", "d", false, "Show Debug Logs")
-	awsCmd.Flags().StringP("tags", "t", "", "Tags for K8s VMs")
+	awsCmd.Flags().String("output", "table", "Report format: table, json or csv")
+	awsCmd.Flags().String("output-file", "", "Write the report to a file instead of stdout")
+	awsCmd.Flags().String("states", "running", "VM lifecycle states to inventory: running, stopped or all")
+	awsCmd.Flags().String("include-tag", "", "Only inventory resources with one of these tags, key=value or key, comma separated")
+	awsCmd.Flags().String("exclude-tag", "", "Leave out resources with any of these tags, key=value or key, comma separated")
+	awsCmd.Flags().String("group-by-tag", "", "Break VMs, vCPUs and agentless resources down by the value of this tag key")
+	awsCmd.Flags().Bool("hosts", false, "Record every agent VM and its image in the report, for agent-support")
+	awsCmd.Flags().StringP("tags", "t", "", "Extra tag keys that mark K8s VMs, EKS nodes are found without them")
+	awsCmd.Flags().String("only-services", "", "Only inventory these services: "+strings.Join(lwaws.ServiceNames(), ", "))
+	awsCmd.Flags().String("skip-services", "", "Services to leave out of the inventory")
+	awsCmd.Flags().Int("image-lookback-days", 30, "Count container images pushed to ECR within this many days")
+	awsCmd.Flags().Bool("lambda-vcpus", false, "Estimate a vCPU equivalent for Lambda functions from their configured memory at one vCPU per 1,769 MB, unlike lw-billing which converts billed GB-seconds")
+	awsCmd.Flags().Bool("org", false, "Inventory every account in the AWS Organization of the profile(s)")
+	awsCmd.Flags().String("org-role", "OrganizationAccountAccessRole", "Role to assume in organization member accounts")
+	awsCmd.Flags().String("partition", "", "AWS partition of the profile(s): aws, aws-us-gov or aws-cn (default detected from each profile)")
+	awsCmd.Flags().String("role-arn", "", "Role to assume with the profile credentials, or with --web-identity-token-file")
+	awsCmd.Flags().String("external-id", "", "External ID to use when assuming --role-arn or the organization role")
+	awsCmd.Flags().String("role-session-name", lwaws.DEFAULT_ROLE_SESSION_NAME, "Session name of assumed roles")
+	awsCmd.Flags().String("mfa-serial", "", "MFA device to assume --role-arn with, the token code is read from stdin")
+	awsCmd.Flags().String("web-identity-token-file", "", "OIDC token file to assume --role-arn with, such as a CI job token")
+	awsCmd.Flags().Int("concurrency", 10, "Maximum number of AWS API scans to run at once")
+	awsCmd.Flags().Duration("timeout", 0, "Stop the scan after this long, e.g. 30m (default no limit)")
 }
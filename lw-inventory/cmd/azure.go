@@ -11,9 +11,20 @@ var azureCmd = &cobra.Command{
 	Short: "Grab Azure Inventory",
 	Long:  `Grab Azure Inventory`,
 	Run: func(cmd *cobra.Command, args []string) {
-		subscriptions := lwazure.ParseIgnoreSubscriptions(cmd)
-		debug := helpers.ParseDebug(cmd)
-		lwazure.Run(subscriptions, debug)
+		output := helpers.ParseOutput(cmd)
+		opts := lwazure.Options{
+			SubscriptionsToIgnore: lwazure.ParseIgnoreSubscriptions(cmd),
+			Debug:                 helpers.ParseDebug(cmd),
+			Hosts:                 helpers.ParseHosts(cmd),
+			States:                helpers.ParseStates(cmd),
+			Tags:                  helpers.ParseTagFilter(cmd),
+			GroupBy:               helpers.ParseGroupByTag(cmd),
+			ImageLookbackDays:     helpers.ParseImageLookback(cmd),
+			Progress:              output.Progress(),
+		}
+		inventory := lwazure.Run(opts)
+		helpers.WriteReport(output, inventory)
+		exitCode = helpers.ExitCode(inventory)
 	},
 }
 
@@ -21,4 +32,12 @@ func init() {
 	rootCmd.AddCommand(azureCmd)
 	azureCmd.Flags().StringP("ignore-subscriptions", "i", "", "Azure subscriptions to ignore")
 	azureCmd.Flags().BoolP("debug", "d", false, "Show Debug Logs")
+	azureCmd.Flags().String("output", "table", "Report format: table, json or csv")
+	azureCmd.Flags().String("output-file", "", "Write the report to a file instead of stdout")
+	azureCmd.Flags().String("states", "running", "VM lifecycle states to inventory: running, stopped or all")
+	azureCmd.Flags().String("include-tag", "", "Only inventory resources with one of these tags, key=value or key, comma separated")
+	azureCmd.Flags().String("exclude-tag", "", "Leave out resources with any of these tags, key=value or key, comma separated")
+	azureCmd.Flags().String("group-by-tag", "", "Break VMs and agentless resources down by the value of this tag key")
+	azureCmd.Flags().Int("image-lookback-days", 30, "Count container images pushed to Azure Container Registry within this many days")
+	azureCmd.Flags().Bool("hosts", false, "Record every agent VM and its image in the report, for agent-support")
 }
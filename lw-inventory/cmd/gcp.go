@@ -13,10 +13,21 @@ var gcpCmd = &cobra.Command{
 	Long:  `Grab GCP Inventory`,
 	Run: func(cmd *cobra.Command, args []string) {
 		//zones := lwgcp.ParseZones(cmd)
-		projectsToIgnore := lwgcp.ParseProjectsToIgnore(cmd)
-		credentials := lwgcp.ParseCredentials(cmd)
-		debug := helpers.ParseDebug(cmd)
-		lwgcp.Run(projectsToIgnore, credentials, debug)
+		output := helpers.ParseOutput(cmd)
+		opts := lwgcp.Options{
+			ProjectsToIgnore:  lwgcp.ParseProjectsToIgnore(cmd),
+			Credentials:       lwgcp.ParseCredentials(cmd),
+			Debug:             helpers.ParseDebug(cmd),
+			Hosts:             helpers.ParseHosts(cmd),
+			States:            helpers.ParseStates(cmd),
+			Tags:              helpers.ParseTagFilter(cmd),
+			GroupBy:           helpers.ParseGroupByTag(cmd),
+			ImageLookbackDays: helpers.ParseImageLookback(cmd),
+			Progress:          output.Progress(),
+		}
+		inventory := lwgcp.Run(opts)
+		helpers.WriteReport(output, inventory)
+		exitCode = helpers.ExitCode(inventory)
 	},
 }
 
@@ -26,4 +37,12 @@ func init() {
 	gcpCmd.Flags().StringP("projects-to-ignore", "i", "", "GCP projects to ignore")
 	gcpCmd.Flags().StringP("credentials", "c", "", "Path to GCP credentials file") //may add back in if need to support custom location
 	gcpCmd.Flags().BoolP("debug", "d", false, "Show Debug Logs")
+	gcpCmd.Flags().String("output", "table", "Report format: table, json or csv")
+	gcpCmd.Flags().String("output-file", "", "Write the report to a file instead of stdout")
+	gcpCmd.Flags().String("states", "running", "VM lifecycle states to inventory: running, stopped or all")
+	gcpCmd.Flags().String("include-tag", "", "Only inventory resources with one of these labels, key=value or key, comma separated")
+	gcpCmd.Flags().String("exclude-tag", "", "Leave out resources with any of these labels, key=value or key, comma separated")
+	gcpCmd.Flags().String("group-by-tag", "", "Break VMs and agentless resources down by the value of this label key")
+	gcpCmd.Flags().Int("image-lookback-days", 30, "Count container images pushed to Artifact Registry within this many days")
+	gcpCmd.Flags().Bool("hosts", false, "Record every agent VM and its image in the report, for agent-support")
 }
@@ -3,11 +3,14 @@ package lwaws
 import (
 	"context"
 	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
 	"strings"
-	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	"github.com/aws/aws-sdk-go-v2/service/ecs"
 	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancing"
@@ -16,9 +19,13 @@ import (
 	ec2Types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
 	ecsTypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
 	"github.com/aws/aws-sdk-go-v2/service/eks"
+	"github.com/aws/aws-sdk-go-v2/service/organizations"
+	orgTypes "github.com/aws/aws-sdk-go-v2/service/organizations/types"
 	"github.com/aws/aws-sdk-go-v2/service/rds"
 	"github.com/aws/aws-sdk-go-v2/service/redshift"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
 	"github.com/lacework-dev/scripts/lw-inventory/helpers"
+	"github.com/lacework-dev/scripts/lw-inventory/report"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
@@ -39,6 +46,13 @@ const (
 	EKS_FARGATE_ACTIVE_PROFILES = "EKS Fargate Active Profiles"
 	ENTERPRISE_AGENT            = "Enterprise Agent"
 	STANDARD_AGENT              = "Standard Agent"
+	retryMaxAttempts            = 10
+	//DescribeInstanceTypes takes at most 100 instance types per call
+	instanceTypesBatchSize = 100
+	//ECS Describe calls take at most 100 clusters, tasks or container instances
+	ecsDescribeBatchSize = 100
+	//ELB DescribeTags takes at most 20 load balancers per call
+	elbTagsBatchSize = 20
 )
 
 type AgentlessServiceCount struct {
@@ -54,778 +68,1027 @@ type AgentContainerCount struct {
 }
 
 type VMInfo struct {
-	Region    string
-	AMI       string
+	Region       string
+	InstanceID   string
+	AMI          string
+	ImageName    string
+	AccountId    string
+	AgentType    string
+	OS           string
+	InstanceType string
+	VCPU         int
+	//State is running or stopped
+	State string
+	Tags  map[string]string
+	//OSFamily is linux, windows or other, Distribution the Linux
+	//distribution, Architecture x86_64, arm64 or i386
+	OSFamily     string
+	Distribution string
+	Architecture string
+	//EKSCluster is set for the nodes of a Kubernetes cluster, EKSNodeGroup
+	//for the nodes of a managed node group
+	EKSCluster   string
+	EKSNodeGroup string
+}
+
+type OSCounts = report.OSCounts
+
+// OrgOptions controls scanning every member account of an AWS Organization
+// from a management account profile
+type OrgOptions struct {
+	Enabled         bool
+	Role            string
+	ExternalID      string
+	RoleSessionName string
+}
+
+// scanTarget is a single set of credentials to inventory, either a profile or
+// an organization member account reached through the cross-account role
+type scanTarget struct {
+	Label     string
+	Name      string
 	AccountId string
-	AgentType string
-	OS        string
+	Partition string
+	Config    aws.Config
+	//labels of other targets that resolved to the same account
+	Merged []string
 }
 
-type OSCounts struct {
-	Windows int
-	Linux   int
+type SkippedAccount struct {
+	AccountId string
+	Name      string
+	Reason    string
+}
+
+// Options are the settings of an AWS inventory scan
+type Options struct {
+	Profiles    []string
+	Credentials CredentialOptions
+	//partition of the profiles, empty to detect it from each profile
+	Partition      string
+	Regions        []string
+	ExcludeRegions []string
+	Debug          bool
+	K8sTags        []string
+	Org            OrgOptions
+	Concurrency    int
+	Services       ServiceFilter
+	//estimate a vCPU equivalent for Lambda functions from their memory
+	LambdaVCPUs bool
+	//record every agent VM and its AMI in the report
+	Hosts bool
+	//VM lifecycle states to inventory: running, stopped or all
+	States string
+	Tags   helpers.TagFilter
+	//tag key to break VMs and agentless resources down by
+	GroupBy string
+	//container images pushed within this many days are counted
+	ImageLookbackDays int
+	//progress and summary text, stdout when nil
+	Progress io.Writer
 }
 
-func Run(profiles []string, regions []string, debug bool, k8sTags []string) {
-	if debug {
+func Run(ctx context.Context, opts Options) *report.Report {
+	profiles, regions, k8sTags, org := opts.Profiles, opts.Regions, opts.K8sTags, opts.Org
+	if opts.Debug {
 		log.SetLevel(log.DebugLevel)
 	}
+	scheduler := helpers.NewScheduler(opts.Concurrency)
+	out := opts.Progress
+	if out == nil {
+		out = os.Stdout
+	}
+	enabledCounters := opts.Services.Counters()
+
+	inventory := report.New("aws")
+	inventory.Metadata.Options["profiles"] = strings.Join(profiles, ",")
+	if len(regions) > 0 {
+		inventory.Metadata.Options["regions"] = strings.Join(regions, ",")
+	}
+	if len(opts.ExcludeRegions) > 0 {
+		inventory.Metadata.Options["exclude-regions"] = strings.Join(opts.ExcludeRegions, ",")
+	}
+	if len(opts.Services.Only) > 0 {
+		inventory.Metadata.Options["only-services"] = strings.Join(opts.Services.Only, ",")
+	}
+	if len(opts.Services.Skip) > 0 {
+		inventory.Metadata.Options["skip-services"] = strings.Join(opts.Services.Skip, ",")
+	}
+	if org.Enabled {
+		inventory.Metadata.Options["org-role"] = org.Role
+	}
+	opts.Credentials.SetOptions(inventory.Metadata.Options)
+	if opts.Partition != "" {
+		inventory.Metadata.Options["partition"] = opts.Partition
+	}
+	inventory.Metadata.Options["states"] = opts.States
+	if opts.Services.Enabled(ECR_IMAGES) {
+		inventory.Metadata.Options["image-lookback-days"] = strconv.Itoa(opts.ImageLookbackDays)
+	}
+	opts.Tags.SetOptions(inventory.Metadata.Options)
+	if opts.GroupBy != "" {
+		inventory.Metadata.Options["group-by-tag"] = opts.GroupBy
+	}
+	if opts.Tags.Enabled() || opts.GroupBy != "" {
+		untagged := untaggedServices(opts.Services)
+		inventory.Metadata.Options["tags-unavailable"] = strings.Join(untagged, ",")
+		fmt.Fprintln(out, "Tags aren't available for", strings.Join(untagged, ", "))
+	}
 
-	fmt.Println("Beginning Scan")
-	fmt.Printf("Profiles to use: %s\n", profiles)
+	fmt.Fprintln(out, "Beginning Scan")
+	fmt.Fprintf(out, "Profiles to use: %s\n", profiles)
 
-	totalResources := 0
-	var totalStandardAgentOSCount OSCounts
-	var totalEnterpriseAgentOSCount OSCounts
+	allTotals := newTotals()
 	totalAccounts := 0
 
-	//loop over all profiles and get counts
+	var targets []scanTarget
+	var skippedAccounts []SkippedAccount
 	for _, p := range profiles {
-		var agentlessCounts []AgentlessServiceCount
-		var ec2VMInfo []VMInfo
-		var ECSVMInfo []VMInfo
-		var agentContainers []AgentContainerCount
-		fmt.Println("Using profile", p)
-
-		cfg := getSession(p, "us-east-1")
-
-		if len(regions) == 0 {
-			regions = getRegions(*cfg)
-		}
-		fmt.Printf("Scanning regions: %s\n", regions)
-
-		agentlessCounts = getAgentlessCounts(p, regions)
-		ec2VMInfo = getAgentVMCounts(p, regions, k8sTags)
-		ECSVMInfo = getECSVMCounts(p, regions)
-		agentContainers = getAgentContainerCounts(p, regions)
-
-		//run through each region
-		agentlessResourceCount := 0
-		agentContainerCount := make(map[string]int)
-		var standardAgents []string
-		var enterpriseAgents []string
-		agentlessServices := []string{RDS, REDSHIFT, ELBv1, ELBv2, NATGATEWAY, ECS}
-		agentServices := []string{ECS_TASKS, FARGATE_RUNNING_TASKS, FARGATE_RUNNING_CONTAINERS, FARGATE_TOTAL_CONTAINERS, FARGATE_ACTIVE_SERVICES, EKS_FARGATE_ACTIVE_PROFILES}
-		var enterpriseAgentOSCounts OSCounts
-		var standardAgentOSCounts OSCounts
-
-		for _, r := range regions {
-			agentlessCountByRegion := 0
-
-			log.Debugln("Region ", r)
-			for _, s := range agentlessServices {
-				agentlessCountByRegion += getAgentlessCountByService(agentlessCounts, r, s)
+		cfg, err := getSession(ctx, p, opts.Partition, opts.Credentials)
+		if err != nil {
+			log.Errorln("Unable to use profile", p, err)
+			inventory.AddFailure(p, "", "Credentials", err)
+			continue
+		}
+		cfg, identity, err := findCallerIdentity(ctx, cfg, opts.Partition)
+		if err != nil {
+			log.Errorln("Unable to get account for profile", p, err)
+			inventory.AddFailure(p, "", "STS", err)
+			continue
+		}
+		partition, err := arnPartition(aws.ToString(identity.Arn))
+		if err != nil {
+			log.Errorln("Unable to get partition for profile", p, err)
+			inventory.AddFailure(p, "", "STS", err)
+			continue
+		}
+		if opts.Partition != "" && partition != opts.Partition {
+			log.Warnf("profile %s is in partition %s, not %s", p, partition, opts.Partition)
+		}
+		cfg.Region = bootstrapRegion(partition, cfg.Region)
+		if org.Enabled {
+			fmt.Fprintln(out, "Listing organization accounts with profile", p)
+			orgTargets, skipped, err := getOrgTargets(ctx, scheduler, newClients(cfg).Organizations, cfg, identity, partition, org)
+			if err != nil {
+				log.Errorln("Unable to list organization accounts with profile", p, err)
+				inventory.AddFailure(p, "", "Organizations", err)
 			}
-
-			agentlessResourceCount += agentlessCountByRegion
-
-			for _, s := range agentServices {
-				agentContainerCount[s] += getAgentCountByService(agentContainers, r, s)
+			targets = append(targets, orgTargets...)
+			skippedAccounts = append(skippedAccounts, skipped...)
+		} else {
+			targets = append(targets, scanTarget{Label: "profile " + p, Name: p, AccountId: *identity.Account, Partition: partition, Config: cfg})
+		}
+	}
+	targets = mergeTargets(targets)
+
+	//loop over all profiles and accounts and get counts
+	for i, t := range targets {
+		if ctx.Err() != nil {
+			log.Errorln("Scan stopped before", t.Label, ctx.Err())
+			for _, t := range targets[i:] {
+				inventory.AddFailure(t.AccountId, "", "", ctx.Err())
 			}
+			break
 		}
 
-		//get a list of AMIs to compare against
-		var ECSAMIS []string
-		for _, vm := range ECSVMInfo {
-			ECSAMIS = append(ECSAMIS, vm.AMI)
+		fmt.Fprintln(out, "Using", t.Label)
+
+		account := inventory.Account(t.AccountId, t.Name)
+		account.Partition = t.Partition
+		//counted even when its scans fail, the failures flag it
+		totalAccounts++
+		//opt-in regions differ between accounts, so each one gets its own list
+		//discovery lists the enabled regions of the account for patterns and
+		//exclusions, plain --region names skip it and are only checked
+		//against the account's partition, a region that isn't enabled fails
+		//its scans
+		var targetRegions []string
+		if needsRegionDiscovery(regions, opts.ExcludeRegions) {
+			discovered, err := getRegions(ctx, newClients(t.Config).EC2)
+			if err != nil {
+				log.Errorln("Unable to list regions for", t.Label, err)
+				inventory.AddFailure(t.AccountId, "", "Regions", err)
+				continue
+			}
+			targetRegions = filterRegions(discovered, regions, opts.ExcludeRegions)
+		} else {
+			var otherRegions []string
+			targetRegions, otherRegions = partitionRegions(regions, t.Partition)
+			if len(otherRegions) > 0 {
+				log.Warnf("Skipping regions %s, %s is in partition %s", strings.Join(otherRegions, ","), t.Label, t.Partition)
+			}
+		}
+		account.RegionsScanned = targetRegions
+		fmt.Fprintf(out, "Scanning regions: %s\n", targetRegions)
+
+		//counters filter and group by tag through the tag scan
+		tags := newTagScan(opts.Tags, opts.GroupBy)
+		scans := regionScans(t.Config, targetRegions, RegionScan{Tags: tags, K8sTags: k8sTags, ImageCutoff: helpers.ImageCutoff(opts.ImageLookbackDays)})
+		var failures []ScanFailure
+		//ECS and EKS counters share one crawl and one cluster listing of each
+		//region, which report their own failures
+		if opts.Services.ECS() {
+			failures = append(failures, crawlECSRegions(ctx, scheduler, scans)...)
+		}
+		if opts.Services.EKS() {
+			failures = append(failures, listEKSClusterRegions(ctx, scheduler, scans)...)
+		}
+		serviceCounts, results, f := scanRegions(ctx, scheduler, scans, enabledCounters)
+		failures = append(failures, f...)
+		for _, f := range failures {
+			inventory.AddFailure(t.AccountId, f.Region, f.Service, f.Err)
 		}
 
-		var cleanVMs []VMInfo
-
-		for _, vm := range ec2VMInfo {
-			if vm.AgentType == ENTERPRISE_AGENT {
-				cleanVMs = append(cleanVMs, vm)
-			} else {
-				if helpers.Contains(ECSAMIS, vm.AMI) {
-					vm.AgentType = ENTERPRISE_AGENT
-					cleanVMs = append(cleanVMs, vm)
-				} else {
-					vm.AgentType = STANDARD_AGENT
-					cleanVMs = append(cleanVMs, vm)
-				}
+		totals := newTotals()
+		for _, r := range targetRegions {
+			account.Region(r)
+		}
+		for _, c := range serviceCounts {
+			log.Debugf("%s %s: %d\n", c.Region, c.Service, c.Count)
+			account.Region(c.Region).AddService(c.Service, c.Category, c.Count)
+			totals.Services[c.Service] += c.Count
+			//containers are counted separately from resources
+			if c.Category != report.CategoryContainer {
+				totals.Resources += c.Count
 			}
 		}
 
-		var accountIds []string
-		for _, vm := range cleanVMs {
-			if !helpers.Contains(accountIds, vm.AccountId) {
-				accountIds = append(accountIds, vm.AccountId)
+		for r, groups := range tags.tagGroups() {
+			for _, g := range groups {
+				account.Region(r).AddTagGroup(g)
+				totals.TagGroups = report.AddTagGroup(totals.TagGroups, g)
 			}
 		}
 
-		for _, vm := range cleanVMs {
-			agentlessResourceCount++
-			if vm.AgentType == STANDARD_AGENT {
-				if vm.OS == "Linux/UNIX" {
-					standardAgentOSCounts.Linux++
-				} else {
-					standardAgentOSCounts.Windows++
-				}
-			} else {
-				if vm.OS == "Linux/UNIX" {
-					enterpriseAgentOSCounts.Linux++
-				} else {
-					enterpriseAgentOSCounts.Windows++
-				}
+		results.VMs = reconcileEKSNodes(classifyVMs(filterVMTags(filterVMStates(results.VMs, opts.States), opts.Tags), results.ECSVMs), results.EKSClusters)
+		for _, c := range enabledCounters {
+			if c.Report != nil {
+				c.Report(account, results, totals, opts)
 			}
 		}
+		allTotals.add(totals)
 
-		totalResources += agentlessResourceCount
-		totalStandardAgentOSCount.Linux += standardAgentOSCounts.Linux
-		totalStandardAgentOSCount.Windows += standardAgentOSCounts.Windows
-		totalEnterpriseAgentOSCount.Linux += enterpriseAgentOSCounts.Linux
-		totalEnterpriseAgentOSCount.Windows += enterpriseAgentOSCounts.Windows
-		totalAccounts += len(accountIds)
+		fmt.Fprintln(out, "----------------------------------------------")
+		fmt.Fprintf(out, "Totals for account %s (%s)\n", t.AccountId, strings.Join(append([]string{t.Label}, t.Merged...), ", "))
+		printTotals(out, totals, enabledCounters, opts)
 
-		fmt.Println("----------------------------------------------")
-		fmt.Println("Totals for profile", p)
-		fmt.Printf("Total Resources  %d\n", agentlessResourceCount)
-		fmt.Printf("Standard Agent VMs: %d\n", len(standardAgents))
-		fmt.Printf("Enterprise Agent VMs: %d\n", len(enterpriseAgents))
-		for s, c := range agentContainerCount {
-			fmt.Printf("%s: %d\n", s, c)
+		if len(failures) > 0 {
+			fmt.Fprintf(out, "Totals for %s are incomplete, %d scan(s) failed\n", t.Label, len(failures))
 		}
-
-		fmt.Println("\nVM OS Counts")
-		fmt.Printf("Standard Agent Linux VMs %d\n", standardAgentOSCounts.Linux)
-		fmt.Printf("Standard Agent Windows VMs %d\n", standardAgentOSCounts.Windows)
-		fmt.Printf("Enterprise Agent Linux VMs %d\n", enterpriseAgentOSCounts.Linux)
-		fmt.Printf("Enterprise Agent Windows VMs %d\n", enterpriseAgentOSCounts.Windows)
-
-		fmt.Println("\nNumber of AWS Accounts inventoried:", len(accountIds))
-		fmt.Println("----------------------------------------------")
+		fmt.Fprintln(out, "----------------------------------------------")
 	}
 
-	fmt.Println("----------------------------------------------")
-	fmt.Println("Totals for all profiles")
-	fmt.Printf("Total Resources  %d\n", totalResources)
-	fmt.Printf("Standard Agent VMs: %d\n", totalStandardAgentOSCount.Linux+totalStandardAgentOSCount.Windows)
-	fmt.Printf("Enterprise Agent VMs: %d\n", totalEnterpriseAgentOSCount.Linux+totalEnterpriseAgentOSCount.Windows)
-
-	fmt.Println("\nVM OS Counts")
-	fmt.Printf("Standard Agent Linux VMs %d\n", totalStandardAgentOSCount.Linux)
-	fmt.Printf("Standard Agent Windows VMs %d\n", totalStandardAgentOSCount.Windows)
-	fmt.Printf("Enterprise Agent Linux VMs %d\n", totalEnterpriseAgentOSCount.Linux)
-	fmt.Printf("Enterprise Agent Windows VMs %d\n", totalEnterpriseAgentOSCount.Windows)
+	fmt.Fprintln(out, "----------------------------------------------")
+	fmt.Fprintln(out, "Totals for all accounts")
+	printTotals(out, allTotals, enabledCounters, opts)
 
-	fmt.Println("\nNumber of AWS Accounts inventoried:", totalAccounts)
-	fmt.Println("----------------------------------------------")
+	fmt.Fprintln(out, "\nNumber of AWS Accounts inventoried:", totalAccounts)
+	fmt.Fprintln(out, "----------------------------------------------")
 
-}
-
-func getSession(profile string, region string) *aws.Config {
-	cfg, err := config.LoadDefaultConfig(context.TODO(),
-		config.WithRegion(region),
-		config.WithSharedConfigProfile(profile),
-		//config.WithDefaultsMode(aws.DefaultsModeAuto),
-	)
-	if err != nil {
-		log.Errorln("Error connecting to AWS", err)
+	if org.Enabled {
+		fmt.Fprintln(out, "\nSkipped organization accounts:", len(skippedAccounts))
+		for _, s := range skippedAccounts {
+			fmt.Fprintf(out, "%s (%s): %s\n", s.Name, s.AccountId, s.Reason)
+			inventory.Skipped = append(inventory.Skipped, report.Skipped{ID: s.AccountId, Name: s.Name, Reason: s.Reason})
+		}
+		fmt.Fprintln(out, "----------------------------------------------")
 	}
+	helpers.PrintFailures(out, inventory)
 
-	return &cfg
+	inventory.Finish()
+	return inventory
 }
 
-func getRegions(cfg aws.Config) []string {
-	service := ec2.NewFromConfig(cfg)
-	var regions []string
-	regionsResponse, err := service.DescribeRegions(context.TODO(), &ec2.DescribeRegionsInput{
-		AllRegions: aws.Bool(true),
-	})
+func printOSCounts(out io.Writer, standard OSCounts, enterprise OSCounts) {
+	fmt.Fprintln(out, "\nVM OS Counts")
+	fmt.Fprintf(out, "Standard Agent Linux VMs %d\n", standard.Linux)
+	fmt.Fprintf(out, "Standard Agent Windows VMs %d\n", standard.Windows)
+	fmt.Fprintf(out, "Standard Agent Other VMs %d\n", standard.Other)
+	fmt.Fprintf(out, "Enterprise Agent Linux VMs %d\n", enterprise.Linux)
+	fmt.Fprintf(out, "Enterprise Agent Windows VMs %d\n", enterprise.Windows)
+	fmt.Fprintf(out, "Enterprise Agent Other VMs %d\n", enterprise.Other)
+}
 
-	if err != nil {
-		fmt.Println(err)
-	} else {
-		awsRegions := regionsResponse.Regions
-		for _, r := range awsRegions {
-			//make sure not to add regions that are disabled
-			if *r.OptInStatus != "not-opted-in" {
-				n := *r.RegionName
-				regions = append(regions, n)
-			} else {
-				log.Debugln("Region skipped", *r.RegionName)
-			}
-		}
-	}
+func printVCPUs(out io.Writer, standard OSCounts, enterprise OSCounts) {
+	fmt.Fprintln(out, "\nVM vCPU Counts")
+	fmt.Fprintf(out, "Total vCPUs: %d\n", standard.Total()+enterprise.Total())
+	fmt.Fprintf(out, "Standard Agent vCPUs: %d\n", standard.Total())
+	fmt.Fprintf(out, "Enterprise Agent vCPUs: %d\n", enterprise.Total())
+	fmt.Fprintf(out, "Standard Agent Linux vCPUs %d\n", standard.Linux)
+	fmt.Fprintf(out, "Standard Agent Windows vCPUs %d\n", standard.Windows)
+	fmt.Fprintf(out, "Standard Agent Other vCPUs %d\n", standard.Other)
+	fmt.Fprintf(out, "Enterprise Agent Linux vCPUs %d\n", enterprise.Linux)
+	fmt.Fprintf(out, "Enterprise Agent Windows vCPUs %d\n", enterprise.Windows)
+	fmt.Fprintf(out, "Enterprise Agent Other vCPUs %d\n", enterprise.Other)
+}
 
-	return regions
+func printFargate(out io.Writer, fargate FargateSizing) {
+	fmt.Fprintln(out, "\nFargate Task Sizing")
+	fmt.Fprintf(out, "Fargate vCPUs: %.2f\n", fargate.VCPUs())
+	fmt.Fprintf(out, "Fargate CPU Units: %d\n", fargate.CPUUnits)
+	fmt.Fprintf(out, "Fargate Memory (MiB): %d\n", fargate.MemoryMB)
 }
 
-func getAgentlessCountByService(counts []AgentlessServiceCount, region string, service string) int {
-	for _, i := range counts {
-		if i.Service == service && i.Region == region {
-			log.Debugf("%s: %d\n", service, i.Count)
-			return i.Count
-		}
+func printLambda(out io.Writer, functions int, vcpus float64, showVCPUs bool) {
+	fmt.Fprintf(out, "\nLambda Functions: %d\n", functions)
+	if showVCPUs {
+		fmt.Fprintf(out, "Lambda vCPU equivalent (memory / %d MB): %.2f\n", LAMBDA_MB_PER_VCPU, vcpus)
 	}
-	return 0
 }
 
-func getAgentCountByService(counts []AgentContainerCount, region string, containerType string) int {
-	for _, i := range counts {
-		if i.ContainerType == containerType && i.Region == region {
-			// log.Debugf("%s: %d\n", containerType, i.Count)
-			return i.Count
+// mergeTargets keeps one target per account ID, so profiles that resolve to
+// the same account (e.g. an SSO and an IAM user profile) are only counted once
+func mergeTargets(targets []scanTarget) []scanTarget {
+	var merged []scanTarget
+	seen := make(map[string]int)
+	for _, t := range targets {
+		if i, ok := seen[t.AccountId]; ok {
+			log.Warnf("%s and %s are both account %s, scanning it once", merged[i].Label, t.Label, t.AccountId)
+			merged[i].Merged = append(merged[i].Merged, t.Label)
+			continue
 		}
+		seen[t.AccountId] = len(merged)
+		merged = append(merged, t)
 	}
-	return 0
+	return merged
 }
 
-func getAgentlessCounts(profile string, regions []string) []AgentlessServiceCount {
-	log.Debugf("start getAgentlessCounts\n")
-	start := time.Now()
+// getOrgTargets lists the accounts of the organization the management config
+// belongs to and assumes the cross-account role into each active member, the
+// assumes run on the scheduler and the targets keep the listing order
+func getOrgTargets(ctx context.Context, scheduler *helpers.Scheduler, service OrganizationsAPI, cfg aws.Config, identity *sts.GetCallerIdentityOutput, partition string, org OrgOptions) ([]scanTarget, []SkippedAccount, error) {
+	var targets []scanTarget
+	var skipped []SkippedAccount
 
-	numFuncs := 0
-	channel := make(chan AgentlessServiceCount)
-	var serviceCountList []AgentlessServiceCount
-	for _, r := range regions {
-		cfg := getSession(profile, r)
-		//rds instances
-		numFuncs += 1
-		go func(r string) {
-			channel <- getRDSInstanceCountByRegion(*cfg, r)
-		}(r)
+	managementAccountId := *identity.Account
 
-		//redshift instances
-		numFuncs += 1
-		go func(r string) {
-			channel <- getRedshiftInstanceCountByRegion(*cfg, r)
-		}(r)
+	var members []orgTypes.Account
+	output := organizations.NewListAccountsPaginator(service, &organizations.ListAccountsInput{})
+	for output.HasMorePages() {
+		page, err := output.NextPage(ctx)
+		if err != nil {
+			return targets, skipped, fmt.Errorf("ListAccounts: %w", err)
+		}
 
-		//elbv1 instances
-		numFuncs += 1
-		go func(r string) {
-			channel <- getELBv1InstanceCountByRegion(*cfg, r)
-		}(r)
+		for _, a := range page.Accounts {
+			accountId := aws.ToString(a.Id)
+			name := aws.ToString(a.Name)
 
-		// //elbv2 instances
-		numFuncs += 1
-		go func(r string) {
-			channel <- getELBv2InstanceCountByRegion(*cfg, r)
-		}(r)
+			if a.Status != orgTypes.AccountStatusActive {
+				skipped = append(skipped, SkippedAccount{AccountId: accountId, Name: name, Reason: "account status " + string(a.Status)})
+				continue
+			}
 
-		//NAT gateways
-		numFuncs += 1
-		go func(r string) {
-			channel <- getNatGatewayInstanceCountByRegion(*cfg, r)
-		}(r)
+			//the management account usually has no cross-account role, use it directly
+			if accountId == managementAccountId {
+				targets = append(targets, scanTarget{Label: orgLabel(a), Name: name, AccountId: accountId, Partition: partition, Config: cfg})
+				continue
+			}
+			members = append(members, a)
+		}
 	}
 
-	for i := 0; i < numFuncs; i++ {
-		count := <-channel
-		serviceCountList = append(serviceCountList, count)
+	tasks := make([]func(context.Context) orgMember, len(members))
+	for i, a := range members {
+		i, a := i, a
+		tasks[i] = func(ctx context.Context) orgMember {
+			roleArn := fmt.Sprintf("arn:%s:iam::%s:role/%s", partition, aws.ToString(a.Id), org.Role)
+			memberCfg, err := assumeRole(ctx, cfg, roleArn, org.ExternalID, org.RoleSessionName)
+			if err != nil {
+				log.Debugln("getOrgTargets AssumeRole ", roleArn, err)
+				err = fmt.Errorf("unable to assume %s: %w", roleArn, err)
+			}
+			return orgMember{Index: i, Config: memberCfg, Err: err}
+		}
+	}
+	assumed := make(map[int]orgMember)
+	for _, m := range helpers.Collect(ctx, scheduler, tasks) {
+		assumed[m.Index] = m
 	}
 
-	elapsed := time.Since(start)
-	log.Debugf("end getAgentlessCounts - %s\n", elapsed)
-	return serviceCountList
+	for i, a := range members {
+		accountId, name := aws.ToString(a.Id), aws.ToString(a.Name)
+		m, ok := assumed[i]
+		switch {
+		case !ok:
+			//never ran, the scan was stopped first
+			skipped = append(skipped, SkippedAccount{AccountId: accountId, Name: name, Reason: ctx.Err().Error()})
+		case m.Err != nil:
+			skipped = append(skipped, SkippedAccount{AccountId: accountId, Name: name, Reason: m.Err.Error()})
+		default:
+			targets = append(targets, scanTarget{Label: orgLabel(a), Name: name, AccountId: accountId, Partition: partition, Config: m.Config})
+		}
+	}
+
+	return targets, skipped, nil
 }
 
-func getAgentContainerCounts(profile string, regions []string) []AgentContainerCount {
-	log.Debugf("start getAgentContainerCounts\n")
-	start := time.Now()
+// orgMember is the outcome of assuming the cross-account role into one member
+type orgMember struct {
+	Index  int
+	Config aws.Config
+	Err    error
+}
 
-	numFuncs := 0
-	channel := make(chan AgentContainerCount)
-	var agentContainerList []AgentContainerCount
+func orgLabel(a orgTypes.Account) string {
+	return fmt.Sprintf("account %s (%s)", aws.ToString(a.Name), aws.ToString(a.Id))
+}
 
-	for _, r := range regions {
-		cfg := getSession(profile, r)
-		//ECS Tasks
-		numFuncs += 1
-		go func(r string) {
-			channel <- getECSTaskDefinitionsByRegion(*cfg, r)
-		}(r)
+// assumeRole returns a copy of cfg using credentials for roleArn, retrieving
+// them once up front so inaccessible accounts are caught before scanning.
+// Tests replace it to fail or succeed without STS
+var assumeRole = func(ctx context.Context, cfg aws.Config, roleArn string, externalId string, sessionName string) (aws.Config, error) {
+	provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), roleArn, func(o *stscreds.AssumeRoleOptions) {
+		o.RoleSessionName = sessionName
+		if externalId != "" {
+			o.ExternalID = aws.String(externalId)
+		}
+	})
 
-		//Running Fargate Tasks
-		numFuncs += 1
-		go func(r string) {
-			channel <- getECSFargateRunningTasksByRegion(*cfg, r)
-		}(r)
+	roleCfg := cfg.Copy()
+	roleCfg.Credentials = aws.NewCredentialsCache(provider)
+	if _, err := roleCfg.Credentials.Retrieve(ctx); err != nil {
+		return roleCfg, err
+	}
 
-		//Running Fargate Containers
-		numFuncs += 1
-		go func(r string) {
-			channel <- getECSFargateRunningContainersByRegion(*cfg, r)
-		}(r)
+	return roleCfg, nil
+}
 
-		//Total Fargate Containers
-		numFuncs += 1
-		go func(r string) {
-			channel <- getECSFargateTotalContainersByRegion(*cfg, r)
-		}(r)
+// filterVMStates keeps the instances in the lifecycle states to inventory
+func filterVMStates(vms []VMInfo, states string) []VMInfo {
+	var kept []VMInfo
+	for _, vm := range vms {
+		if helpers.IncludeState(states, vm.State) {
+			kept = append(kept, vm)
+		}
+	}
+	return kept
+}
 
-		numFuncs += 1
-		go func(r string) {
-			channel <- getEKSFargateActiveProfilesByRegion(*cfg, r)
-		}(r)
+// ec2State maps an instance state to running or stopped, pending instances
+// are about to run and stopping ones about to stop
+func ec2State(state *ec2Types.InstanceState) string {
+	if state == nil {
+		return ""
+	}
+	switch state.Name {
+	case ec2Types.InstanceStateNamePending, ec2Types.InstanceStateNameRunning:
+		return report.StateRunning
+	case ec2Types.InstanceStateNameStopping, ec2Types.InstanceStateNameStopped:
+		return report.StateStopped
+	}
+	return ""
+}
 
-		numFuncs += 1
-		go func(r string) {
-			channel <- getECSFargateActiveServicesByRegion(*cfg, r)
-		}(r)
+// classifyVMs marks EC2 instances that are ECS container instances as
+// enterprise agents, everything not already enterprise is a standard agent
+func classifyVMs(ec2VMInfo []VMInfo, ECSVMInfo []VMInfo) []VMInfo {
+	//instance IDs are only unique within a region
+	ecsInstances := make(map[string]bool)
+	for _, vm := range ECSVMInfo {
+		ecsInstances[vm.Region+"/"+vm.InstanceID] = true
 	}
 
-	for i := 0; i < numFuncs; i++ {
-		containers := <-channel
-		agentContainerList = append(agentContainerList, containers)
+	var cleanVMs []VMInfo
+	for _, vm := range ec2VMInfo {
+		if ecsInstances[vm.Region+"/"+vm.InstanceID] {
+			vm.AgentType = ENTERPRISE_AGENT
+		} else if vm.AgentType != ENTERPRISE_AGENT {
+			vm.AgentType = STANDARD_AGENT
+		}
+		cleanVMs = append(cleanVMs, vm)
 	}
 
-	elapsed := time.Since(start)
-	log.Debugf("end getAgentContainerCounts - %s\n", elapsed)
-
-	return agentContainerList
-}
-
-func getEKSFargateActiveProfilesByRegion(cfg aws.Config, region string) AgentContainerCount {
-	service := eks.NewFromConfig(cfg)
-	output := eks.NewListClustersPaginator(service, &eks.ListClustersInput{})
-
-	count := 0
-	for output.HasMorePages() {
-		page, err := output.NextPage(context.TODO())
-		if err != nil {
-			log.Errorln("getEKSFargateActiveProfilesByRegion ListFargateProfiles ", region, output, err)
+	return cleanVMs
+}
+
+// reportVMs adds the reconciled VMs to the regions of the account and to the
+// totals, by agent type, OS, state, platform and tag group
+func reportVMs(account *report.Account, results *ScanResults, totals *Totals, opts Options) {
+	for _, vm := range results.VMs {
+		totals.Resources++
+		region := account.Region(vm.Region)
+		region.AddService(EC2, report.CategoryAgent, 1)
+		region.States.Add(vm.State)
+		totals.States.Add(vm.State)
+		if opts.GroupBy != "" {
+			region.AddTagGroup(vmTagGroup(vm, opts.GroupBy))
+			totals.TagGroups = report.AddTagGroup(totals.TagGroups, vmTagGroup(vm, opts.GroupBy))
+		}
+		region.AddPlatform(vmPlatform(vm))
+		totals.Platforms = report.AddPlatform(totals.Platforms, vmPlatform(vm))
+		if opts.Hosts {
+			region.Hosts = append(region.Hosts, report.Host{ID: vm.InstanceID, AgentType: vm.AgentType, Family: vm.OSFamily, Image: vm.ImageName})
+		}
+		if vm.AgentType == STANDARD_AGENT {
+			addOS(&totals.StandardAgents, vm.OSFamily, 1)
+			addOS(&totals.StandardVCPUs, vm.OSFamily, vm.VCPU)
+			addOS(&region.Agents.Standard, vm.OSFamily, 1)
+			addOS(&region.VCPUs.Standard, vm.OSFamily, vm.VCPU)
 		} else {
-			for _, c := range page.Clusters {
-				output := eks.NewListFargateProfilesPaginator(service, &eks.ListFargateProfilesInput{ClusterName: &c})
-				for output.HasMorePages() {
-					page, err := output.NextPage(context.TODO())
-					if err != nil {
-						log.Errorln("getEKSFargateActiveProfilesByRegion ListFargateProfiles ", region, c, err)
-					} else {
-						count += len(page.FargateProfileNames)
-					}
-				}
-			}
+			addOS(&totals.EnterpriseAgents, vm.OSFamily, 1)
+			addOS(&totals.EnterpriseVCPUs, vm.OSFamily, vm.VCPU)
+			addOS(&region.Agents.Enterprise, vm.OSFamily, 1)
+			addOS(&region.VCPUs.Enterprise, vm.OSFamily, vm.VCPU)
 		}
 	}
+}
 
-	return AgentContainerCount{
-		Region:        region,
-		ContainerType: EKS_FARGATE_ACTIVE_PROFILES,
-		Count:         count,
-	}
+// getCallerIdentity calls STS in the region of cfg, tests replace it
+var getCallerIdentity = func(ctx context.Context, cfg aws.Config) (*sts.GetCallerIdentityOutput, error) {
+	return sts.NewFromConfig(cfg).GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
 }
 
-func getECSTaskDefinitionsByRegion(cfg aws.Config, region string) AgentContainerCount {
-	service := ecs.NewFromConfig(cfg)
-	output := ecs.NewListTaskDefinitionsPaginator(service, &ecs.ListTaskDefinitionsInput{})
+func regionConfig(cfg aws.Config, region string) aws.Config {
+	regionCfg := cfg.Copy()
+	regionCfg.Region = region
+	return regionCfg
+}
 
-	count := 0
-	for output.HasMorePages() {
-		page, err := output.NextPage(context.TODO())
-		if err != nil {
-			log.Errorln("getECSTaskDefinitionsByRegion ListTaskDefinitions ", region, err)
+func getRegions(ctx context.Context, service EC2API) ([]string, error) {
+	var regions []string
+	regionsResponse, err := service.DescribeRegions(ctx, &ec2.DescribeRegionsInput{
+		AllRegions: aws.Bool(true),
+	})
+	if err != nil {
+		return regions, fmt.Errorf("DescribeRegions: %w", err)
+	}
+
+	for _, r := range regionsResponse.Regions {
+		//make sure not to add regions that are disabled
+		if *r.OptInStatus != "not-opted-in" {
+			n := *r.RegionName
+			regions = append(regions, n)
 		} else {
-			count += len(page.TaskDefinitionArns)
+			log.Debugln("Region skipped", *r.RegionName)
 		}
 	}
 
-	return AgentContainerCount{
-		Region:        region,
-		ContainerType: ECS_TASKS,
-		Count:         count,
-	}
+	return regions, nil
 }
 
-func getECSFargateRunningTasksByRegion(cfg aws.Config, region string) AgentContainerCount {
-	service := ecs.NewFromConfig(cfg)
-	output := ecs.NewListClustersPaginator(service, &ecs.ListClustersInput{})
+// needsRegionDiscovery is false only when --region lists plain region names
+// and nothing is excluded, then the list is used as is
+func needsRegionDiscovery(include []string, exclude []string) bool {
+	if len(include) == 0 || len(exclude) > 0 {
+		return true
+	}
+	for _, r := range include {
+		if strings.HasPrefix(r, "!") || strings.ContainsAny(r, "*?[") {
+			return true
+		}
+	}
+	return false
+}
 
-	taskCount := 0
-	for output.HasMorePages() {
-		page, err := output.NextPage(context.TODO())
-		if err != nil {
-			log.Errorln("getECSFargateRunningTasksByRegion ListClusters ", region, err)
+// filterRegions applies the --region and --exclude-region glob patterns to the
+// regions enabled in an account. --region entries starting with ! exclude, and
+// without any other --region entries every enabled region is included
+func filterRegions(available []string, include []string, exclude []string) []string {
+	var patterns []string
+	excluded := append([]string{}, exclude...)
+	for _, r := range include {
+		if strings.HasPrefix(r, "!") {
+			excluded = append(excluded, strings.TrimPrefix(r, "!"))
 		} else {
-			for _, cluster := range page.ClusterArns {
-				output := ecs.NewListTasksPaginator(service, &ecs.ListTasksInput{
-					Cluster: &cluster,
-				})
-				for output.HasMorePages() {
-					page, err := output.NextPage(context.TODO())
-					if err != nil {
-						log.Errorln("getECSFargateRunningTasksByRegion ListTasks ", region, err)
-					} else {
-						taskCount += len(page.TaskArns)
-					}
-				}
-			}
+			patterns = append(patterns, r)
 		}
 	}
 
-	return AgentContainerCount{
-		Region:        region,
-		ContainerType: FARGATE_RUNNING_TASKS,
-		Count:         taskCount,
+	var regions []string
+	for _, r := range available {
+		if len(patterns) > 0 && !matchesRegion(patterns, r) {
+			continue
+		}
+		if matchesRegion(excluded, r) {
+			log.Debugln("Region excluded", r)
+			continue
+		}
+		regions = append(regions, r)
 	}
+	return regions
 }
 
-func getECSFargateActiveServicesByRegion(cfg aws.Config, region string) AgentContainerCount {
-	service := ecs.NewFromConfig(cfg)
-	output := ecs.NewListClustersPaginator(service, &ecs.ListClustersInput{})
+func matchesRegion(patterns []string, region string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, region); ok {
+			return true
+		}
+	}
+	return false
+}
 
-	count := 0
-	for output.HasMorePages() {
-		page, err := output.NextPage(context.TODO())
-		if err != nil {
-			log.Errorln("getECSFargateActiveServicesByRegion ListClusters ", region, err)
-		} else {
-			output, err := service.DescribeClusters(context.TODO(), &ecs.DescribeClustersInput{
-				Clusters: page.ClusterArns,
-			})
+// ScanFailure is a region and service that could not be fully counted
+type ScanFailure struct {
+	Region  string
+	Service string
+	Err     error
+}
 
-			if err != nil {
-				log.Errorln("getECSFargateActiveServicesByRegion DescribeClusters ", region, err)
-			} else {
-				for _, c := range output.Clusters {
-					count += int(c.ActiveServicesCount)
-				}
-			}
+// scanTask is one counter call, the unit of work that can fail on its own
+type scanTask[T any] struct {
+	Region  string
+	Service string
+	Scan    func(context.Context) (T, error)
+}
+
+// runScanTasks runs the tasks on the scheduler and returns every count along
+// with a failure for each task that errored or was cancelled before it ran
+func runScanTasks[T any](ctx context.Context, scheduler *helpers.Scheduler, tasks []scanTask[T]) ([]T, []ScanFailure) {
+	type result struct {
+		index int
+		value T
+		err   error
+	}
+
+	funcs := make([]func(context.Context) result, len(tasks))
+	for i, t := range tasks {
+		i, t := i, t
+		funcs[i] = func(ctx context.Context) result {
+			value, err := t.Scan(ctx)
+			return result{index: i, value: value, err: err}
 		}
 	}
 
-	return AgentContainerCount{
-		Region:        region,
-		ContainerType: FARGATE_ACTIVE_SERVICES,
-		Count:         count,
+	var values []T
+	var failures []ScanFailure
+	ran := make([]bool, len(tasks))
+	for _, r := range helpers.Collect(ctx, scheduler, funcs) {
+		ran[r.index] = true
+		//partial counts are kept, the failure flags them as a lower bound
+		values = append(values, r.value)
+		if r.err != nil {
+			failures = append(failures, ScanFailure{Region: tasks[r.index].Region, Service: tasks[r.index].Service, Err: r.err})
+		}
+	}
+	for i, t := range tasks {
+		if !ran[i] {
+			failures = append(failures, ScanFailure{Region: t.Region, Service: t.Service, Err: ctx.Err()})
+		}
 	}
-}
 
-func getECSFargateRunningContainersByRegion(cfg aws.Config, region string) AgentContainerCount {
-	service := ecs.NewFromConfig(cfg)
-	output := ecs.NewListClustersPaginator(service, &ecs.ListClustersInput{})
+	return values, failures
+}
 
-	taskCount := 0
-	for output.HasMorePages() {
-		page, err := output.NextPage(context.TODO())
-		if err != nil {
-			log.Errorln("getECSFargateRunningContainersByRegion ListClusters ", region, err)
-		} else {
-			for _, cluster := range page.ClusterArns {
-				output := ecs.NewListTasksPaginator(service, &ecs.ListTasksInput{
-					Cluster: &cluster,
-				})
-				for output.HasMorePages() {
-					page, err := output.NextPage(context.TODO())
-					if err != nil {
-						log.Errorln("getECSFargateRunningContainersByRegion ListTasks ", region, err)
-					} else {
-						if len(page.TaskArns) > 0 {
-							outputDT, err := service.DescribeTasks(context.TODO(), &ecs.DescribeTasksInput{
-								Cluster: &cluster,
-								Tasks:   page.TaskArns,
-							})
-							if err != nil {
-								log.Errorln("getECSFargateRunningContainersByRegion DescribeTasks ", region, err)
-							} else {
-								for _, t := range outputDT.Tasks {
-									if t.LaunchType == ecsTypes.LaunchTypeFargate && ecsTypes.DesiredStatus(*t.LastStatus) == ecsTypes.DesiredStatusRunning {
-										for _, c := range t.Containers {
-											if ecsTypes.DesiredStatus(*c.LastStatus) == ecsTypes.DesiredStatusRunning {
-												taskCount += 1
-											}
-										}
-									}
-								}
-							}
-						}
-					}
-				}
+func getEKSFargateActiveProfilesByRegion(ctx context.Context, service EKSAPI, region string, clusters []string) (AgentContainerCount, error) {
+	counts := AgentContainerCount{
+		Region:        region,
+		ContainerType: EKS_FARGATE_ACTIVE_PROFILES,
+	}
+	for _, c := range clusters {
+		output := eks.NewListFargateProfilesPaginator(service, &eks.ListFargateProfilesInput{ClusterName: aws.String(c)})
+		for output.HasMorePages() {
+			page, err := output.NextPage(ctx)
+			if err != nil {
+				log.Errorln("getEKSFargateActiveProfilesByRegion ListFargateProfiles ", region, c, err)
+				return counts, fmt.Errorf("ListFargateProfiles %s: %w", c, err)
 			}
+			counts.Count += len(page.FargateProfileNames)
 		}
 	}
 
-	return AgentContainerCount{
-		Region:        region,
-		ContainerType: FARGATE_RUNNING_CONTAINERS,
-		Count:         taskCount,
-	}
+	return counts, nil
 }
 
-func getECSFargateTotalContainersByRegion(cfg aws.Config, region string) AgentContainerCount {
-	service := ecs.NewFromConfig(cfg)
-	output := ecs.NewListClustersPaginator(service, &ecs.ListClustersInput{})
+func getECSTaskDefinitionsByRegion(ctx context.Context, service ECSAPI, region string) (AgentContainerCount, error) {
+	output := ecs.NewListTaskDefinitionsPaginator(service, &ecs.ListTaskDefinitionsInput{})
 
-	taskCount := 0
+	counts := AgentContainerCount{
+		Region:        region,
+		ContainerType: ECS_TASKS,
+	}
 	for output.HasMorePages() {
-		page, err := output.NextPage(context.TODO())
+		page, err := output.NextPage(ctx)
 		if err != nil {
-			log.Errorln("getECSFargateTotalContainersByRegion ListClusters ", region, err)
-		} else {
-			for _, cluster := range page.ClusterArns {
-				output := ecs.NewListTasksPaginator(service, &ecs.ListTasksInput{
-					Cluster: &cluster,
-				})
-				for output.HasMorePages() {
-					page, err := output.NextPage(context.TODO())
-					if err != nil {
-						log.Errorln("getECSFargateTotalContainersByRegion ListTasks ", region, err)
-					} else {
-						if len(page.TaskArns) > 0 {
-							outputDT, err := service.DescribeTasks(context.TODO(), &ecs.DescribeTasksInput{
-								Cluster: &cluster,
-								Tasks:   page.TaskArns,
-							})
-							if err != nil {
-								log.Errorln("getECSFargateTotalContainersByRegion DescribeTasks ", region, err)
-							} else {
-								for _, t := range outputDT.Tasks {
-									if t.LaunchType == ecsTypes.LaunchTypeFargate && ecsTypes.DesiredStatus(*t.LastStatus) == ecsTypes.DesiredStatusRunning {
-										taskCount += len(t.Containers)
-									}
-								}
-							}
-						}
-					}
-				}
-			}
+			log.Errorln("getECSTaskDefinitionsByRegion ListTaskDefinitions ", region, err)
+			return counts, fmt.Errorf("ListTaskDefinitions: %w", err)
 		}
+		counts.Count += len(page.TaskDefinitionArns)
 	}
 
-	return AgentContainerCount{
-		Region:        region,
-		ContainerType: FARGATE_TOTAL_CONTAINERS,
-		Count:         taskCount,
-	}
+	return counts, nil
 }
 
-func getAgentVMCounts(profile string, regions []string, k8sTags []string) []VMInfo {
-	log.Debugf("start getAgentVMCounts\n")
-	start := time.Now()
-
-	numFuncs := 0
-	channel := make(chan []VMInfo)
-	var serviceCountList []VMInfo
-	for _, r := range regions {
-		cfg := getSession(profile, r)
-		//EC2s
-		numFuncs += 1
-		go func(r string, tags []string) {
-			channel <- getEC2InstancesByRegion(*cfg, r, tags)
-		}(r, k8sTags)
+func getECSFargateRunningTasksByRegion(snapshot *ECSSnapshot) AgentContainerCount {
+	counts := AgentContainerCount{
+		Region:        snapshot.Region,
+		ContainerType: FARGATE_RUNNING_TASKS,
 	}
+	counts.Count = len(snapshot.Tasks)
 
-	for i := 0; i < numFuncs; i++ {
-		vms := <-channel
-		serviceCountList = append(serviceCountList, vms...)
-	}
+	return counts
+}
 
-	elapsed := time.Since(start)
-	log.Debugf("end getAgentVMCounts - %s\n", elapsed)
+func getECSFargateActiveServicesByRegion(snapshot *ECSSnapshot) AgentContainerCount {
+	counts := AgentContainerCount{
+		Region:        snapshot.Region,
+		ContainerType: FARGATE_ACTIVE_SERVICES,
+	}
+	for _, c := range snapshot.Clusters {
+		counts.Count += int(c.ActiveServicesCount)
+	}
 
-	return serviceCountList
+	return counts
 }
 
-func getECSVMCounts(profile string, regions []string) []VMInfo {
-	log.Debugf("start getECSVMCounts\n")
-	start := time.Now()
-
-	numFuncs := 0
-	channel := make(chan []VMInfo)
-	var serviceCountList []VMInfo
-	for _, r := range regions {
-		cfg := getSession(profile, r)
-		//ECS EC2s
-		numFuncs += 1
-		go func(r string) {
-			channel <- getECSVMCountByRegion(*cfg, r)
-		}(r)
+func getECSFargateRunningContainersByRegion(snapshot *ECSSnapshot) AgentContainerCount {
+	counts := AgentContainerCount{
+		Region:        snapshot.Region,
+		ContainerType: FARGATE_RUNNING_CONTAINERS,
 	}
-
-	for i := 0; i < numFuncs; i++ {
-		vms := <-channel
-		serviceCountList = append(serviceCountList, vms...)
+	for _, t := range snapshot.RunningFargateTasks() {
+		for _, c := range t.Containers {
+			if ecsTypes.DesiredStatus(aws.ToString(c.LastStatus)) == ecsTypes.DesiredStatusRunning {
+				counts.Count += 1
+			}
+		}
 	}
 
-	elapsed := time.Since(start)
-	log.Debugf("end getECSVMCounts - %s\n", elapsed)
-
-	return serviceCountList
+	return counts
 }
 
-func getECSVMCountByRegion(cfg aws.Config, region string) []VMInfo {
-	service := ecs.NewFromConfig(cfg)
-	var instances []VMInfo
-	output, err := service.ListClusters(context.TODO(), &ecs.ListClustersInput{})
+func getECSFargateTotalContainersByRegion(snapshot *ECSSnapshot) AgentContainerCount {
+	counts := AgentContainerCount{
+		Region:        snapshot.Region,
+		ContainerType: FARGATE_TOTAL_CONTAINERS,
+	}
+	for _, t := range snapshot.RunningFargateTasks() {
+		counts.Count += len(t.Containers)
+	}
 
-	if err != nil {
-		log.Errorln("getECSVMCountByRegion ListClusters ", region, err)
-	} else {
-		for _, cluster := range output.ClusterArns {
-			output, err := service.ListContainerInstances(context.TODO(), &ecs.ListContainerInstancesInput{
-				Cluster: &cluster,
-			})
+	return counts
+}
 
-			if err != nil {
-				log.Errorln("getECSVMCountByRegion ListContainerInstances ", region, err)
-			} else {
-				for _, cia := range output.ContainerInstanceArns {
-					output, err := service.DescribeContainerInstances(context.TODO(), &ecs.DescribeContainerInstancesInput{
-						Cluster:            &cluster,
-						ContainerInstances: []string{cia},
-					})
-
-					if err != nil {
-						log.Errorln("getECSVMCountByRegion DescribeContainerInstances ", region, err)
-					} else {
-						//log.Debugln("ECS EC2 Instances", output.ContainerInstances)
-						for _, i := range output.ContainerInstances {
-							log.Debugln("ECS VM ID", region, *i.Ec2InstanceId)
-							instances = append(instances, VMInfo{Region: region, AMI: *i.Ec2InstanceId, AgentType: ENTERPRISE_AGENT})
-						}
-					}
-				}
-			}
-		}
+func getECSVMCountByRegion(snapshot *ECSSnapshot) []VMInfo {
+	var instances []VMInfo
+	for _, i := range snapshot.ContainerInstances {
+		log.Debugln("ECS VM ID", snapshot.Region, aws.ToString(i.Ec2InstanceId))
+		instances = append(instances, VMInfo{Region: snapshot.Region, InstanceID: aws.ToString(i.Ec2InstanceId), AgentType: ENTERPRISE_AGENT})
 	}
 
 	return instances
 }
 
-func getEC2InstancesByRegion(cfg aws.Config, region string, k8sTags []string) []VMInfo {
-	service := ec2.NewFromConfig(cfg)
+func getEC2InstancesByRegion(ctx context.Context, service EC2API, region string, k8sTags []string) ([]VMInfo, error) {
 	output := ec2.NewDescribeInstancesPaginator(service, &ec2.DescribeInstancesInput{
-		Filters: []ec2Types.Filter{{Name: aws.String("instance-state-name"), Values: []string{"running", "pending", "stopped"}}},
+		Filters: []ec2Types.Filter{{Name: aws.String("instance-state-name"), Values: []string{"pending", "running", "stopping", "stopped"}}},
 	})
 
 	instances := []VMInfo{}
 	for output.HasMorePages() {
-
-		page, err := output.NextPage(context.TODO())
+		page, err := output.NextPage(ctx)
 		if err != nil {
 			log.Errorln("getEC2InstancesByRegion DescribeInstances ", region, err)
-		} else {
-			for _, res := range page.Reservations {
-				for _, i := range res.Instances {
-					agentType := STANDARD_AGENT
-					log.Debugln("Looking for user provided k8s tags", k8sTags)
-					for _, t := range i.Tags {
-						log.Debugf("Instance: %s, tag: %s", *i.InstanceId, *t.Key)
-						if helpers.Contains(k8sTags, *t.Key) {
-							log.Debugln("found EKS node")
-							agentType = ENTERPRISE_AGENT
-						}
-						//if *t.Key == "eks:cluster-name" || *t.Key == "aws:eks:cluster-name" {
-						//
-						//}
+			return instances, fmt.Errorf("DescribeInstances: %w", err)
+		}
+
+		for _, res := range page.Reservations {
+			for _, i := range res.Instances {
+				agentType := STANDARD_AGENT
+				log.Debugln("Looking for user provided k8s tags", k8sTags)
+				var eksCluster, eksNodeGroup string
+				for _, t := range i.Tags {
+					log.Debugf("Instance: %s, tag: %s", *i.InstanceId, *t.Key)
+					if helpers.Contains(k8sTags, *t.Key) {
+						log.Debugln("found EKS node")
+						agentType = ENTERPRISE_AGENT
+					}
+					if cluster, ok := eksClusterTag(aws.ToString(t.Key), aws.ToString(t.Value)); ok {
+						eksCluster = cluster
+					}
+					if aws.ToString(t.Key) == eksNodeGroupNameTag {
+						eksNodeGroup = aws.ToString(t.Value)
 					}
-					instances = append(instances, VMInfo{Region: region, AMI: *i.InstanceId, AgentType: agentType, OS: *i.PlatformDetails, AccountId: *res.OwnerId})
 				}
+				family, distribution := osFamily(aws.ToString(i.PlatformDetails), string(i.Architecture))
+				instances = append(instances, VMInfo{Region: region, InstanceID: *i.InstanceId, AMI: aws.ToString(i.ImageId), AgentType: agentType, OS: aws.ToString(i.PlatformDetails), AccountId: *res.OwnerId, InstanceType: string(i.InstanceType), VCPU: instanceVCPU(i), State: ec2State(i.State), EKSCluster: eksCluster, EKSNodeGroup: eksNodeGroup, Tags: ec2Tags(i.Tags), OSFamily: family, Distribution: distribution, Architecture: normalizeArchitecture(string(i.Architecture))})
 			}
 		}
 	}
 
-	return instances
+	if err := setInstanceTypeVCPUs(ctx, service, instances); err != nil {
+		log.Errorln("getEC2InstancesByRegion DescribeInstanceTypes ", region, err)
+		return instances, fmt.Errorf("DescribeInstanceTypes: %w", err)
+	}
+	if err := setImageDetails(ctx, service, instances); err != nil {
+		log.Errorln("getEC2InstancesByRegion DescribeImages ", region, err)
+		return instances, fmt.Errorf("DescribeImages: %w", err)
+	}
+
+	return instances, nil
+}
+
+// instanceVCPU is the vCPU count from the instance CPU options, 0 when the
+// options aren't reported
+func instanceVCPU(i ec2Types.Instance) int {
+	if i.CpuOptions == nil || i.CpuOptions.CoreCount == nil || i.CpuOptions.ThreadsPerCore == nil {
+		return 0
+	}
+	return int(*i.CpuOptions.CoreCount * *i.CpuOptions.ThreadsPerCore)
+}
+
+// setInstanceTypeVCPUs fills in the default vCPUs of the instance type for
+// instances without CPU options
+func setInstanceTypeVCPUs(ctx context.Context, service EC2API, instances []VMInfo) error {
+	var instanceTypes []ec2Types.InstanceType
+	seen := make(map[string]bool)
+	for _, vm := range instances {
+		if vm.VCPU == 0 && vm.InstanceType != "" && !seen[vm.InstanceType] {
+			seen[vm.InstanceType] = true
+			instanceTypes = append(instanceTypes, ec2Types.InstanceType(vm.InstanceType))
+		}
+	}
+
+	vcpus := make(map[string]int)
+	for start := 0; start < len(instanceTypes); start += instanceTypesBatchSize {
+		end := start + instanceTypesBatchSize
+		if end > len(instanceTypes) {
+			end = len(instanceTypes)
+		}
+
+		output := ec2.NewDescribeInstanceTypesPaginator(service, &ec2.DescribeInstanceTypesInput{InstanceTypes: instanceTypes[start:end]})
+		for output.HasMorePages() {
+			page, err := output.NextPage(ctx)
+			if err != nil {
+				return err
+			}
+			for _, t := range page.InstanceTypes {
+				if t.VCpuInfo != nil && t.VCpuInfo.DefaultVCpus != nil {
+					vcpus[string(t.InstanceType)] = int(*t.VCpuInfo.DefaultVCpus)
+				}
+			}
+		}
+	}
+
+	for i, vm := range instances {
+		if vm.VCPU == 0 {
+			instances[i].VCPU = vcpus[vm.InstanceType]
+		}
+	}
+	return nil
 }
 
-func getRDSInstanceCountByRegion(cfg aws.Config, region string) AgentlessServiceCount {
-	service := rds.NewFromConfig(cfg)
+func getRDSInstanceCountByRegion(ctx context.Context, service RDSAPI, region string, tags *tagScan) (AgentlessServiceCount, error) {
 	output := rds.NewDescribeDBInstancesPaginator(service, &rds.DescribeDBInstancesInput{})
 
-	instanceCount := 0
+	counts := AgentlessServiceCount{
+		Region:  region,
+		Service: RDS,
+	}
 	for output.HasMorePages() {
-		page, err := output.NextPage(context.TODO())
+		page, err := output.NextPage(ctx)
 		if err != nil {
 			log.Errorln("getRDSInstanceCountByRegion DescribeDBInstances ", region, err)
-		} else {
-			instanceCount += len(page.DBInstances)
+			return counts, fmt.Errorf("DescribeDBInstances: %w", err)
+		}
+		for _, db := range page.DBInstances {
+			if tags.keep(region, rdsTags(db.TagList)) {
+				counts.Count++
+			}
 		}
 	}
 
-	return AgentlessServiceCount{
-		Region:  region,
-		Service: RDS,
-		Count:   instanceCount,
-	}
+	return counts, nil
 }
 
-func getRedshiftInstanceCountByRegion(cfg aws.Config, region string) AgentlessServiceCount {
-	service := redshift.NewFromConfig(cfg)
+func getRedshiftInstanceCountByRegion(ctx context.Context, service RedshiftAPI, region string, tags *tagScan) (AgentlessServiceCount, error) {
 	output := redshift.NewDescribeClustersPaginator(service, &redshift.DescribeClustersInput{})
 
-	instanceCount := 0
+	counts := AgentlessServiceCount{
+		Region:  region,
+		Service: REDSHIFT,
+	}
 	for output.HasMorePages() {
-		page, err := output.NextPage(context.TODO())
+		page, err := output.NextPage(ctx)
 		if err != nil {
 			log.Errorln("getRedshiftInstanceCountByRegion DescribeClusters ", region, err)
-		} else {
-			instanceCount += len(page.Clusters)
+			return counts, fmt.Errorf("DescribeClusters: %w", err)
+		}
+		for _, c := range page.Clusters {
+			if tags.keep(region, redshiftTags(c.Tags)) {
+				counts.Count++
+			}
 		}
 	}
 
-	return AgentlessServiceCount{
-		Region:  region,
-		Service: REDSHIFT,
-		Count:   instanceCount,
-	}
+	return counts, nil
 }
 
-func getELBv1InstanceCountByRegion(cfg aws.Config, region string) AgentlessServiceCount {
-	service := elasticloadbalancing.NewFromConfig(cfg)
+func getELBv1InstanceCountByRegion(ctx context.Context, service ELBAPI, region string, tags *tagScan) (AgentlessServiceCount, error) {
 	output := elasticloadbalancing.NewDescribeLoadBalancersPaginator(service, &elasticloadbalancing.DescribeLoadBalancersInput{})
 
-	instanceCount := 0
+	counts := AgentlessServiceCount{
+		Region:  region,
+		Service: ELBv1,
+	}
 	for output.HasMorePages() {
-		page, err := output.NextPage(context.TODO())
+		page, err := output.NextPage(ctx)
 		if err != nil {
 			log.Errorln("getELBv1InstanceCountByRegion DescribeLoadBalancers ", region, err)
-		} else {
-			instanceCount += len(page.LoadBalancerDescriptions)
+			return counts, fmt.Errorf("DescribeLoadBalancers: %w", err)
+		}
+		//load balancers are listed without tags, they are only read when
+		//filtering or grouping needs them
+		if !tags.lookup() {
+			counts.Count += len(page.LoadBalancerDescriptions)
+			continue
 		}
-	}
 
-	return AgentlessServiceCount{
-		Region:  region,
-		Service: ELBv1,
-		Count:   instanceCount,
+		var names []string
+		for _, lb := range page.LoadBalancerDescriptions {
+			names = append(names, aws.ToString(lb.LoadBalancerName))
+		}
+		for start := 0; start < len(names); start += elbTagsBatchSize {
+			end := start + elbTagsBatchSize
+			if end > len(names) {
+				end = len(names)
+			}
+			output, err := service.DescribeTags(ctx, &elasticloadbalancing.DescribeTagsInput{LoadBalancerNames: names[start:end]})
+			if err != nil {
+				log.Errorln("getELBv1InstanceCountByRegion DescribeTags ", region, err)
+				return counts, fmt.Errorf("DescribeTags: %w", err)
+			}
+			for _, d := range output.TagDescriptions {
+				if tags.keep(region, elbTags(d.Tags)) {
+					counts.Count++
+				}
+			}
+		}
 	}
+
+	return counts, nil
 }
 
-func getELBv2InstanceCountByRegion(cfg aws.Config, region string) AgentlessServiceCount {
-	service := elasticloadbalancingv2.NewFromConfig(cfg)
+func getELBv2InstanceCountByRegion(ctx context.Context, service ELBv2API, region string, tags *tagScan) (AgentlessServiceCount, error) {
 	output := elasticloadbalancingv2.NewDescribeLoadBalancersPaginator(service, &elasticloadbalancingv2.DescribeLoadBalancersInput{})
 
-	instanceCount := 0
+	counts := AgentlessServiceCount{
+		Region:  region,
+		Service: ELBv2,
+	}
 	for output.HasMorePages() {
-		page, err := output.NextPage(context.TODO())
+		page, err := output.NextPage(ctx)
 		if err != nil {
 			log.Errorln("getELBv2InstanceCountByRegion DescribeLoadBalancers ", region, err)
-		} else {
-			instanceCount += len(page.LoadBalancers)
+			return counts, fmt.Errorf("DescribeLoadBalancers: %w", err)
+		}
+		if !tags.lookup() {
+			counts.Count += len(page.LoadBalancers)
+			continue
 		}
-	}
 
-	return AgentlessServiceCount{
-		Region:  region,
-		Service: ELBv2,
-		Count:   instanceCount,
+		var arns []string
+		for _, lb := range page.LoadBalancers {
+			arns = append(arns, aws.ToString(lb.LoadBalancerArn))
+		}
+		for start := 0; start < len(arns); start += elbTagsBatchSize {
+			end := start + elbTagsBatchSize
+			if end > len(arns) {
+				end = len(arns)
+			}
+			output, err := service.DescribeTags(ctx, &elasticloadbalancingv2.DescribeTagsInput{ResourceArns: arns[start:end]})
+			if err != nil {
+				log.Errorln("getELBv2InstanceCountByRegion DescribeTags ", region, err)
+				return counts, fmt.Errorf("DescribeTags: %w", err)
+			}
+			for _, d := range output.TagDescriptions {
+				if tags.keep(region, elbv2Tags(d.Tags)) {
+					counts.Count++
+				}
+			}
+		}
 	}
+
+	return counts, nil
 }
 
-func getNatGatewayInstanceCountByRegion(cfg aws.Config, region string) AgentlessServiceCount {
-	service := ec2.NewFromConfig(cfg)
+func getNatGatewayInstanceCountByRegion(ctx context.Context, service EC2API, region string, tags *tagScan) (AgentlessServiceCount, error) {
 	output := ec2.NewDescribeNatGatewaysPaginator(service, &ec2.DescribeNatGatewaysInput{})
 
-	instanceCount := 0
+	counts := AgentlessServiceCount{
+		Region:  region,
+		Service: NATGATEWAY,
+	}
 	for output.HasMorePages() {
-		page, err := output.NextPage(context.TODO())
+		page, err := output.NextPage(ctx)
 		if err != nil {
 			log.Errorln("getNatGatewayInstanceCountByRegion DescribeNatGateways ", region, err)
-		} else {
-			instanceCount += len(page.NatGateways)
+			return counts, fmt.Errorf("DescribeNatGateways: %w", err)
+		}
+		for _, n := range page.NatGateways {
+			if tags.keep(region, ec2Tags(n.Tags)) {
+				counts.Count++
+			}
 		}
 	}
 
-	return AgentlessServiceCount{
-		Region:  region,
-		Service: NATGATEWAY,
-		Count:   instanceCount,
-	}
+	return counts, nil
 }
 
 func ParseProfiles(cmd *cobra.Command) []string {
+	if profiles := parseAllProfiles(cmd); profiles != nil {
+		return profiles
+	}
 	profilesFlag := helpers.GetFlagEnvironmentString(cmd, "profile", "profile", "Missing Profile(s) to use", false)
 	var profiles []string
 	if profilesFlag != "" {
@@ -853,13 +1116,60 @@ func ParseTags(cmd *cobra.Command) []string {
 	return tags
 }
 
+func ParseOrg(cmd *cobra.Command) OrgOptions {
+	enabled, _ := cmd.Flags().GetBool("org")
+	return OrgOptions{
+		Enabled:         enabled,
+		Role:            helpers.GetFlagEnvironmentString(cmd, "org-role", "org-role", "Missing organization role to assume", enabled),
+		ExternalID:      helpers.GetFlagEnvironmentString(cmd, "external-id", "external-id", "", false),
+		RoleSessionName: parseRoleSessionName(cmd),
+	}
+}
+
 func ParseRegions(cmd *cobra.Command) []string {
-	regionsFlag := helpers.GetFlagEnvironmentString(cmd, "region", "region", "Missing Region(s) to use", false)
+	return parseRegionPatterns(cmd, "region")
+}
+
+func ParseLambdaVCPUs(cmd *cobra.Command) bool {
+	return helpers.GetFlagEnvironmentBool(cmd, "lambda-vcpus", "lambda-vcpus", false)
+}
+
+func ParseServices(cmd *cobra.Command) ServiceFilter {
+	filter := ServiceFilter{
+		Only: parseServiceNames(cmd, "only-services"),
+		Skip: parseServiceNames(cmd, "skip-services"),
+	}
+	if err := filter.Validate(); err != nil {
+		helpers.Bail("invalid service filter", err)
+	}
+	return filter
+}
+
+func parseServiceNames(cmd *cobra.Command, flag string) []string {
+	servicesFlag := helpers.GetFlagEnvironmentString(cmd, flag, flag, "", false)
+	var services []string
+	if servicesFlag != "" {
+		for _, s := range strings.Split(servicesFlag, ",") {
+			services = append(services, strings.ToLower(strings.TrimSpace(s)))
+		}
+	}
+	return services
+}
+
+func ParseExcludeRegions(cmd *cobra.Command) []string {
+	return parseRegionPatterns(cmd, "exclude-region")
+}
+
+func parseRegionPatterns(cmd *cobra.Command, flag string) []string {
+	regionsFlag := helpers.GetFlagEnvironmentString(cmd, flag, flag, "Missing Region(s) to use", false)
 	var regions []string
 	if regionsFlag != "" {
 		profilesTemp := strings.Split(regionsFlag, ",")
 		for _, p := range profilesTemp {
 			trimmed := strings.TrimSpace(p)
+			if _, err := path.Match(strings.TrimPrefix(trimmed, "!"), ""); err != nil {
+				helpers.Bail(fmt.Sprintf("invalid --%s pattern %q", flag, trimmed), err)
+			}
 			regions = append(regions, trimmed)
 		}
 	}
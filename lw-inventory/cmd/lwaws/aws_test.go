@@ -0,0 +1,566 @@
+package lwaws
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ec2Types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	ecsTypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	elbTypes "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancing/types"
+	elbv2Types "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+	orgTypes "github.com/aws/aws-sdk-go-v2/service/organizations/types"
+	rdsTypes "github.com/aws/aws-sdk-go-v2/service/rds/types"
+	redshiftTypes "github.com/aws/aws-sdk-go-v2/service/redshift/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/lacework-dev/scripts/lw-inventory/helpers"
+	"github.com/lacework-dev/scripts/lw-inventory/report"
+)
+
+var (
+	ctx             = context.Background()
+	errAccessDenied = errors.New("AccessDenied")
+)
+
+func instance(id string, platform string, tags ...string) ec2Types.Instance {
+	i := ec2Types.Instance{InstanceId: aws.String(id), ImageId: aws.String("ami-" + id), PlatformDetails: aws.String(platform)}
+	for _, t := range tags {
+		i.Tags = append(i.Tags, ec2Types.Tag{Key: aws.String(t), Value: aws.String("value")})
+	}
+	return i
+}
+
+func reservation(owner string, instances ...ec2Types.Instance) ec2Types.Reservation {
+	return ec2Types.Reservation{OwnerId: aws.String(owner), Instances: instances}
+}
+
+func fargateTask(lastStatus string, containerStatuses ...string) ecsTypes.Task {
+	t := ecsTypes.Task{LaunchType: ecsTypes.LaunchTypeFargate, LastStatus: aws.String(lastStatus)}
+	for _, c := range containerStatuses {
+		t.Containers = append(t.Containers, ecsTypes.Container{LastStatus: aws.String(c)})
+	}
+	return t
+}
+
+func TestGetAgentlessCountersPagination(t *testing.T) {
+	tests := []struct {
+		name    string
+		count   func() (AgentlessServiceCount, error)
+		service string
+		want    int
+		wantErr bool
+	}{
+		{
+			name: "rds over two pages",
+			count: func() (AgentlessServiceCount, error) {
+				return getRDSInstanceCountByRegion(ctx, &fakeRDS{instances: [][]rdsTypes.DBInstance{make([]rdsTypes.DBInstance, 100), make([]rdsTypes.DBInstance, 3)}}, "us-east-1", nil)
+			},
+			service: RDS,
+			want:    103,
+		},
+		{
+			name: "rds error",
+			count: func() (AgentlessServiceCount, error) {
+				return getRDSInstanceCountByRegion(ctx, &fakeRDS{err: errAccessDenied}, "us-east-1", nil)
+			},
+			service: RDS,
+			want:    0,
+			wantErr: true,
+		},
+		{
+			name: "redshift",
+			count: func() (AgentlessServiceCount, error) {
+				return getRedshiftInstanceCountByRegion(ctx, &fakeRedshift{clusters: [][]redshiftTypes.Cluster{make([]redshiftTypes.Cluster, 2)}}, "us-east-1", nil)
+			},
+			service: REDSHIFT,
+			want:    2,
+		},
+		{
+			name: "elbv1 over three pages",
+			count: func() (AgentlessServiceCount, error) {
+				return getELBv1InstanceCountByRegion(ctx, &fakeELB{loadBalancers: [][]elbTypes.LoadBalancerDescription{make([]elbTypes.LoadBalancerDescription, 1), make([]elbTypes.LoadBalancerDescription, 1), make([]elbTypes.LoadBalancerDescription, 1)}}, "us-east-1", nil)
+			},
+			service: ELBv1,
+			want:    3,
+		},
+		{
+			name: "elbv2 error",
+			count: func() (AgentlessServiceCount, error) {
+				return getELBv2InstanceCountByRegion(ctx, &fakeELBv2{err: errAccessDenied}, "us-east-1", nil)
+			},
+			service: ELBv2,
+			want:    0,
+			wantErr: true,
+		},
+		{
+			name: "elbv2",
+			count: func() (AgentlessServiceCount, error) {
+				return getELBv2InstanceCountByRegion(ctx, &fakeELBv2{loadBalancers: [][]elbv2Types.LoadBalancer{make([]elbv2Types.LoadBalancer, 4)}}, "us-east-1", nil)
+			},
+			service: ELBv2,
+			want:    4,
+		},
+		{
+			name: "nat gateways over two pages",
+			count: func() (AgentlessServiceCount, error) {
+				return getNatGatewayInstanceCountByRegion(ctx, &fakeEC2{natGateways: [][]ec2Types.NatGateway{make([]ec2Types.NatGateway, 2), make([]ec2Types.NatGateway, 1)}}, "us-east-1", nil)
+			},
+			service: NATGATEWAY,
+			want:    3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.count()
+			if got.Service != tt.service || got.Region != "us-east-1" || got.Count != tt.want {
+				t.Errorf("got %+v, want %s count %d in us-east-1", got, tt.service, tt.want)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGetRegions(t *testing.T) {
+	service := &fakeEC2{regions: []ec2Types.Region{
+		{RegionName: aws.String("us-east-1"), OptInStatus: aws.String("opt-in-not-required")},
+		{RegionName: aws.String("af-south-1"), OptInStatus: aws.String("not-opted-in")},
+		{RegionName: aws.String("ap-east-1"), OptInStatus: aws.String("opted-in")},
+	}}
+
+	got, err := getRegions(ctx, service)
+	want := []string{"us-east-1", "ap-east-1"}
+	if !reflect.DeepEqual(got, want) || err != nil {
+		t.Errorf("getRegions() = %v, %v, want %v", got, err, want)
+	}
+
+	if got, err := getRegions(ctx, &fakeEC2{errs: map[string]error{"DescribeRegions": errAccessDenied}}); len(got) != 0 || !errors.Is(err, errAccessDenied) {
+		t.Errorf("getRegions() on error = %v, %v, want none and %v", got, err, errAccessDenied)
+	}
+}
+
+func TestGetEC2InstancesByRegion(t *testing.T) {
+	k8sTags := []string{"eks:cluster-name", "aws:eks:cluster-name", "custom-k8s"}
+
+	tests := []struct {
+		name    string
+		service *fakeEC2
+		want    []VMInfo
+		wantErr bool
+	}{
+		{
+			name: "eks and user tags are enterprise",
+			service: &fakeEC2{reservations: [][]ec2Types.Reservation{{
+				reservation("111111111111",
+					instance("i-plain", "Linux/UNIX", "Name"),
+					instance("i-eks", "Linux/UNIX", "eks:cluster-name"),
+					instance("i-aws-eks", "Windows", "aws:eks:cluster-name"),
+					instance("i-custom", "Linux/UNIX", "custom-k8s"),
+				),
+			}}},
+			want: []VMInfo{
+				{Region: "us-east-1", InstanceID: "i-plain", AMI: "ami-i-plain", AccountId: "111111111111", AgentType: STANDARD_AGENT, OS: "Linux/UNIX", OSFamily: OS_LINUX, Distribution: DISTRO_UNKNOWN, Tags: map[string]string{"Name": "value"}},
+				{Region: "us-east-1", InstanceID: "i-eks", AMI: "ami-i-eks", AccountId: "111111111111", AgentType: ENTERPRISE_AGENT, OS: "Linux/UNIX", OSFamily: OS_LINUX, Distribution: DISTRO_UNKNOWN, EKSCluster: "value", Tags: map[string]string{"eks:cluster-name": "value"}},
+				{Region: "us-east-1", InstanceID: "i-aws-eks", AMI: "ami-i-aws-eks", AccountId: "111111111111", AgentType: ENTERPRISE_AGENT, OS: "Windows", OSFamily: OS_WINDOWS, Distribution: OS_WINDOWS, EKSCluster: "value", Tags: map[string]string{"aws:eks:cluster-name": "value"}},
+				{Region: "us-east-1", InstanceID: "i-custom", AMI: "ami-i-custom", AccountId: "111111111111", AgentType: ENTERPRISE_AGENT, OS: "Linux/UNIX", OSFamily: OS_LINUX, Distribution: DISTRO_UNKNOWN, Tags: map[string]string{"custom-k8s": "value"}},
+			},
+		},
+		{
+			name: "reservations across pages",
+			service: &fakeEC2{reservations: [][]ec2Types.Reservation{
+				{reservation("111111111111", instance("i-1", "Linux/UNIX"))},
+				{reservation("222222222222", instance("i-2", "Windows"))},
+			}},
+			want: []VMInfo{
+				{Region: "us-east-1", InstanceID: "i-1", AMI: "ami-i-1", AccountId: "111111111111", AgentType: STANDARD_AGENT, OS: "Linux/UNIX", OSFamily: OS_LINUX, Distribution: DISTRO_UNKNOWN, Tags: map[string]string{}},
+				{Region: "us-east-1", InstanceID: "i-2", AMI: "ami-i-2", AccountId: "222222222222", AgentType: STANDARD_AGENT, OS: "Windows", OSFamily: OS_WINDOWS, Distribution: OS_WINDOWS, Tags: map[string]string{}},
+			},
+		},
+		{
+			name:    "error",
+			service: &fakeEC2{errs: map[string]error{"DescribeInstances": errAccessDenied}},
+			want:    []VMInfo{},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := getEC2InstancesByRegion(ctx, tt.service, "us-east-1", k8sTags)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGetEC2InstancesVCPU(t *testing.T) {
+	withCPUOptions := instance("i-options", "Linux/UNIX")
+	withCPUOptions.InstanceType = ec2Types.InstanceTypeM5Xlarge
+	withCPUOptions.CpuOptions = &ec2Types.CpuOptions{CoreCount: aws.Int32(2), ThreadsPerCore: aws.Int32(1)}
+	byType := instance("i-type", "Windows")
+	byType.InstanceType = ec2Types.InstanceTypeC52xlarge
+	unknown := instance("i-unknown", "Linux/UNIX")
+	unknown.InstanceType = ec2Types.InstanceTypeT2Micro
+
+	service := &fakeEC2{
+		reservations: [][]ec2Types.Reservation{{reservation("111111111111", withCPUOptions, byType, unknown)}},
+		vcpus:        map[string]int32{"m5.xlarge": 4, "c5.2xlarge": 8},
+	}
+
+	vms, err := getEC2InstancesByRegion(ctx, service, "us-east-1", nil)
+	if err != nil {
+		t.Fatalf("getEC2InstancesByRegion() error = %v", err)
+	}
+
+	got := make(map[string]int)
+	for _, vm := range vms {
+		got[vm.InstanceID+" "+vm.InstanceType] = vm.VCPU
+	}
+	//CPU options win over the instance type default, unknown types stay at 0
+	want := map[string]int{"i-options m5.xlarge": 2, "i-type c5.2xlarge": 8, "i-unknown t2.micro": 0}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("vCPUs = %v, want %v", got, want)
+	}
+
+	service.errs = map[string]error{"DescribeInstanceTypes": errAccessDenied}
+	if vms, err := getEC2InstancesByRegion(ctx, service, "us-east-1", nil); len(vms) != 3 || !errors.Is(err, errAccessDenied) {
+		t.Errorf("on DescribeInstanceTypes error got %d VMs, %v, want 3 and %v", len(vms), err, errAccessDenied)
+	}
+}
+
+func TestGetEC2InstancesState(t *testing.T) {
+	var instances []ec2Types.Instance
+	for _, state := range []ec2Types.InstanceStateName{"pending", "running", "stopping", "stopped"} {
+		i := instance("i-"+string(state), "Linux/UNIX")
+		i.State = &ec2Types.InstanceState{Name: state}
+		instances = append(instances, i)
+	}
+	service := &fakeEC2{reservations: [][]ec2Types.Reservation{{reservation("111111111111", instances...)}}}
+
+	vms, err := getEC2InstancesByRegion(ctx, service, "us-east-1", nil)
+	if err != nil {
+		t.Fatalf("getEC2InstancesByRegion() error = %v", err)
+	}
+	got := make(map[string]string)
+	for _, vm := range vms {
+		got[vm.InstanceID] = vm.State
+	}
+	want := map[string]string{"i-pending": report.StateRunning, "i-running": report.StateRunning, "i-stopping": report.StateStopped, "i-stopped": report.StateStopped}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("states = %v, want %v", got, want)
+	}
+
+	for states, want := range map[string]int{report.StateRunning: 2, report.StateStopped: 2, report.StatesAll: 4} {
+		if got := filterVMStates(vms, states); len(got) != want {
+			t.Errorf("filterVMStates(%s) kept %d VMs, want %d", states, len(got), want)
+		}
+	}
+}
+
+func TestGetECSFargateContainers(t *testing.T) {
+	ec2Task := fargateTask("RUNNING", "RUNNING", "RUNNING")
+	ec2Task.LaunchType = ecsTypes.LaunchTypeEc2
+
+	service := &fakeECS{
+		clusters: [][]string{{"cluster-a"}, {"cluster-b"}},
+		tasks: map[string][][]string{
+			"cluster-a": {{"fargate-running", "fargate-stopped"}, {"ec2-running"}},
+			"cluster-b": {{"fargate-partial"}},
+		},
+		taskDetails: map[string]ecsTypes.Task{
+			"fargate-running": fargateTask("RUNNING", "RUNNING", "RUNNING"),
+			"fargate-stopped": fargateTask("STOPPED", "STOPPED"),
+			"ec2-running":     ec2Task,
+			"fargate-partial": fargateTask("RUNNING", "RUNNING", "STOPPED", "PENDING"),
+		},
+	}
+
+	snapshot := crawlSnapshot(t, service)
+	if got := getECSFargateRunningContainersByRegion(snapshot); got.Count != 3 || got.ContainerType != FARGATE_RUNNING_CONTAINERS || got.Region != "us-east-1" {
+		t.Errorf("running containers = %+v, want 3", got)
+	}
+	if got := getECSFargateTotalContainersByRegion(snapshot); got.Count != 5 || got.ContainerType != FARGATE_TOTAL_CONTAINERS {
+		t.Errorf("total containers = %+v, want 5", got)
+	}
+	if got := getECSFargateRunningTasksByRegion(snapshot); got.Count != 4 || got.ContainerType != FARGATE_RUNNING_TASKS {
+		t.Errorf("running tasks = %+v, want 4", got)
+	}
+
+	//a failed crawl leaves an empty snapshot
+	if got := getECSFargateTotalContainersByRegion(&ECSSnapshot{Region: "us-east-1"}); got.Count != 0 {
+		t.Errorf("total containers of an empty snapshot = %d, want 0", got.Count)
+	}
+}
+
+func TestGetECSFargateActiveServicesByRegion(t *testing.T) {
+	service := &fakeECS{
+		clusters: [][]string{{"cluster-a", "cluster-b"}, {"cluster-c"}},
+		clusterDetails: map[string]ecsTypes.Cluster{
+			"cluster-a": {ActiveServicesCount: 2},
+			"cluster-b": {ActiveServicesCount: 0},
+			"cluster-c": {ActiveServicesCount: 5},
+		},
+	}
+
+	if got := getECSFargateActiveServicesByRegion(crawlSnapshot(t, service)); got.Count != 7 {
+		t.Errorf("active services = %d, want 7", got.Count)
+	}
+}
+
+func TestGetECSTaskDefinitionsByRegion(t *testing.T) {
+	service := &fakeECS{taskDefinitions: [][]string{{"td-1", "td-2"}, {"td-3"}}}
+	if got, err := getECSTaskDefinitionsByRegion(ctx, service, "us-east-1"); got.Count != 3 || got.ContainerType != ECS_TASKS || err != nil {
+		t.Errorf("task definitions = %+v, want 3", got)
+	}
+}
+
+func TestGetEKSFargateActiveProfilesByRegion(t *testing.T) {
+	service := &fakeEKS{
+		clusters: [][]string{{"eks-a"}, {"eks-b"}},
+		fargateProfiles: map[string][][]string{
+			"eks-a": {{"fp-1"}, {"fp-2"}},
+			"eks-b": {{"fp-3"}},
+		},
+	}
+	if got, err := getEKSFargateActiveProfilesByRegion(ctx, service, "us-east-1", []string{"eks-a", "eks-b"}); got.Count != 3 || err != nil {
+		t.Errorf("fargate profiles = %d, want 3", got.Count)
+	}
+
+	service.errs = map[string]error{"ListFargateProfiles": errAccessDenied}
+	if got, err := getEKSFargateActiveProfilesByRegion(ctx, service, "us-east-1", []string{"eks-a", "eks-b"}); got.Count != 0 || !errors.Is(err, errAccessDenied) {
+		t.Errorf("fargate profiles on error = %d, want 0", got.Count)
+	}
+}
+
+func TestGetECSVMCountByRegion(t *testing.T) {
+	service := &fakeECS{
+		clusters: [][]string{{"cluster-a"}},
+		containerInstances: map[string][][]string{
+			"cluster-a": {{"ci-1", "ci-2"}},
+		},
+		instanceDetails: map[string]ecsTypes.ContainerInstance{
+			"ci-1": {Ec2InstanceId: aws.String("i-1")},
+			"ci-2": {Ec2InstanceId: aws.String("i-2")},
+		},
+	}
+
+	var ids []string
+	for _, vm := range getECSVMCountByRegion(crawlSnapshot(t, service)) {
+		if vm.AgentType != ENTERPRISE_AGENT {
+			t.Errorf("%s agent type = %s, want %s", vm.InstanceID, vm.AgentType, ENTERPRISE_AGENT)
+		}
+		ids = append(ids, vm.InstanceID)
+	}
+	if want := []string{"i-1", "i-2"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("ECS instances = %v, want %v", ids, want)
+	}
+}
+
+func TestClassifyVMs(t *testing.T) {
+	tests := []struct {
+		name   string
+		ec2VMs []VMInfo
+		ecsVMs []VMInfo
+		want   map[string]string
+	}{
+		{
+			name: "ecs container instances are enterprise",
+			ec2VMs: []VMInfo{
+				{Region: "us-east-1", InstanceID: "i-standard", AgentType: STANDARD_AGENT},
+				{Region: "us-east-1", InstanceID: "i-ecs", AgentType: STANDARD_AGENT},
+				{Region: "us-east-1", InstanceID: "i-eks", AgentType: ENTERPRISE_AGENT},
+			},
+			ecsVMs: []VMInfo{{Region: "us-east-1", InstanceID: "i-ecs", AgentType: ENTERPRISE_AGENT}},
+			want: map[string]string{
+				"us-east-1/i-standard": STANDARD_AGENT,
+				"us-east-1/i-ecs":      ENTERPRISE_AGENT,
+				"us-east-1/i-eks":      ENTERPRISE_AGENT,
+			},
+		},
+		{
+			name: "same instance ID in another region is not an ecs instance",
+			ec2VMs: []VMInfo{
+				{Region: "us-east-1", InstanceID: "i-shared", AgentType: STANDARD_AGENT},
+				{Region: "eu-west-1", InstanceID: "i-shared", AgentType: STANDARD_AGENT},
+			},
+			ecsVMs: []VMInfo{{Region: "eu-west-1", InstanceID: "i-shared", AgentType: ENTERPRISE_AGENT}},
+			want: map[string]string{
+				"us-east-1/i-shared": STANDARD_AGENT,
+				"eu-west-1/i-shared": ENTERPRISE_AGENT,
+			},
+		},
+		{
+			name:   "matched on instance ID not AMI",
+			ec2VMs: []VMInfo{{Region: "us-east-1", InstanceID: "i-1", AMI: "ami-1", AgentType: STANDARD_AGENT}},
+			ecsVMs: []VMInfo{{Region: "us-east-1", InstanceID: "ami-1", AgentType: ENTERPRISE_AGENT}},
+			want:   map[string]string{"us-east-1/i-1": STANDARD_AGENT},
+		},
+		{
+			name:   "ecs instances missing from ec2 are not added",
+			ec2VMs: []VMInfo{{Region: "us-east-1", InstanceID: "i-1"}},
+			ecsVMs: []VMInfo{{Region: "us-east-1", InstanceID: "i-gone", AgentType: ENTERPRISE_AGENT}},
+			want:   map[string]string{"us-east-1/i-1": STANDARD_AGENT},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := make(map[string]string)
+			for _, vm := range classifyVMs(tt.ec2VMs, tt.ecsVMs) {
+				got[vm.Region+"/"+vm.InstanceID] = vm.AgentType
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("classifyVMs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeTargets(t *testing.T) {
+	targets := []scanTarget{
+		{Label: "profile sso", Name: "sso", AccountId: "111111111111"},
+		{Label: "profile other", Name: "other", AccountId: "222222222222"},
+		{Label: "profile legacy", Name: "legacy", AccountId: "111111111111"},
+	}
+
+	got := mergeTargets(targets)
+	if len(got) != 2 {
+		t.Fatalf("got %d targets, want one per account", len(got))
+	}
+	if got[0].Name != "sso" || !reflect.DeepEqual(got[0].Merged, []string{"profile legacy"}) {
+		t.Errorf("merged target = %+v, want sso with profile legacy merged", got[0])
+	}
+	if got[1].AccountId != "222222222222" || len(got[1].Merged) != 0 {
+		t.Errorf("second target = %+v, want 222222222222 unmerged", got[1])
+	}
+}
+
+func TestFilterRegions(t *testing.T) {
+	available := []string{"us-east-1", "us-west-2", "eu-west-1", "ap-east-1", "ap-southeast-2"}
+
+	tests := []struct {
+		name    string
+		include []string
+		exclude []string
+		want    []string
+	}{
+		{name: "everything", want: available},
+		{name: "glob", include: []string{"us-*"}, want: []string{"us-east-1", "us-west-2"}},
+		{name: "negated pattern", include: []string{"!ap-east-1"}, want: []string{"us-east-1", "us-west-2", "eu-west-1", "ap-southeast-2"}},
+		{name: "glob and negation", include: []string{"ap-*", "!ap-east-1"}, want: []string{"ap-southeast-2"}},
+		{name: "exclude flag", include: []string{"us-east-1", "eu-west-1"}, exclude: []string{"eu-*"}, want: []string{"us-east-1"}},
+		{name: "not enabled in account", include: []string{"me-south-1", "us-west-2"}, want: []string{"us-west-2"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := filterRegions(available, tt.include, tt.exclude); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("filterRegions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNeedsRegionDiscovery(t *testing.T) {
+	tests := []struct {
+		include []string
+		exclude []string
+		want    bool
+	}{
+		{want: true},
+		{include: []string{"us-east-1", "eu-west-1"}, want: false},
+		{include: []string{"us-*"}, want: true},
+		{include: []string{"!ap-east-1"}, want: true},
+		{include: []string{"us-east-1"}, exclude: []string{"us-east-1"}, want: true},
+	}
+
+	for _, tt := range tests {
+		if got := needsRegionDiscovery(tt.include, tt.exclude); got != tt.want {
+			t.Errorf("needsRegionDiscovery(%v, %v) = %v, want %v", tt.include, tt.exclude, got, tt.want)
+		}
+	}
+}
+
+func TestGetOrgTargets(t *testing.T) {
+	account := func(id string, status orgTypes.AccountStatus) orgTypes.Account {
+		return orgTypes.Account{Id: aws.String(id), Name: aws.String("name-" + id), Status: status}
+	}
+	service := &fakeOrganizations{accounts: [][]orgTypes.Account{
+		{account("111", orgTypes.AccountStatusActive), account("222", orgTypes.AccountStatusActive), account("333", orgTypes.AccountStatusSuspended)},
+		{account("444", orgTypes.AccountStatusActive), account("555", orgTypes.AccountStatusActive), account("666", orgTypes.AccountStatusActive)},
+	}}
+
+	var mu sync.Mutex
+	var running, maxRunning int
+	var assumed []string
+	original := assumeRole
+	assumeRole = func(ctx context.Context, cfg aws.Config, roleArn string, externalId string, sessionName string) (aws.Config, error) {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		assumed = append(assumed, roleArn)
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+
+		if strings.Contains(roleArn, "::444:") {
+			return cfg, errAccessDenied
+		}
+		roleCfg := cfg.Copy()
+		roleCfg.Region = roleArn
+		return roleCfg, nil
+	}
+	t.Cleanup(func() { assumeRole = original })
+
+	org := OrgOptions{Enabled: true, Role: "OrganizationAccountAccessRole"}
+	identity := &sts.GetCallerIdentityOutput{Account: aws.String("111")}
+	targets, skipped, err := getOrgTargets(ctx, helpers.NewScheduler(2), service, aws.Config{}, identity, "aws", org)
+	if err != nil {
+		t.Fatalf("getOrgTargets() error = %v", err)
+	}
+
+	var ids []string
+	for _, target := range targets {
+		ids = append(ids, target.AccountId)
+	}
+	if want := []string{"111", "222", "555", "666"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("targets = %v, want %v in listing order", ids, want)
+	}
+	if targets[0].Config.Region != "" || targets[1].Config.Region != "arn:aws:iam::222:role/OrganizationAccountAccessRole" {
+		t.Errorf("target configs = %q, %q, want the management config and the assumed role", targets[0].Config.Region, targets[1].Config.Region)
+	}
+	//the management account is used directly and suspended accounts aren't assumed
+	if len(assumed) != 4 {
+		t.Errorf("assumed %v, want the four active members", assumed)
+	}
+	if maxRunning > 2 {
+		t.Errorf("%d assumes ran at once, want at most 2", maxRunning)
+	}
+
+	if len(skipped) != 2 || skipped[0].AccountId != "333" || skipped[0].Reason != "account status SUSPENDED" {
+		t.Fatalf("skipped = %+v, want the suspended account first", skipped)
+	}
+	if skipped[1].AccountId != "444" || !strings.Contains(skipped[1].Reason, "unable to assume arn:aws:iam::444:role/OrganizationAccountAccessRole") || !strings.Contains(skipped[1].Reason, errAccessDenied.Error()) {
+		t.Errorf("skipped = %+v, want the member whose role can't be assumed", skipped[1])
+	}
+
+	service.err = errAccessDenied
+	if _, _, err := getOrgTargets(ctx, helpers.NewScheduler(2), service, aws.Config{}, identity, "aws", org); !errors.Is(err, errAccessDenied) {
+		t.Errorf("getOrgTargets() on ListAccounts error = %v, want %v", err, errAccessDenied)
+	}
+}
@@ -0,0 +1,118 @@
+package lwaws
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/eks"
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancing"
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/organizations"
+	"github.com/aws/aws-sdk-go-v2/service/rds"
+	"github.com/aws/aws-sdk-go-v2/service/redshift"
+)
+
+// The interfaces below are the subset of each SDK client the counters use, so
+// they can be swapped for in-memory fakes in tests
+
+type EC2API interface {
+	ec2.DescribeInstancesAPIClient
+	ec2.DescribeNatGatewaysAPIClient
+	ec2.DescribeInstanceTypesAPIClient
+	ec2.DescribeVolumesAPIClient
+	ec2.DescribeSnapshotsAPIClient
+	DescribeRegions(ctx context.Context, params *ec2.DescribeRegionsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeRegionsOutput, error)
+	DescribeImages(ctx context.Context, params *ec2.DescribeImagesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeImagesOutput, error)
+}
+
+type ECRAPI interface {
+	ecr.DescribeRepositoriesAPIClient
+	ecr.DescribeImagesAPIClient
+}
+
+type ECSAPI interface {
+	ecs.ListClustersAPIClient
+	ecs.ListTasksAPIClient
+	ecs.ListTaskDefinitionsAPIClient
+	ecs.ListContainerInstancesAPIClient
+	DescribeClusters(ctx context.Context, params *ecs.DescribeClustersInput, optFns ...func(*ecs.Options)) (*ecs.DescribeClustersOutput, error)
+	DescribeTasks(ctx context.Context, params *ecs.DescribeTasksInput, optFns ...func(*ecs.Options)) (*ecs.DescribeTasksOutput, error)
+	DescribeContainerInstances(ctx context.Context, params *ecs.DescribeContainerInstancesInput, optFns ...func(*ecs.Options)) (*ecs.DescribeContainerInstancesOutput, error)
+}
+
+type EKSAPI interface {
+	eks.ListClustersAPIClient
+	eks.ListFargateProfilesAPIClient
+	eks.ListNodegroupsAPIClient
+	DescribeCluster(ctx context.Context, params *eks.DescribeClusterInput, optFns ...func(*eks.Options)) (*eks.DescribeClusterOutput, error)
+	DescribeNodegroup(ctx context.Context, params *eks.DescribeNodegroupInput, optFns ...func(*eks.Options)) (*eks.DescribeNodegroupOutput, error)
+}
+
+type AutoScalingAPI interface {
+	autoscaling.DescribeAutoScalingGroupsAPIClient
+}
+
+type RDSAPI interface {
+	rds.DescribeDBInstancesAPIClient
+}
+
+type RedshiftAPI interface {
+	redshift.DescribeClustersAPIClient
+}
+
+type ELBAPI interface {
+	elasticloadbalancing.DescribeLoadBalancersAPIClient
+	DescribeTags(ctx context.Context, params *elasticloadbalancing.DescribeTagsInput, optFns ...func(*elasticloadbalancing.Options)) (*elasticloadbalancing.DescribeTagsOutput, error)
+}
+
+type ELBv2API interface {
+	elasticloadbalancingv2.DescribeLoadBalancersAPIClient
+	DescribeTags(ctx context.Context, params *elasticloadbalancingv2.DescribeTagsInput, optFns ...func(*elasticloadbalancingv2.Options)) (*elasticloadbalancingv2.DescribeTagsOutput, error)
+}
+
+type LambdaAPI interface {
+	lambda.ListFunctionsAPIClient
+}
+
+type OrganizationsAPI interface {
+	organizations.ListAccountsAPIClient
+}
+
+// Clients are the service clients for a single account and region
+type Clients struct {
+	EC2         EC2API
+	ECR         ECRAPI
+	ECS         ECSAPI
+	EKS         EKSAPI
+	RDS         RDSAPI
+	Redshift    RedshiftAPI
+	ELB         ELBAPI
+	ELBv2       ELBv2API
+	Lambda      LambdaAPI
+	AutoScaling AutoScalingAPI
+	//only used with the management account config of --org
+	Organizations OrganizationsAPI
+}
+
+// newClients creates the SDK clients for a region config, tests replace it to
+// inject fakes
+var newClients = func(cfg aws.Config) Clients {
+	return Clients{
+		EC2:           ec2.NewFromConfig(cfg),
+		ECR:           ecr.NewFromConfig(cfg),
+		ECS:           ecs.NewFromConfig(cfg),
+		EKS:           eks.NewFromConfig(cfg),
+		RDS:           rds.NewFromConfig(cfg),
+		Redshift:      redshift.NewFromConfig(cfg),
+		ELB:           elasticloadbalancing.NewFromConfig(cfg),
+		ELBv2:         elasticloadbalancingv2.NewFromConfig(cfg),
+		Lambda:        lambda.NewFromConfig(cfg),
+		AutoScaling:   autoscaling.NewFromConfig(cfg),
+		Organizations: organizations.NewFromConfig(cfg),
+	}
+}
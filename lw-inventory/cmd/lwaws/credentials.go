@@ -0,0 +1,161 @@
+package lwaws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/lacework-dev/scripts/lw-inventory/helpers"
+	"github.com/spf13/cobra"
+)
+
+const DEFAULT_ROLE_SESSION_NAME = "lw-inventory"
+
+// CredentialOptions are credentials layered over those of each profile, so
+// CI jobs and jump accounts can assume a role without writing profiles
+type CredentialOptions struct {
+	//role to assume with the profile credentials, or with the web identity
+	//token when WebIdentityTokenFile is set
+	RoleArn string
+	//also used for the organization role
+	ExternalID      string
+	RoleSessionName string
+	//MFA device of the profile user, the token code is read from stdin
+	MFASerial            string
+	WebIdentityTokenFile string
+}
+
+func (c CredentialOptions) Validate() error {
+	if c.RoleArn == "" {
+		if c.MFASerial != "" {
+			return errors.New("--mfa-serial needs --role-arn")
+		}
+		if c.WebIdentityTokenFile != "" {
+			return errors.New("--web-identity-token-file needs --role-arn")
+		}
+	}
+	if c.MFASerial != "" && c.WebIdentityTokenFile != "" {
+		return errors.New("--mfa-serial can't be used with --web-identity-token-file")
+	}
+	return nil
+}
+
+// SetOptions records the credential settings in the report metadata options,
+// the external ID is left out
+func (c CredentialOptions) SetOptions(options map[string]string) {
+	if c.RoleArn != "" {
+		options["role-arn"] = c.RoleArn
+		options["role-session-name"] = c.RoleSessionName
+	}
+	if c.MFASerial != "" {
+		options["mfa-serial"] = c.MFASerial
+	}
+	if c.WebIdentityTokenFile != "" {
+		options["web-identity-token-file"] = c.WebIdentityTokenFile
+	}
+}
+
+// getSession loads the config of profile in the bootstrap region of the
+// partition and applies the credential options. Credentials are retrieved up
+// front so a profile that can't be used, such as one with an expired SSO
+// session, is reported before scanning instead of failing every scan of the
+// account
+func getSession(ctx context.Context, profile string, partition string, creds CredentialOptions) (aws.Config, error) {
+	//the SDK falls back to the default credential chain when a profile is
+	//missing, a named profile has to exist
+	if profile != config.DefaultSharedConfigProfile {
+		configFile, credentialsFile := sharedConfigFiles()
+		if _, err := config.LoadSharedConfigProfile(ctx, profile, func(o *config.LoadSharedConfigOptions) {
+			o.ConfigFiles = []string{configFile}
+			o.CredentialsFiles = []string{credentialsFile}
+		}); err != nil {
+			return aws.Config{}, fmt.Errorf("loading profile: %w", err)
+		}
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx,
+		config.WithSharedConfigProfile(profile),
+		//back off on throttling instead of failing the call
+		config.WithRetryMode(aws.RetryModeAdaptive),
+		config.WithRetryMaxAttempts(retryMaxAttempts),
+		//config.WithDefaultsMode(aws.DefaultsModeAuto),
+	)
+	if err != nil {
+		return cfg, fmt.Errorf("loading config: %w", err)
+	}
+	profileRegion := cfg.Region
+	cfg.Region = bootstrapRegion(partition, cfg.Region)
+
+	cfg = withRoleCredentials(cfg, creds)
+	if cfg.Credentials == nil {
+		return cfg, errors.New("no credentials found")
+	}
+	if _, err := cfg.Credentials.Retrieve(ctx); err != nil {
+		if creds.RoleArn != "" {
+			//the role is assumed through STS in the bootstrap region, which
+			//is in the aws partition unless the profile or flag says otherwise
+			if partition == "" && profileRegion == "" {
+				return cfg, fmt.Errorf("assuming %s in %s, set --partition for GovCloud and China roles: %w", creds.RoleArn, cfg.Region, ssoLoginError(profile, err))
+			}
+			return cfg, fmt.Errorf("assuming %s: %w", creds.RoleArn, ssoLoginError(profile, err))
+		}
+		return cfg, fmt.Errorf("retrieving credentials: %w", ssoLoginError(profile, err))
+	}
+
+	return cfg, nil
+}
+
+// withRoleCredentials returns a copy of cfg using credentials for the role
+// of creds, cfg itself when there is no role
+func withRoleCredentials(cfg aws.Config, creds CredentialOptions) aws.Config {
+	if creds.RoleArn == "" {
+		return cfg
+	}
+
+	roleCfg := cfg.Copy()
+	client := sts.NewFromConfig(cfg)
+	if creds.WebIdentityTokenFile != "" {
+		roleCfg.Credentials = aws.NewCredentialsCache(stscreds.NewWebIdentityRoleProvider(client, creds.RoleArn, stscreds.IdentityTokenFile(creds.WebIdentityTokenFile), func(o *stscreds.WebIdentityRoleOptions) {
+			o.RoleSessionName = creds.RoleSessionName
+		}))
+		return roleCfg
+	}
+
+	roleCfg.Credentials = aws.NewCredentialsCache(stscreds.NewAssumeRoleProvider(client, creds.RoleArn, func(o *stscreds.AssumeRoleOptions) {
+		o.RoleSessionName = creds.RoleSessionName
+		if creds.ExternalID != "" {
+			o.ExternalID = aws.String(creds.ExternalID)
+		}
+		if creds.MFASerial != "" {
+			o.SerialNumber = aws.String(creds.MFASerial)
+			o.TokenProvider = stscreds.StdinTokenProvider
+		}
+	}))
+	return roleCfg
+}
+
+func ParseCredentials(cmd *cobra.Command) CredentialOptions {
+	creds := CredentialOptions{
+		RoleArn:              helpers.GetFlagEnvironmentString(cmd, "role-arn", "role-arn", "", false),
+		ExternalID:           helpers.GetFlagEnvironmentString(cmd, "external-id", "external-id", "", false),
+		RoleSessionName:      parseRoleSessionName(cmd),
+		MFASerial:            helpers.GetFlagEnvironmentString(cmd, "mfa-serial", "mfa-serial", "", false),
+		WebIdentityTokenFile: helpers.GetFlagEnvironmentString(cmd, "web-identity-token-file", "web-identity-token-file", "", false),
+	}
+	if err := creds.Validate(); err != nil {
+		helpers.Bail("invalid credential options", err)
+	}
+	return creds
+}
+
+func parseRoleSessionName(cmd *cobra.Command) string {
+	name := helpers.GetFlagEnvironmentString(cmd, "role-session-name", "role-session-name", "", false)
+	if name == "" {
+		name = DEFAULT_ROLE_SESSION_NAME
+	}
+	return name
+}
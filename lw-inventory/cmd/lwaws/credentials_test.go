@@ -0,0 +1,97 @@
+package lwaws
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCredentialOptionsValidate(t *testing.T) {
+	tests := []struct {
+		creds CredentialOptions
+		valid bool
+	}{
+		{CredentialOptions{}, true},
+		{CredentialOptions{RoleArn: "arn:aws:iam::123456789012:role/audit", MFASerial: "arn:aws:iam::123456789012:mfa/ops"}, true},
+		{CredentialOptions{RoleArn: "arn:aws:iam::123456789012:role/audit", WebIdentityTokenFile: "/var/run/token"}, true},
+		{CredentialOptions{MFASerial: "arn:aws:iam::123456789012:mfa/ops"}, false},
+		{CredentialOptions{WebIdentityTokenFile: "/var/run/token"}, false},
+		{CredentialOptions{RoleArn: "arn:aws:iam::123456789012:role/audit", MFASerial: "arn:aws:iam::123456789012:mfa/ops", WebIdentityTokenFile: "/var/run/token"}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.creds.Validate(); (err == nil) != tt.valid {
+			t.Errorf("Validate(%+v) = %v, want valid %v", tt.creds, err, tt.valid)
+		}
+	}
+}
+
+// useSharedConfig points the SDK at a shared config with only the default
+// profile so tests don't read the profiles of the machine
+func useSharedConfig(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"AWS_CONFIG_FILE":             "[default]\nregion = us-east-1\n",
+		"AWS_SHARED_CREDENTIALS_FILE": "[default]\naws_access_key_id = AKIDTEST\naws_secret_access_key = secret\n",
+	}
+	for env, content := range files {
+		path := filepath.Join(dir, env)
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		t.Setenv(env, path)
+	}
+	for _, env := range []string{"AWS_PROFILE", "AWS_REGION", "AWS_DEFAULT_REGION", "AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN"} {
+		t.Setenv(env, "")
+	}
+}
+
+func TestGetSessionMissingProfile(t *testing.T) {
+	useSharedConfig(t)
+
+	if _, err := getSession(ctx, "missing", "", CredentialOptions{}); err == nil || !strings.Contains(err.Error(), "loading profile") {
+		t.Errorf("getSession error = %v, want a loading profile error", err)
+	}
+}
+
+func TestGetSessionBadConfig(t *testing.T) {
+	useSharedConfig(t)
+	path := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(path, []byte("[default]\nec2_metadata_service_endpoint_mode = sideways\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AWS_CONFIG_FILE", path)
+
+	if _, err := getSession(ctx, "default", "", CredentialOptions{}); err == nil || !strings.Contains(err.Error(), "loading config") {
+		t.Errorf("getSession error = %v, want a loading config error", err)
+	}
+}
+
+func TestGetSessionWebIdentity(t *testing.T) {
+	useSharedConfig(t)
+
+	creds := CredentialOptions{
+		RoleArn:              "arn:aws:iam::123456789012:role/ci",
+		RoleSessionName:      DEFAULT_ROLE_SESSION_NAME,
+		WebIdentityTokenFile: filepath.Join(t.TempDir(), "missing-token"),
+	}
+	//the token file is read before STS is called
+	if _, err := getSession(ctx, "default", "", creds); err == nil || !strings.Contains(err.Error(), "assuming "+creds.RoleArn) {
+		t.Errorf("getSession error = %v, want an error assuming the role", err)
+	}
+}
+
+func TestWithRoleCredentials(t *testing.T) {
+	useSharedConfig(t)
+
+	cfg, err := getSession(ctx, "default", "", CredentialOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := withRoleCredentials(cfg, CredentialOptions{}); got.Credentials != cfg.Credentials {
+		t.Error("credentials changed without a role")
+	}
+	if got := withRoleCredentials(cfg, CredentialOptions{RoleArn: "arn:aws:iam::123456789012:role/audit"}); got.Credentials == cfg.Credentials {
+		t.Error("credentials unchanged with a role")
+	}
+}
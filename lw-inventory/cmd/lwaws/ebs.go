@@ -0,0 +1,123 @@
+package lwaws
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2Types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/lacework-dev/scripts/lw-inventory/report"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	EBS_VOLUMES = "EBS Volumes"
+	//--only-services/--skip-services name of the EBS volume and snapshot scan
+	EBS_SIZING = "ebs"
+)
+
+// EBSVolumes are the volumes attached to the running instances of a region
+// and the snapshots the account owns there
+type EBSVolumes struct {
+	Region  string
+	Volumes *report.Volumes
+}
+
+// getEBSVolumesByRegion sizes the volumes of running instances that pass the
+// tag filter, and the snapshots whose own tags pass it
+func getEBSVolumesByRegion(ctx context.Context, service EC2API, region string, tags *tagScan) (EBSVolumes, error) {
+	ebs := EBSVolumes{Region: region, Volumes: report.NewVolumes()}
+
+	running := make(map[string]bool)
+	instances := ec2.NewDescribeInstancesPaginator(service, &ec2.DescribeInstancesInput{
+		Filters: []ec2Types.Filter{{Name: aws.String("instance-state-name"), Values: []string{"running"}}},
+	})
+	for instances.HasMorePages() {
+		page, err := instances.NextPage(ctx)
+		if err != nil {
+			log.Errorln("getEBSVolumesByRegion DescribeInstances ", region, err)
+			return ebs, fmt.Errorf("DescribeInstances: %w", err)
+		}
+		for _, r := range page.Reservations {
+			for _, i := range r.Instances {
+				if tags.match(ec2Tags(i.Tags)) {
+					running[aws.ToString(i.InstanceId)] = true
+				}
+			}
+		}
+	}
+
+	volumes := ec2.NewDescribeVolumesPaginator(service, &ec2.DescribeVolumesInput{
+		Filters: []ec2Types.Filter{{Name: aws.String("attachment.status"), Values: []string{"attached"}}},
+	})
+	for volumes.HasMorePages() {
+		page, err := volumes.NextPage(ctx)
+		if err != nil {
+			log.Errorln("getEBSVolumesByRegion DescribeVolumes ", region, err)
+			return ebs, fmt.Errorf("DescribeVolumes: %w", err)
+		}
+		for _, v := range page.Volumes {
+			if attachedToRunning(v, running) {
+				addVolume(ebs.Volumes, v)
+			}
+		}
+	}
+
+	snapshots := ec2.NewDescribeSnapshotsPaginator(service, &ec2.DescribeSnapshotsInput{OwnerIds: []string{"self"}})
+	for snapshots.HasMorePages() {
+		page, err := snapshots.NextPage(ctx)
+		if err != nil {
+			log.Errorln("getEBSVolumesByRegion DescribeSnapshots ", region, err)
+			return ebs, fmt.Errorf("DescribeSnapshots: %w", err)
+		}
+		for _, s := range page.Snapshots {
+			if tags.match(ec2Tags(s.Tags)) {
+				ebs.Volumes.Snapshots++
+				ebs.Volumes.SnapshotGiB += int(aws.ToInt32(s.VolumeSize))
+			}
+		}
+	}
+
+	return ebs, nil
+}
+
+// attachedToRunning reports whether a volume is attached to one of the
+// running instances, multi-attach volumes can have several
+func attachedToRunning(v ec2Types.Volume, running map[string]bool) bool {
+	for _, a := range v.Attachments {
+		if running[aws.ToString(a.InstanceId)] {
+			return true
+		}
+	}
+	return false
+}
+
+func addVolume(volumes *report.Volumes, v ec2Types.Volume) {
+	volumes.Count++
+	volumes.SizeGiB += int(aws.ToInt32(v.Size))
+	volumes.Types[string(v.VolumeType)]++
+	if aws.ToBool(v.Encrypted) {
+		volumes.Encrypted++
+		if key := aws.ToString(v.KmsKeyId); key != "" {
+			volumes.KMSKeys[key]++
+		}
+	}
+}
+
+func printVolumes(out io.Writer, volumes *report.Volumes) {
+	fmt.Fprintln(out, "\nEBS Volumes of Running Instances")
+	fmt.Fprintf(out, "Volumes: %d (%d GiB)\n", volumes.Count, volumes.SizeGiB)
+	fmt.Fprintf(out, "Encrypted Volumes: %d with %d KMS key(s)\n", volumes.Encrypted, len(volumes.KMSKeys))
+	var types []string
+	for t := range volumes.Types {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	for _, t := range types {
+		fmt.Fprintf(out, "%s Volumes: %d\n", t, volumes.Types[t])
+	}
+	fmt.Fprintf(out, "Snapshots: %d (%d GiB)\n", volumes.Snapshots, volumes.SnapshotGiB)
+}
@@ -0,0 +1,97 @@
+package lwaws
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ec2Types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/lacework-dev/scripts/lw-inventory/helpers"
+	"github.com/lacework-dev/scripts/lw-inventory/report"
+)
+
+func attachedVolume(instanceId string, size int32, volumeType ec2Types.VolumeType, kmsKey string) ec2Types.Volume {
+	v := ec2Types.Volume{
+		Size:        aws.Int32(size),
+		VolumeType:  volumeType,
+		Encrypted:   aws.Bool(kmsKey != ""),
+		Attachments: []ec2Types.VolumeAttachment{{InstanceId: aws.String(instanceId)}},
+	}
+	if kmsKey != "" {
+		v.KmsKeyId = aws.String(kmsKey)
+	}
+	return v
+}
+
+var ebsEC2 = &fakeEC2{
+	reservations: [][]ec2Types.Reservation{{{Instances: []ec2Types.Instance{
+		{InstanceId: aws.String("i-web"), Tags: []ec2Types.Tag{{Key: aws.String("env"), Value: aws.String("prod")}}},
+		{InstanceId: aws.String("i-batch")},
+	}}}},
+	volumes: [][]ec2Types.Volume{
+		{attachedVolume("i-web", 100, ec2Types.VolumeTypeGp3, "arn:aws:kms:us-east-1:123456789012:key/a")},
+		//i-stopped isn't running, its volume is left out
+		{attachedVolume("i-batch", 500, ec2Types.VolumeTypeSt1, ""), attachedVolume("i-stopped", 8, ec2Types.VolumeTypeGp2, "")},
+	},
+	snapshots: [][]ec2Types.Snapshot{
+		{{VolumeSize: aws.Int32(100), Tags: []ec2Types.Tag{{Key: aws.String("env"), Value: aws.String("prod")}}}},
+		{{VolumeSize: aws.Int32(30)}},
+	},
+}
+
+func TestGetEBSVolumesByRegion(t *testing.T) {
+	got, err := getEBSVolumesByRegion(ctx, ebsEC2, "us-east-1", nil)
+	if err != nil {
+		t.Fatalf("getEBSVolumesByRegion() error = %v", err)
+	}
+	want := &report.Volumes{
+		Count:       2,
+		SizeGiB:     600,
+		Encrypted:   1,
+		Types:       map[string]int{"gp3": 1, "st1": 1},
+		KMSKeys:     map[string]int{"arn:aws:kms:us-east-1:123456789012:key/a": 1},
+		Snapshots:   2,
+		SnapshotGiB: 130,
+	}
+	if got.Region != "us-east-1" || !reflect.DeepEqual(got.Volumes, want) {
+		t.Errorf("got %s %+v, want %+v", got.Region, got.Volumes, want)
+	}
+
+	for _, op := range []string{"DescribeInstances", "DescribeVolumes", "DescribeSnapshots"} {
+		service := &fakeEC2{errs: map[string]error{op: errAccessDenied}}
+		if _, err := getEBSVolumesByRegion(ctx, service, "us-east-1", nil); !errors.Is(err, errAccessDenied) {
+			t.Errorf("%s error = %v, want %v", op, err, errAccessDenied)
+		}
+	}
+}
+
+func TestGetEBSVolumesTagFilter(t *testing.T) {
+	prod := newTagScan(helpers.TagFilter{Include: []helpers.Tag{{Key: "env", Value: "prod"}}}, "")
+
+	got, err := getEBSVolumesByRegion(ctx, ebsEC2, "us-east-1", prod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := got.Volumes; v.Count != 1 || v.SizeGiB != 100 || v.Snapshots != 1 || v.SnapshotGiB != 100 {
+		t.Errorf("got %+v, want the prod volume and snapshot", v)
+	}
+}
+
+func TestScanEBSVolumes(t *testing.T) {
+	useClients(t, Clients{EC2: ebsEC2})
+
+	_, results, failures := scanRegions(ctx, helpers.NewScheduler(2), regionScans(aws.Config{}, []string{"us-east-1", "eu-west-1"}, RegionScan{}), ServiceFilter{Only: []string{EBS_SIZING}}.Counters())
+	volumes := results.Volumes
+	if len(volumes) != 2 || len(failures) != 0 {
+		t.Fatalf("got %d regions and %d failures, want volumes for both regions", len(volumes), len(failures))
+	}
+
+	totals := report.NewVolumes()
+	for _, v := range volumes {
+		totals.Add(v.Volumes)
+	}
+	if totals.Count != 4 || totals.SizeGiB != 1200 || totals.Types["gp3"] != 2 || totals.Snapshots != 4 {
+		t.Errorf("totals = %+v, want both regions added up", totals)
+	}
+}
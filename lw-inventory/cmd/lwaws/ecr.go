@@ -0,0 +1,61 @@
+package lwaws
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/lacework-dev/scripts/lw-inventory/report"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	ECR_REPOSITORIES = "ECR Repositories"
+	//--only-services/--skip-services name of the container registry scan
+	ECR_IMAGES = "ecr"
+)
+
+// ECRRepositories are the repositories of the registry of a region, with the
+// images pushed since the cutoff
+type ECRRepositories struct {
+	Region       string
+	Repositories []report.Repository
+}
+
+// getECRRepositoriesByRegion counts the images of each repository pushed
+// after the cutoff and their tags, untagged images count with no tags
+func getECRRepositoriesByRegion(ctx context.Context, service ECRAPI, region string, cutoff time.Time) (ECRRepositories, error) {
+	ecrRepositories := ECRRepositories{Region: region}
+
+	repositories := ecr.NewDescribeRepositoriesPaginator(service, &ecr.DescribeRepositoriesInput{})
+	for repositories.HasMorePages() {
+		page, err := repositories.NextPage(ctx)
+		if err != nil {
+			log.Errorln("getECRRepositoriesByRegion DescribeRepositories ", region, err)
+			return ecrRepositories, fmt.Errorf("DescribeRepositories: %w", err)
+		}
+		for _, r := range page.Repositories {
+			repo := report.Repository{Registry: aws.ToString(r.RegistryId), Name: aws.ToString(r.RepositoryName)}
+
+			images := ecr.NewDescribeImagesPaginator(service, &ecr.DescribeImagesInput{RepositoryName: r.RepositoryName, RegistryId: r.RegistryId})
+			for images.HasMorePages() {
+				page, err := images.NextPage(ctx)
+				if err != nil {
+					log.Errorln("getECRRepositoriesByRegion DescribeImages ", region, err)
+					return ecrRepositories, fmt.Errorf("DescribeImages: %w", err)
+				}
+				for _, i := range page.ImageDetails {
+					if i.ImagePushedAt != nil && i.ImagePushedAt.After(cutoff) {
+						repo.Images++
+						repo.Tags += len(i.ImageTags)
+					}
+				}
+			}
+			ecrRepositories.Repositories = append(ecrRepositories.Repositories, repo)
+		}
+	}
+
+	return ecrRepositories, nil
+}
@@ -0,0 +1,70 @@
+package lwaws
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ecrTypes "github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	"github.com/lacework-dev/scripts/lw-inventory/helpers"
+	"github.com/lacework-dev/scripts/lw-inventory/report"
+)
+
+var ecrCutoff = time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+func pushedImage(daysAfterCutoff int, tags ...string) ecrTypes.ImageDetail {
+	return ecrTypes.ImageDetail{ImagePushedAt: aws.Time(ecrCutoff.AddDate(0, 0, daysAfterCutoff)), ImageTags: tags}
+}
+
+var ecrRegistry = &fakeECR{
+	repositories: [][]ecrTypes.Repository{
+		{{RegistryId: aws.String("123456789012"), RepositoryName: aws.String("web")}},
+		{{RegistryId: aws.String("123456789012"), RepositoryName: aws.String("batch")}},
+	},
+	images: map[string][][]ecrTypes.ImageDetail{
+		"web": {
+			{pushedImage(1, "v2", "latest"), pushedImage(3)},
+			//pushed before the cutoff
+			{pushedImage(-10, "v1")},
+		},
+		"batch": {{pushedImage(-1, "old")}},
+	},
+}
+
+func TestGetECRRepositoriesByRegion(t *testing.T) {
+	got, err := getECRRepositoriesByRegion(ctx, ecrRegistry, "us-east-1", ecrCutoff)
+	if err != nil {
+		t.Fatalf("getECRRepositoriesByRegion() error = %v", err)
+	}
+	want := []report.Repository{
+		{Registry: "123456789012", Name: "web", Images: 2, Tags: 2},
+		{Registry: "123456789012", Name: "batch"},
+	}
+	if got.Region != "us-east-1" || !reflect.DeepEqual(got.Repositories, want) {
+		t.Errorf("got %s %+v, want %+v", got.Region, got.Repositories, want)
+	}
+
+	for _, op := range []string{"DescribeRepositories", "DescribeImages"} {
+		service := &fakeECR{repositories: ecrRegistry.repositories, errs: map[string]error{op: errAccessDenied}}
+		if _, err := getECRRepositoriesByRegion(ctx, service, "us-east-1", ecrCutoff); !errors.Is(err, errAccessDenied) {
+			t.Errorf("%s error = %v, want %v", op, err, errAccessDenied)
+		}
+	}
+}
+
+func TestScanECRRepositories(t *testing.T) {
+	useClients(t, Clients{ECR: ecrRegistry})
+
+	_, results, failures := scanRegions(ctx, helpers.NewScheduler(2), regionScans(aws.Config{}, []string{"us-east-1", "eu-west-1"}, RegionScan{ImageCutoff: ecrCutoff}), ServiceFilter{Only: []string{ECR_IMAGES}}.Counters())
+	repositories := results.Repositories
+	if len(repositories) != 2 || len(failures) != 0 {
+		t.Fatalf("got %d regions and %d failures, want repositories for both regions", len(repositories), len(failures))
+	}
+	for _, r := range repositories {
+		if len(r.Repositories) != 2 {
+			t.Errorf("%s has %d repositories, want 2", r.Region, len(r.Repositories))
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package lwaws
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	ecsTypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/lacework-dev/scripts/lw-inventory/helpers"
+	log "github.com/sirupsen/logrus"
+)
+
+// ECSSnapshot is every cluster of a region with its running tasks and
+// container instances, listed once and shared by all ECS metrics
+type ECSSnapshot struct {
+	Region             string
+	Clusters           []ecsTypes.Cluster
+	Tasks              []ecsTypes.Task
+	ContainerInstances []ecsTypes.ContainerInstance
+}
+
+// RunningFargateTasks are the tasks launched on Fargate that are running
+func (s *ECSSnapshot) RunningFargateTasks() []ecsTypes.Task {
+	var tasks []ecsTypes.Task
+	for _, t := range s.Tasks {
+		if t.LaunchType == ecsTypes.LaunchTypeFargate && ecsTypes.DesiredStatus(aws.ToString(t.LastStatus)) == ecsTypes.DesiredStatusRunning {
+			tasks = append(tasks, t)
+		}
+	}
+	return tasks
+}
+
+// ECS_CRAWL is the service a failed ECS crawl is reported under
+const ECS_CRAWL = "ECS"
+
+// crawlECSRegions crawls ECS once in every region and sets the snapshot of
+// each scan, for the counters with ECS set to read. A failed crawl is reported
+// here, the snapshot keeps what was listed and the counters don't report it
+// again
+func crawlECSRegions(ctx context.Context, scheduler *helpers.Scheduler, scans []RegionScan) []ScanFailure {
+	log.Debugf("start crawlECSRegions\n")
+	start := time.Now()
+
+	var tasks []scanTask[*ECSSnapshot]
+	for i := range scans {
+		scan := scans[i]
+		//regions the crawl doesn't get to are empty
+		scans[i].ECS = &ECSSnapshot{Region: scan.Region}
+		tasks = append(tasks, scanTask[*ECSSnapshot]{Region: scan.Region, Service: ECS_CRAWL, Scan: func(ctx context.Context) (*ECSSnapshot, error) {
+			return crawlECS(ctx, scan.Clients.ECS, scan.Region)
+		}})
+	}
+	snapshots, failures := runScanTasks(ctx, scheduler, tasks)
+	for _, snapshot := range snapshots {
+		for i := range scans {
+			if scans[i].Region == snapshot.Region {
+				scans[i].ECS = snapshot
+			}
+		}
+	}
+
+	elapsed := time.Since(start)
+	log.Debugf("end crawlECSRegions - %s\n", elapsed)
+
+	return failures
+}
+
+// crawlECS lists every cluster, task and container instance of the region.
+// On error the snapshot holds what was listed up to that point
+func crawlECS(ctx context.Context, service ECSAPI, region string) (*ECSSnapshot, error) {
+	log.Debugf("start crawlECS %s\n", region)
+	start := time.Now()
+
+	snapshot := &ECSSnapshot{Region: region}
+	var clusterArns []string
+	output := ecs.NewListClustersPaginator(service, &ecs.ListClustersInput{})
+	for output.HasMorePages() {
+		page, err := output.NextPage(ctx)
+		if err != nil {
+			log.Errorln("crawlECS ListClusters ", region, err)
+			return snapshot, fmt.Errorf("ListClusters: %w", err)
+		}
+		clusterArns = append(clusterArns, page.ClusterArns...)
+	}
+
+	for start := 0; start < len(clusterArns); start += ecsDescribeBatchSize {
+		end := start + ecsDescribeBatchSize
+		if end > len(clusterArns) {
+			end = len(clusterArns)
+		}
+		output, err := service.DescribeClusters(ctx, &ecs.DescribeClustersInput{Clusters: clusterArns[start:end]})
+		if err != nil {
+			log.Errorln("crawlECS DescribeClusters ", region, err)
+			return snapshot, fmt.Errorf("DescribeClusters: %w", err)
+		}
+		snapshot.Clusters = append(snapshot.Clusters, output.Clusters...)
+	}
+
+	for _, cluster := range clusterArns {
+		if err := crawlECSTasks(ctx, service, cluster, snapshot); err != nil {
+			log.Errorln("crawlECS ", region, err)
+			return snapshot, err
+		}
+		if err := crawlECSContainerInstances(ctx, service, cluster, snapshot); err != nil {
+			log.Errorln("crawlECS ", region, err)
+			return snapshot, err
+		}
+	}
+
+	elapsed := time.Since(start)
+	log.Debugf("end crawlECS %s - %d clusters, %d tasks, %d container instances - %s\n", region, len(snapshot.Clusters), len(snapshot.Tasks), len(snapshot.ContainerInstances), elapsed)
+	return snapshot, nil
+}
+
+func crawlECSTasks(ctx context.Context, service ECSAPI, cluster string, snapshot *ECSSnapshot) error {
+	//ListTasks pages hold at most 100 tasks, so every page is one DescribeTasks call
+	output := ecs.NewListTasksPaginator(service, &ecs.ListTasksInput{
+		Cluster:    aws.String(cluster),
+		MaxResults: aws.Int32(ecsDescribeBatchSize),
+	})
+	for output.HasMorePages() {
+		page, err := output.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("ListTasks %s: %w", cluster, err)
+		}
+		if len(page.TaskArns) == 0 {
+			continue
+		}
+
+		outputDT, err := service.DescribeTasks(ctx, &ecs.DescribeTasksInput{
+			Cluster: aws.String(cluster),
+			Tasks:   page.TaskArns,
+		})
+		if err != nil {
+			return fmt.Errorf("DescribeTasks %s: %w", cluster, err)
+		}
+		snapshot.Tasks = append(snapshot.Tasks, outputDT.Tasks...)
+	}
+	return nil
+}
+
+func crawlECSContainerInstances(ctx context.Context, service ECSAPI, cluster string, snapshot *ECSSnapshot) error {
+	output := ecs.NewListContainerInstancesPaginator(service, &ecs.ListContainerInstancesInput{
+		Cluster:    aws.String(cluster),
+		MaxResults: aws.Int32(ecsDescribeBatchSize),
+	})
+	for output.HasMorePages() {
+		page, err := output.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("ListContainerInstances %s: %w", cluster, err)
+		}
+		if len(page.ContainerInstanceArns) == 0 {
+			continue
+		}
+
+		outputDCI, err := service.DescribeContainerInstances(ctx, &ecs.DescribeContainerInstancesInput{
+			Cluster:            aws.String(cluster),
+			ContainerInstances: page.ContainerInstanceArns,
+		})
+		if err != nil {
+			return fmt.Errorf("DescribeContainerInstances %s: %w", cluster, err)
+		}
+		snapshot.ContainerInstances = append(snapshot.ContainerInstances, outputDCI.ContainerInstances...)
+	}
+	return nil
+}
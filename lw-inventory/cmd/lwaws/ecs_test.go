@@ -0,0 +1,150 @@
+package lwaws
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ecsTypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/lacework-dev/scripts/lw-inventory/helpers"
+)
+
+func arns(prefix string, n int) []string {
+	a := make([]string, n)
+	for i := range a {
+		a[i] = fmt.Sprintf("%s-%d", prefix, i)
+	}
+	return a
+}
+
+func TestCrawlECS(t *testing.T) {
+	clusters := arns("cluster", 150)
+	service := &fakeECS{
+		clusters: [][]string{clusters[:100], clusters[100:]},
+		tasks: map[string][][]string{
+			"cluster-0":   {arns("task-a", 100), arns("task-b", 100)},
+			"cluster-149": {arns("task-c", 1)},
+		},
+		containerInstances: map[string][][]string{
+			"cluster-0": {arns("ci-a", 100), arns("ci-b", 20)},
+		},
+	}
+
+	snapshot, err := crawlECS(ctx, service, "us-east-1")
+	if err != nil {
+		t.Fatalf("crawlECS() error = %v", err)
+	}
+	if len(snapshot.Clusters) != 150 || len(snapshot.Tasks) != 201 || len(snapshot.ContainerInstances) != 120 {
+		t.Errorf("got %d clusters, %d tasks, %d container instances, want 150, 201, 120", len(snapshot.Clusters), len(snapshot.Tasks), len(snapshot.ContainerInstances))
+	}
+
+	//every cluster is listed once and Describe calls are batched per 100
+	for op, want := range map[string]int{"ListClusters": 2, "DescribeClusters": 2, "ListTasks": 151, "DescribeTasks": 3, "ListContainerInstances": 151, "DescribeContainerInstances": 2} {
+		if service.calls[op] != want {
+			t.Errorf("%s calls = %d, want %d", op, service.calls[op], want)
+		}
+	}
+}
+
+func TestCrawlECSError(t *testing.T) {
+	service := &fakeECS{
+		clusters:           [][]string{{"cluster-a"}},
+		tasks:              map[string][][]string{"cluster-a": {{"task-1"}}},
+		containerInstances: map[string][][]string{"cluster-a": {{"ci-1"}}},
+		errs:               map[string]error{"ListContainerInstances": errAccessDenied},
+	}
+
+	snapshot, err := crawlECS(ctx, service, "us-east-1")
+	if !errors.Is(err, errAccessDenied) {
+		t.Errorf("error = %v, want %v", err, errAccessDenied)
+	}
+	if len(snapshot.Clusters) != 1 || len(snapshot.Tasks) != 1 {
+		t.Errorf("partial snapshot = %+v, want the cluster and task listed before the error", snapshot)
+	}
+}
+
+// crawlSnapshot crawls the fake in us-east-1
+func crawlSnapshot(t *testing.T, service *fakeECS) *ECSSnapshot {
+	t.Helper()
+	snapshot, err := crawlECS(ctx, service, "us-east-1")
+	if err != nil {
+		t.Fatalf("crawlECS() error = %v", err)
+	}
+	return snapshot
+}
+
+func ecsCounters() []Counter {
+	var ecs []Counter
+	for _, c := range counters {
+		if c.ECS {
+			ecs = append(ecs, c)
+		}
+	}
+	return ecs
+}
+
+func TestCrawlECSRegions(t *testing.T) {
+	service := &fakeECS{
+		clusters: [][]string{{"cluster-a"}},
+		clusterDetails: map[string]ecsTypes.Cluster{
+			"cluster-a": {ActiveServicesCount: 2},
+		},
+		tasks:       map[string][][]string{"cluster-a": {{"task-1"}}},
+		taskDetails: map[string]ecsTypes.Task{"task-1": sizedTask("cluster-a", "RUNNING", "1024", "2048")},
+	}
+	useClients(t, Clients{EC2: &fakeEC2{}, ECS: service})
+
+	scheduler := helpers.NewScheduler(2)
+	scans := regionScans(aws.Config{}, []string{"us-east-1", "eu-west-1"}, RegionScan{})
+	if failures := crawlECSRegions(ctx, scheduler, scans); len(failures) != 0 {
+		t.Fatalf("crawl failures = %+v, want none", failures)
+	}
+	for _, scan := range scans {
+		if scan.ECS == nil || scan.ECS.Region != scan.Region || len(scan.ECS.Tasks) != 1 {
+			t.Errorf("%s snapshot = %+v, want the crawl of the region", scan.Region, scan.ECS)
+		}
+	}
+
+	//every ECS counter reads the snapshot instead of crawling again
+	counts, results, failures := scanRegions(ctx, scheduler, scans, ecsCounters())
+	if len(failures) != 0 {
+		t.Fatalf("failures = %+v, want none", failures)
+	}
+	if service.calls["ListClusters"] != 2 || service.calls["DescribeTasks"] != 2 {
+		t.Errorf("got %d ListClusters and %d DescribeTasks calls, want one crawl per region", service.calls["ListClusters"], service.calls["DescribeTasks"])
+	}
+	for _, c := range counts {
+		if c.Service == FARGATE_ACTIVE_SERVICES && c.Count != 2 {
+			t.Errorf("%s %s = %d, want 2", c.Region, c.Service, c.Count)
+		}
+	}
+	if len(results.Fargate) != 2 {
+		t.Errorf("Fargate sizing = %+v, want a cluster per region", results.Fargate)
+	}
+}
+
+func TestCrawlECSRegionsReportsOnce(t *testing.T) {
+	useClients(t, Clients{EC2: &fakeEC2{}, ECS: &fakeECS{
+		clusters: [][]string{{"cluster-a"}},
+		errs:     map[string]error{"DescribeClusters": errAccessDenied},
+	}})
+
+	scheduler := helpers.NewScheduler(2)
+	scans := regionScans(aws.Config{}, []string{"us-east-1", "eu-west-1"}, RegionScan{})
+	failures := crawlECSRegions(ctx, scheduler, scans)
+	if len(failures) != 2 {
+		t.Fatalf("crawl failures = %+v, want one per region", failures)
+	}
+	for _, f := range failures {
+		if f.Service != ECS_CRAWL || !errors.Is(f.Err, errAccessDenied) {
+			t.Errorf("failure = %+v, want %s %v", f, ECS_CRAWL, errAccessDenied)
+		}
+	}
+
+	//the counters read the failed crawl without reporting it again
+	_, _, failures = scanRegions(ctx, scheduler, scans, ecsCounters())
+	if len(failures) != 0 {
+		t.Errorf("counter failures = %+v, want none", failures)
+	}
+}
@@ -0,0 +1,313 @@
+package lwaws
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	"github.com/aws/aws-sdk-go-v2/service/eks"
+	"github.com/lacework-dev/scripts/lw-inventory/helpers"
+	"github.com/lacework-dev/scripts/lw-inventory/report"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	EKS_CLUSTER    = "EKS Cluster"
+	EKS_NODE_GROUP = "EKS Managed Node Group"
+	//--only-services/--skip-services name of the EKS cluster scan
+	EKS_CLUSTERS = "eks"
+	//service a failed EKS cluster listing is reported under
+	EKS_LIST = "EKS"
+	//every node of a cluster carries kubernetes.io/cluster/<name>, managed
+	//nodes also carry eks:cluster-name and eks:nodegroup-name
+	eksClusterTagPrefix = "kubernetes.io/cluster/"
+	eksClusterNameTag   = "eks:cluster-name"
+	eksNodeGroupNameTag = "eks:nodegroup-name"
+	//DescribeAutoScalingGroups is called with at most 50 group names
+	autoScalingGroupsBatchSize = 50
+)
+
+type EKSCluster struct {
+	Region          string
+	Name            string
+	Version         string
+	PlatformVersion string
+	PublicEndpoint  bool
+	PrivateEndpoint bool
+	NodeGroups      []EKSNodeGroup
+}
+
+// EKSNodeGroup is a managed node group, InstanceIDs are the current
+// instances of its Auto Scaling groups
+type EKSNodeGroup struct {
+	Name          string
+	DesiredSize   int
+	MinSize       int
+	MaxSize       int
+	AMIType       string
+	CapacityType  string
+	InstanceTypes []string
+	InstanceIDs   []string
+}
+
+// listEKSClusterRegions lists the EKS clusters of every region once and sets
+// the cluster names of each scan, for the counters with EKS set to read. A
+// failed listing is reported here, the names keep what was listed
+func listEKSClusterRegions(ctx context.Context, scheduler *helpers.Scheduler, scans []RegionScan) []ScanFailure {
+	log.Debugf("start listEKSClusterRegions\n")
+	start := time.Now()
+
+	var tasks []scanTask[eksClusterNames]
+	for _, scan := range scans {
+		scan := scan
+		tasks = append(tasks, scanTask[eksClusterNames]{Region: scan.Region, Service: EKS_LIST, Scan: func(ctx context.Context) (eksClusterNames, error) {
+			names, err := listEKSClusters(ctx, scan.Clients.EKS, scan.Region)
+			return eksClusterNames{Region: scan.Region, Names: names}, err
+		}})
+	}
+	clusters, failures := runScanTasks(ctx, scheduler, tasks)
+	for _, c := range clusters {
+		for i := range scans {
+			if scans[i].Region == c.Region {
+				scans[i].EKSClusters = c.Names
+			}
+		}
+	}
+
+	elapsed := time.Since(start)
+	log.Debugf("end listEKSClusterRegions - %s\n", elapsed)
+
+	return failures
+}
+
+type eksClusterNames struct {
+	Region string
+	Names  []string
+}
+
+func listEKSClusters(ctx context.Context, service EKSAPI, region string) ([]string, error) {
+	var names []string
+	output := eks.NewListClustersPaginator(service, &eks.ListClustersInput{})
+	for output.HasMorePages() {
+		page, err := output.NextPage(ctx)
+		if err != nil {
+			log.Errorln("listEKSClusters ListClusters ", region, err)
+			return names, fmt.Errorf("ListClusters: %w", err)
+		}
+		names = append(names, page.Clusters...)
+	}
+	return names, nil
+}
+
+func getEKSClustersByRegion(ctx context.Context, service EKSAPI, asgService AutoScalingAPI, region string, names []string) ([]EKSCluster, error) {
+	var clusters []EKSCluster
+	for _, name := range names {
+		cluster, err := getEKSCluster(ctx, service, asgService, region, name)
+		clusters = append(clusters, cluster)
+		if err != nil {
+			log.Errorln("getEKSClustersByRegion ", region, err)
+			return clusters, err
+		}
+	}
+
+	return clusters, nil
+}
+
+func getEKSCluster(ctx context.Context, service EKSAPI, asgService AutoScalingAPI, region string, name string) (EKSCluster, error) {
+	cluster := EKSCluster{Region: region, Name: name}
+	outputDC, err := service.DescribeCluster(ctx, &eks.DescribeClusterInput{Name: aws.String(name)})
+	if err != nil {
+		return cluster, fmt.Errorf("DescribeCluster %s: %w", name, err)
+	}
+	if c := outputDC.Cluster; c != nil {
+		cluster.Version = aws.ToString(c.Version)
+		cluster.PlatformVersion = aws.ToString(c.PlatformVersion)
+		if c.ResourcesVpcConfig != nil {
+			cluster.PublicEndpoint = c.ResourcesVpcConfig.EndpointPublicAccess
+			cluster.PrivateEndpoint = c.ResourcesVpcConfig.EndpointPrivateAccess
+		}
+	}
+
+	//node group instances are only known through their Auto Scaling groups
+	asgNodeGroups := make(map[string]int)
+	var asgNames []string
+	output := eks.NewListNodegroupsPaginator(service, &eks.ListNodegroupsInput{ClusterName: aws.String(name)})
+	for output.HasMorePages() {
+		page, err := output.NextPage(ctx)
+		if err != nil {
+			return cluster, fmt.Errorf("ListNodegroups %s: %w", name, err)
+		}
+
+		for _, ng := range page.Nodegroups {
+			outputDN, err := service.DescribeNodegroup(ctx, &eks.DescribeNodegroupInput{ClusterName: aws.String(name), NodegroupName: aws.String(ng)})
+			if err != nil {
+				return cluster, fmt.Errorf("DescribeNodegroup %s/%s: %w", name, ng, err)
+			}
+			nodeGroup := EKSNodeGroup{Name: ng}
+			if n := outputDN.Nodegroup; n != nil {
+				nodeGroup.AMIType = string(n.AmiType)
+				nodeGroup.CapacityType = string(n.CapacityType)
+				nodeGroup.InstanceTypes = n.InstanceTypes
+				if s := n.ScalingConfig; s != nil {
+					nodeGroup.DesiredSize = int(aws.ToInt32(s.DesiredSize))
+					nodeGroup.MinSize = int(aws.ToInt32(s.MinSize))
+					nodeGroup.MaxSize = int(aws.ToInt32(s.MaxSize))
+				}
+				if n.Resources != nil {
+					for _, asg := range n.Resources.AutoScalingGroups {
+						asgNodeGroups[aws.ToString(asg.Name)] = len(cluster.NodeGroups)
+						asgNames = append(asgNames, aws.ToString(asg.Name))
+					}
+				}
+			}
+			cluster.NodeGroups = append(cluster.NodeGroups, nodeGroup)
+		}
+	}
+
+	for start := 0; start < len(asgNames); start += autoScalingGroupsBatchSize {
+		end := start + autoScalingGroupsBatchSize
+		if end > len(asgNames) {
+			end = len(asgNames)
+		}
+
+		output := autoscaling.NewDescribeAutoScalingGroupsPaginator(asgService, &autoscaling.DescribeAutoScalingGroupsInput{AutoScalingGroupNames: asgNames[start:end]})
+		for output.HasMorePages() {
+			page, err := output.NextPage(ctx)
+			if err != nil {
+				return cluster, fmt.Errorf("DescribeAutoScalingGroups %s: %w", name, err)
+			}
+			for _, asg := range page.AutoScalingGroups {
+				i, ok := asgNodeGroups[aws.ToString(asg.AutoScalingGroupName)]
+				if !ok {
+					continue
+				}
+				for _, instance := range asg.Instances {
+					cluster.NodeGroups[i].InstanceIDs = append(cluster.NodeGroups[i].InstanceIDs, aws.ToString(instance.InstanceId))
+				}
+			}
+		}
+	}
+
+	return cluster, nil
+}
+
+// eksClusterTag returns the cluster named by the tags of an EC2 instance
+func eksClusterTag(key string, value string) (string, bool) {
+	if key == eksClusterNameTag || key == "aws:"+eksClusterNameTag {
+		return value, true
+	}
+	if strings.HasPrefix(key, eksClusterTagPrefix) {
+		return strings.TrimPrefix(key, eksClusterTagPrefix), true
+	}
+	return "", false
+}
+
+// reconcileEKSNodes marks the EC2 instances of managed node groups, and the
+// self-managed nodes tagged for a cluster, as enterprise agents
+func reconcileEKSNodes(vms []VMInfo, clusters []EKSCluster) []VMInfo {
+	type nodeGroupRef struct {
+		cluster   string
+		nodeGroup string
+	}
+	//instance IDs are only unique within a region
+	managed := make(map[string]nodeGroupRef)
+	for _, c := range clusters {
+		for _, ng := range c.NodeGroups {
+			for _, id := range ng.InstanceIDs {
+				managed[c.Region+"/"+id] = nodeGroupRef{cluster: c.Name, nodeGroup: ng.Name}
+			}
+		}
+	}
+
+	for i, vm := range vms {
+		if ref, ok := managed[vm.Region+"/"+vm.InstanceID]; ok {
+			vms[i].EKSCluster = ref.cluster
+			vms[i].EKSNodeGroup = ref.nodeGroup
+		}
+		if vms[i].EKSCluster != "" {
+			vms[i].AgentType = ENTERPRISE_AGENT
+		}
+	}
+	return vms
+}
+
+// eksNodeCounts counts the reconciled nodes of every cluster and node group,
+// keyed by region, cluster and node group with self-managed nodes under ""
+func eksNodeCounts(vms []VMInfo) map[string]int {
+	nodes := make(map[string]int)
+	for _, vm := range vms {
+		if vm.EKSCluster != "" {
+			nodes[vm.Region+"/"+vm.EKSCluster+"/"+vm.EKSNodeGroup]++
+		}
+	}
+	return nodes
+}
+
+// reportEKSClusters adds the clusters to their regions and to the totals,
+// with the nodes of the reconciled VMs
+func reportEKSClusters(account *report.Account, clusters []EKSCluster, vms []VMInfo, totals *Totals) {
+	nodes := eksNodeCounts(vms)
+	for _, c := range clusters {
+		region := account.Region(c.Region)
+		region.AddService(EKS_CLUSTER, report.CategoryContainer, 1)
+		if len(c.NodeGroups) > 0 {
+			region.AddService(EKS_NODE_GROUP, report.CategoryContainer, len(c.NodeGroups))
+		}
+		cluster := kubernetesCluster(c, nodes)
+		region.KubernetesClusters = append(region.KubernetesClusters, cluster)
+		totals.EKS.add(cluster)
+	}
+}
+
+func kubernetesCluster(c EKSCluster, nodes map[string]int) report.KubernetesCluster {
+	k := report.KubernetesCluster{
+		Name:             c.Name,
+		Version:          c.Version,
+		PlatformVersion:  c.PlatformVersion,
+		PublicEndpoint:   c.PublicEndpoint,
+		PrivateEndpoint:  c.PrivateEndpoint,
+		NodeGroups:       []report.NodeGroup{},
+		SelfManagedNodes: nodes[c.Region+"/"+c.Name+"/"],
+	}
+	for _, ng := range c.NodeGroups {
+		k.NodeGroups = append(k.NodeGroups, report.NodeGroup{
+			Name:          ng.Name,
+			DesiredSize:   ng.DesiredSize,
+			MinSize:       ng.MinSize,
+			MaxSize:       ng.MaxSize,
+			AMIType:       ng.AMIType,
+			CapacityType:  ng.CapacityType,
+			InstanceTypes: ng.InstanceTypes,
+			Nodes:         nodes[c.Region+"/"+c.Name+"/"+ng.Name],
+		})
+	}
+	return k
+}
+
+type eksTotals struct {
+	Clusters         int
+	NodeGroups       int
+	ManagedNodes     int
+	SelfManagedNodes int
+}
+
+func (t *eksTotals) add(c report.KubernetesCluster) {
+	t.Clusters++
+	t.NodeGroups += len(c.NodeGroups)
+	for _, ng := range c.NodeGroups {
+		t.ManagedNodes += ng.Nodes
+	}
+	t.SelfManagedNodes += c.SelfManagedNodes
+}
+
+func printEKS(out io.Writer, t eksTotals) {
+	fmt.Fprintln(out, "\nEKS Counts")
+	fmt.Fprintf(out, "EKS Clusters: %d\n", t.Clusters)
+	fmt.Fprintf(out, "EKS Managed Node Groups: %d\n", t.NodeGroups)
+	fmt.Fprintf(out, "EKS Managed Nodes: %d\n", t.ManagedNodes)
+	fmt.Fprintf(out, "EKS Self-managed Nodes: %d\n", t.SelfManagedNodes)
+}
@@ -0,0 +1,185 @@
+package lwaws
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	asgTypes "github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
+	ec2Types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	eksTypes "github.com/aws/aws-sdk-go-v2/service/eks/types"
+	"github.com/lacework-dev/scripts/lw-inventory/helpers"
+)
+
+func autoScalingGroup(name string, instanceIDs ...string) asgTypes.AutoScalingGroup {
+	g := asgTypes.AutoScalingGroup{AutoScalingGroupName: aws.String(name)}
+	for _, id := range instanceIDs {
+		g.Instances = append(g.Instances, asgTypes.Instance{InstanceId: aws.String(id)})
+	}
+	return g
+}
+
+func TestGetEKSClustersByRegion(t *testing.T) {
+	service := &fakeEKS{
+		clusters: [][]string{{"prod"}, {"dev"}},
+		clusterDetails: map[string]eksTypes.Cluster{
+			"prod": {
+				Version:            aws.String("1.27"),
+				PlatformVersion:    aws.String("eks.4"),
+				ResourcesVpcConfig: &eksTypes.VpcConfigResponse{EndpointPrivateAccess: true},
+			},
+			"dev": {Version: aws.String("1.26")},
+		},
+		nodeGroups: map[string][][]string{"prod": {{"general"}, {"spot"}}},
+		nodeGroupDetails: map[string]eksTypes.Nodegroup{
+			"prod/general": {
+				AmiType:       eksTypes.AMITypesAl2X8664,
+				CapacityType:  eksTypes.CapacityTypesOnDemand,
+				InstanceTypes: []string{"m5.large"},
+				ScalingConfig: &eksTypes.NodegroupScalingConfig{DesiredSize: aws.Int32(2), MinSize: aws.Int32(1), MaxSize: aws.Int32(4)},
+				Resources:     &eksTypes.NodegroupResources{AutoScalingGroups: []eksTypes.AutoScalingGroup{{Name: aws.String("asg-general")}}},
+			},
+			"prod/spot": {
+				AmiType:       eksTypes.AMITypesBottlerocketArm64,
+				CapacityType:  eksTypes.CapacityTypesSpot,
+				InstanceTypes: []string{"m6g.large", "m6g.xlarge"},
+				Resources:     &eksTypes.NodegroupResources{AutoScalingGroups: []eksTypes.AutoScalingGroup{{Name: aws.String("asg-spot-a")}, {Name: aws.String("asg-spot-b")}}},
+			},
+		},
+	}
+	asg := &fakeAutoScaling{groups: map[string]asgTypes.AutoScalingGroup{
+		"asg-general": autoScalingGroup("asg-general", "i-1", "i-2"),
+		"asg-spot-a":  autoScalingGroup("asg-spot-a", "i-3"),
+		"asg-spot-b":  autoScalingGroup("asg-spot-b", "i-4"),
+	}}
+
+	got, err := getEKSClustersByRegion(ctx, service, asg, "us-east-1", []string{"prod", "dev"})
+	if err != nil {
+		t.Fatalf("getEKSClustersByRegion() error = %v", err)
+	}
+	want := []EKSCluster{
+		{
+			Region: "us-east-1", Name: "prod", Version: "1.27", PlatformVersion: "eks.4", PrivateEndpoint: true,
+			NodeGroups: []EKSNodeGroup{
+				{Name: "general", DesiredSize: 2, MinSize: 1, MaxSize: 4, AMIType: "AL2_x86_64", CapacityType: "ON_DEMAND", InstanceTypes: []string{"m5.large"}, InstanceIDs: []string{"i-1", "i-2"}},
+				{Name: "spot", AMIType: "BOTTLEROCKET_ARM_64", CapacityType: "SPOT", InstanceTypes: []string{"m6g.large", "m6g.xlarge"}, InstanceIDs: []string{"i-3", "i-4"}},
+			},
+		},
+		{Region: "us-east-1", Name: "dev", Version: "1.26"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	asg.err = errAccessDenied
+	if got, err := getEKSClustersByRegion(ctx, service, asg, "us-east-1", []string{"prod", "dev"}); len(got) != 1 || !errors.Is(err, errAccessDenied) {
+		t.Errorf("on DescribeAutoScalingGroups error got %d clusters, %v, want the partial cluster and %v", len(got), err, errAccessDenied)
+	}
+}
+
+func TestScanEKSClusters(t *testing.T) {
+	service := &fakeEKS{clusters: [][]string{{"prod"}}, fargateProfiles: map[string][][]string{"prod": {{"fp-1"}}}}
+	useClients(t, Clients{EKS: service, AutoScaling: &fakeAutoScaling{}})
+
+	scheduler := helpers.NewScheduler(2)
+	scans := regionScans(aws.Config{}, []string{"us-east-1", "eu-west-1"}, RegionScan{})
+	filter := ServiceFilter{Only: []string{EKS_CLUSTERS, "eks-fargate-profiles"}}
+	if !filter.EKS() {
+		t.Fatalf("EKS() = false, want true")
+	}
+	if failures := listEKSClusterRegions(ctx, scheduler, scans); len(failures) != 0 {
+		t.Fatalf("listing failures = %+v, want none", failures)
+	}
+
+	//the cluster and Fargate profile scans share the listing of each region
+	counts, results, failures := scanRegions(ctx, scheduler, scans, filter.Counters())
+	if clusters := results.EKSClusters; len(clusters) != 2 || len(failures) != 0 {
+		t.Errorf("got %d clusters and %d failures, want one cluster per region", len(clusters), len(failures))
+	}
+	for _, c := range counts {
+		if c.Count != 1 {
+			t.Errorf("%s %s = %d, want 1", c.Region, c.Service, c.Count)
+		}
+	}
+	if service.listClusters != 2 {
+		t.Errorf("got %d ListClusters calls, want one per region", service.listClusters)
+	}
+}
+
+func TestListEKSClusterRegionsFailure(t *testing.T) {
+	useClients(t, Clients{EKS: &fakeEKS{errs: map[string]error{"ListClusters": errAccessDenied}}})
+
+	failures := listEKSClusterRegions(ctx, helpers.NewScheduler(2), regionScans(aws.Config{}, []string{"us-east-1"}, RegionScan{}))
+	if len(failures) != 1 || failures[0].Service != EKS_LIST || !errors.Is(failures[0].Err, errAccessDenied) {
+		t.Errorf("failures = %+v, want the listing reported once", failures)
+	}
+}
+
+func TestGetEC2InstancesEKSTags(t *testing.T) {
+	managed := instance("i-managed", "Linux/UNIX")
+	managed.Tags = []ec2Types.Tag{
+		{Key: aws.String("eks:cluster-name"), Value: aws.String("prod")},
+		{Key: aws.String("eks:nodegroup-name"), Value: aws.String("general")},
+	}
+	selfManaged := instance("i-self", "Linux/UNIX")
+	selfManaged.Tags = []ec2Types.Tag{{Key: aws.String("kubernetes.io/cluster/prod"), Value: aws.String("owned")}}
+
+	service := &fakeEC2{reservations: [][]ec2Types.Reservation{{reservation("123", managed, selfManaged, instance("i-plain", "Linux/UNIX"))}}}
+	vms, err := getEC2InstancesByRegion(ctx, service, "us-east-1", nil)
+	if err != nil {
+		t.Fatalf("getEC2InstancesByRegion() error = %v", err)
+	}
+
+	got := make(map[string][2]string)
+	for _, vm := range vms {
+		got[vm.InstanceID] = [2]string{vm.EKSCluster, vm.EKSNodeGroup}
+	}
+	want := map[string][2]string{"i-managed": {"prod", "general"}, "i-self": {"prod", ""}, "i-plain": {"", ""}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestReconcileEKSNodes(t *testing.T) {
+	clusters := []EKSCluster{{
+		Region: "us-east-1",
+		Name:   "prod",
+		NodeGroups: []EKSNodeGroup{
+			{Name: "general", InstanceIDs: []string{"i-untagged", "i-gone"}},
+			{Name: "empty"},
+		},
+	}}
+	vms := []VMInfo{
+		{Region: "us-east-1", InstanceID: "i-untagged", AgentType: STANDARD_AGENT},
+		{Region: "eu-west-1", InstanceID: "i-untagged", AgentType: STANDARD_AGENT},
+		{Region: "us-east-1", InstanceID: "i-self", AgentType: STANDARD_AGENT, EKSCluster: "prod"},
+		{Region: "us-east-1", InstanceID: "i-plain", AgentType: STANDARD_AGENT},
+	}
+
+	vms = reconcileEKSNodes(vms, clusters)
+	got := make(map[string]string)
+	for _, vm := range vms {
+		got[vm.Region+"/"+vm.InstanceID] = vm.AgentType + " " + vm.EKSCluster + "/" + vm.EKSNodeGroup
+	}
+	want := map[string]string{
+		"us-east-1/i-untagged": ENTERPRISE_AGENT + " prod/general",
+		"eu-west-1/i-untagged": STANDARD_AGENT + " /",
+		"us-east-1/i-self":     ENTERPRISE_AGENT + " prod/",
+		"us-east-1/i-plain":    STANDARD_AGENT + " /",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	cluster := kubernetesCluster(clusters[0], eksNodeCounts(vms))
+	if cluster.SelfManagedNodes != 1 || cluster.NodeGroups[0].Nodes != 1 || cluster.NodeGroups[1].Nodes != 0 {
+		t.Errorf("cluster = %+v, want 1 self-managed node and 1 node in general", cluster)
+	}
+
+	var totals eksTotals
+	totals.add(cluster)
+	if want := (eksTotals{Clusters: 1, NodeGroups: 2, ManagedNodes: 1, SelfManagedNodes: 1}); totals != want {
+		t.Errorf("totals = %+v, want %+v", totals, want)
+	}
+}
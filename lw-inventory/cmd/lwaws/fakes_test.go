@@ -0,0 +1,453 @@
+package lwaws
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	asgTypes "github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2Types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	ecrTypes "github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	ecsTypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/aws/aws-sdk-go-v2/service/eks"
+	eksTypes "github.com/aws/aws-sdk-go-v2/service/eks/types"
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancing"
+	elbTypes "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancing/types"
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	elbv2Types "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	lambdaTypes "github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"github.com/aws/aws-sdk-go-v2/service/organizations"
+	orgTypes "github.com/aws/aws-sdk-go-v2/service/organizations/types"
+	"github.com/aws/aws-sdk-go-v2/service/rds"
+	rdsTypes "github.com/aws/aws-sdk-go-v2/service/rds/types"
+	"github.com/aws/aws-sdk-go-v2/service/redshift"
+	redshiftTypes "github.com/aws/aws-sdk-go-v2/service/redshift/types"
+)
+
+// The fakes serve canned pages, using the page index as the pagination token
+
+func pageIndex(token *string) int {
+	if token == nil {
+		return 0
+	}
+	i, _ := strconv.Atoi(*token)
+	return i
+}
+
+func nextToken(i int, pages int) *string {
+	if i+1 < pages {
+		return aws.String(strconv.Itoa(i + 1))
+	}
+	return nil
+}
+
+func page[T any](pages [][]T, token *string) ([]T, *string) {
+	if len(pages) == 0 {
+		return nil, nil
+	}
+	i := pageIndex(token)
+	return pages[i], nextToken(i, len(pages))
+}
+
+type fakeEC2 struct {
+	reservations [][]ec2Types.Reservation
+	natGateways  [][]ec2Types.NatGateway
+	regions      []ec2Types.Region
+	vcpus        map[string]int32
+	images       map[string]ec2Types.Image
+	volumes      [][]ec2Types.Volume
+	snapshots    [][]ec2Types.Snapshot
+	errs         map[string]error
+}
+
+func (f *fakeEC2) DescribeInstances(ctx context.Context, params *ec2.DescribeInstancesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error) {
+	if err := f.errs["DescribeInstances"]; err != nil {
+		return nil, err
+	}
+	p, next := page(f.reservations, params.NextToken)
+	return &ec2.DescribeInstancesOutput{Reservations: p, NextToken: next}, nil
+}
+
+func (f *fakeEC2) DescribeNatGateways(ctx context.Context, params *ec2.DescribeNatGatewaysInput, optFns ...func(*ec2.Options)) (*ec2.DescribeNatGatewaysOutput, error) {
+	if err := f.errs["DescribeNatGateways"]; err != nil {
+		return nil, err
+	}
+	p, next := page(f.natGateways, params.NextToken)
+	return &ec2.DescribeNatGatewaysOutput{NatGateways: p, NextToken: next}, nil
+}
+
+func (f *fakeEC2) DescribeInstanceTypes(ctx context.Context, params *ec2.DescribeInstanceTypesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstanceTypesOutput, error) {
+	if err := f.errs["DescribeInstanceTypes"]; err != nil {
+		return nil, err
+	}
+	var types []ec2Types.InstanceTypeInfo
+	for _, t := range params.InstanceTypes {
+		if vcpus, ok := f.vcpus[string(t)]; ok {
+			types = append(types, ec2Types.InstanceTypeInfo{InstanceType: t, VCpuInfo: &ec2Types.VCpuInfo{DefaultVCpus: aws.Int32(vcpus)}})
+		}
+	}
+	return &ec2.DescribeInstanceTypesOutput{InstanceTypes: types}, nil
+}
+
+// DescribeImages serves the images named by the image-id filter, skipping
+// the ones it doesn't know like the real API
+func (f *fakeEC2) DescribeImages(ctx context.Context, params *ec2.DescribeImagesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeImagesOutput, error) {
+	if err := f.errs["DescribeImages"]; err != nil {
+		return nil, err
+	}
+	output := &ec2.DescribeImagesOutput{}
+	for _, filter := range params.Filters {
+		if aws.ToString(filter.Name) != "image-id" {
+			continue
+		}
+		if len(filter.Values) > 200 {
+			return nil, fmt.Errorf("DescribeImages: %d filter values, at most 200 are allowed", len(filter.Values))
+		}
+		for _, id := range filter.Values {
+			if image, ok := f.images[id]; ok {
+				image.ImageId = aws.String(id)
+				output.Images = append(output.Images, image)
+			}
+		}
+	}
+	return output, nil
+}
+
+func (f *fakeEC2) DescribeVolumes(ctx context.Context, params *ec2.DescribeVolumesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeVolumesOutput, error) {
+	if err := f.errs["DescribeVolumes"]; err != nil {
+		return nil, err
+	}
+	p, next := page(f.volumes, params.NextToken)
+	return &ec2.DescribeVolumesOutput{Volumes: p, NextToken: next}, nil
+}
+
+func (f *fakeEC2) DescribeSnapshots(ctx context.Context, params *ec2.DescribeSnapshotsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeSnapshotsOutput, error) {
+	if err := f.errs["DescribeSnapshots"]; err != nil {
+		return nil, err
+	}
+	p, next := page(f.snapshots, params.NextToken)
+	return &ec2.DescribeSnapshotsOutput{Snapshots: p, NextToken: next}, nil
+}
+
+func (f *fakeEC2) DescribeRegions(ctx context.Context, params *ec2.DescribeRegionsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeRegionsOutput, error) {
+	if err := f.errs["DescribeRegions"]; err != nil {
+		return nil, err
+	}
+	return &ec2.DescribeRegionsOutput{Regions: f.regions}, nil
+}
+
+type fakeECR struct {
+	repositories [][]ecrTypes.Repository
+	//image pages by repository name
+	images map[string][][]ecrTypes.ImageDetail
+	errs   map[string]error
+}
+
+func (f *fakeECR) DescribeRepositories(ctx context.Context, params *ecr.DescribeRepositoriesInput, optFns ...func(*ecr.Options)) (*ecr.DescribeRepositoriesOutput, error) {
+	if err := f.errs["DescribeRepositories"]; err != nil {
+		return nil, err
+	}
+	p, next := page(f.repositories, params.NextToken)
+	return &ecr.DescribeRepositoriesOutput{Repositories: p, NextToken: next}, nil
+}
+
+func (f *fakeECR) DescribeImages(ctx context.Context, params *ecr.DescribeImagesInput, optFns ...func(*ecr.Options)) (*ecr.DescribeImagesOutput, error) {
+	if err := f.errs["DescribeImages"]; err != nil {
+		return nil, err
+	}
+	p, next := page(f.images[aws.ToString(params.RepositoryName)], params.NextToken)
+	return &ecr.DescribeImagesOutput{ImageDetails: p, NextToken: next}, nil
+}
+
+type fakeECS struct {
+	clusters           [][]string
+	clusterDetails     map[string]ecsTypes.Cluster
+	tasks              map[string][][]string
+	taskDetails        map[string]ecsTypes.Task
+	taskDefinitions    [][]string
+	containerInstances map[string][][]string
+	instanceDetails    map[string]ecsTypes.ContainerInstance
+	errs               map[string]error
+
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+// call records an API call and fails it like the real API when a Describe
+// call is given more than 100 items
+func (f *fakeECS) call(op string, items int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.calls == nil {
+		f.calls = make(map[string]int)
+	}
+	f.calls[op]++
+	if items > 100 {
+		return fmt.Errorf("%s: %d items, at most 100 are allowed", op, items)
+	}
+	return f.errs[op]
+}
+
+func (f *fakeECS) ListClusters(ctx context.Context, params *ecs.ListClustersInput, optFns ...func(*ecs.Options)) (*ecs.ListClustersOutput, error) {
+	if err := f.call("ListClusters", 0); err != nil {
+		return nil, err
+	}
+	p, next := page(f.clusters, params.NextToken)
+	return &ecs.ListClustersOutput{ClusterArns: p, NextToken: next}, nil
+}
+
+func (f *fakeECS) ListTasks(ctx context.Context, params *ecs.ListTasksInput, optFns ...func(*ecs.Options)) (*ecs.ListTasksOutput, error) {
+	if err := f.call("ListTasks", 0); err != nil {
+		return nil, err
+	}
+	p, next := page(f.tasks[aws.ToString(params.Cluster)], params.NextToken)
+	return &ecs.ListTasksOutput{TaskArns: p, NextToken: next}, nil
+}
+
+func (f *fakeECS) ListTaskDefinitions(ctx context.Context, params *ecs.ListTaskDefinitionsInput, optFns ...func(*ecs.Options)) (*ecs.ListTaskDefinitionsOutput, error) {
+	if err := f.call("ListTaskDefinitions", 0); err != nil {
+		return nil, err
+	}
+	p, next := page(f.taskDefinitions, params.NextToken)
+	return &ecs.ListTaskDefinitionsOutput{TaskDefinitionArns: p, NextToken: next}, nil
+}
+
+func (f *fakeECS) ListContainerInstances(ctx context.Context, params *ecs.ListContainerInstancesInput, optFns ...func(*ecs.Options)) (*ecs.ListContainerInstancesOutput, error) {
+	if err := f.call("ListContainerInstances", 0); err != nil {
+		return nil, err
+	}
+	p, next := page(f.containerInstances[aws.ToString(params.Cluster)], params.NextToken)
+	return &ecs.ListContainerInstancesOutput{ContainerInstanceArns: p, NextToken: next}, nil
+}
+
+func (f *fakeECS) DescribeClusters(ctx context.Context, params *ecs.DescribeClustersInput, optFns ...func(*ecs.Options)) (*ecs.DescribeClustersOutput, error) {
+	if err := f.call("DescribeClusters", len(params.Clusters)); err != nil {
+		return nil, err
+	}
+	output := &ecs.DescribeClustersOutput{}
+	for _, c := range params.Clusters {
+		output.Clusters = append(output.Clusters, f.clusterDetails[c])
+	}
+	return output, nil
+}
+
+func (f *fakeECS) DescribeTasks(ctx context.Context, params *ecs.DescribeTasksInput, optFns ...func(*ecs.Options)) (*ecs.DescribeTasksOutput, error) {
+	if err := f.call("DescribeTasks", len(params.Tasks)); err != nil {
+		return nil, err
+	}
+	output := &ecs.DescribeTasksOutput{}
+	for _, t := range params.Tasks {
+		output.Tasks = append(output.Tasks, f.taskDetails[t])
+	}
+	return output, nil
+}
+
+func (f *fakeECS) DescribeContainerInstances(ctx context.Context, params *ecs.DescribeContainerInstancesInput, optFns ...func(*ecs.Options)) (*ecs.DescribeContainerInstancesOutput, error) {
+	if err := f.call("DescribeContainerInstances", len(params.ContainerInstances)); err != nil {
+		return nil, err
+	}
+	output := &ecs.DescribeContainerInstancesOutput{}
+	for _, i := range params.ContainerInstances {
+		output.ContainerInstances = append(output.ContainerInstances, f.instanceDetails[i])
+	}
+	return output, nil
+}
+
+type fakeEKS struct {
+	clusters         [][]string
+	clusterDetails   map[string]eksTypes.Cluster
+	fargateProfiles  map[string][][]string
+	nodeGroups       map[string][][]string
+	nodeGroupDetails map[string]eksTypes.Nodegroup
+	errs             map[string]error
+
+	mu           sync.Mutex
+	listClusters int
+}
+
+func (f *fakeEKS) ListClusters(ctx context.Context, params *eks.ListClustersInput, optFns ...func(*eks.Options)) (*eks.ListClustersOutput, error) {
+	f.mu.Lock()
+	f.listClusters++
+	f.mu.Unlock()
+	if err := f.errs["ListClusters"]; err != nil {
+		return nil, err
+	}
+	p, next := page(f.clusters, params.NextToken)
+	return &eks.ListClustersOutput{Clusters: p, NextToken: next}, nil
+}
+
+func (f *fakeEKS) ListFargateProfiles(ctx context.Context, params *eks.ListFargateProfilesInput, optFns ...func(*eks.Options)) (*eks.ListFargateProfilesOutput, error) {
+	if err := f.errs["ListFargateProfiles"]; err != nil {
+		return nil, err
+	}
+	p, next := page(f.fargateProfiles[aws.ToString(params.ClusterName)], params.NextToken)
+	return &eks.ListFargateProfilesOutput{FargateProfileNames: p, NextToken: next}, nil
+}
+
+func (f *fakeEKS) ListNodegroups(ctx context.Context, params *eks.ListNodegroupsInput, optFns ...func(*eks.Options)) (*eks.ListNodegroupsOutput, error) {
+	if err := f.errs["ListNodegroups"]; err != nil {
+		return nil, err
+	}
+	p, next := page(f.nodeGroups[aws.ToString(params.ClusterName)], params.NextToken)
+	return &eks.ListNodegroupsOutput{Nodegroups: p, NextToken: next}, nil
+}
+
+func (f *fakeEKS) DescribeCluster(ctx context.Context, params *eks.DescribeClusterInput, optFns ...func(*eks.Options)) (*eks.DescribeClusterOutput, error) {
+	if err := f.errs["DescribeCluster"]; err != nil {
+		return nil, err
+	}
+	c := f.clusterDetails[aws.ToString(params.Name)]
+	return &eks.DescribeClusterOutput{Cluster: &c}, nil
+}
+
+// DescribeNodegroup looks node groups up by cluster/name
+func (f *fakeEKS) DescribeNodegroup(ctx context.Context, params *eks.DescribeNodegroupInput, optFns ...func(*eks.Options)) (*eks.DescribeNodegroupOutput, error) {
+	if err := f.errs["DescribeNodegroup"]; err != nil {
+		return nil, err
+	}
+	n := f.nodeGroupDetails[aws.ToString(params.ClusterName)+"/"+aws.ToString(params.NodegroupName)]
+	return &eks.DescribeNodegroupOutput{Nodegroup: &n}, nil
+}
+
+// fakeAutoScaling serves the groups named in the request, a page per group
+type fakeAutoScaling struct {
+	groups map[string]asgTypes.AutoScalingGroup
+	err    error
+}
+
+func (f *fakeAutoScaling) DescribeAutoScalingGroups(ctx context.Context, params *autoscaling.DescribeAutoScalingGroupsInput, optFns ...func(*autoscaling.Options)) (*autoscaling.DescribeAutoScalingGroupsOutput, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	var pages [][]asgTypes.AutoScalingGroup
+	for _, name := range params.AutoScalingGroupNames {
+		if g, ok := f.groups[name]; ok {
+			pages = append(pages, []asgTypes.AutoScalingGroup{g})
+		}
+	}
+	p, next := page(pages, params.NextToken)
+	return &autoscaling.DescribeAutoScalingGroupsOutput{AutoScalingGroups: p, NextToken: next}, nil
+}
+
+type fakeRDS struct {
+	instances [][]rdsTypes.DBInstance
+	err       error
+}
+
+func (f *fakeRDS) DescribeDBInstances(ctx context.Context, params *rds.DescribeDBInstancesInput, optFns ...func(*rds.Options)) (*rds.DescribeDBInstancesOutput, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	p, next := page(f.instances, params.Marker)
+	return &rds.DescribeDBInstancesOutput{DBInstances: p, Marker: next}, nil
+}
+
+type fakeRedshift struct {
+	clusters [][]redshiftTypes.Cluster
+	err      error
+}
+
+func (f *fakeRedshift) DescribeClusters(ctx context.Context, params *redshift.DescribeClustersInput, optFns ...func(*redshift.Options)) (*redshift.DescribeClustersOutput, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	p, next := page(f.clusters, params.Marker)
+	return &redshift.DescribeClustersOutput{Clusters: p, Marker: next}, nil
+}
+
+type fakeELB struct {
+	loadBalancers [][]elbTypes.LoadBalancerDescription
+	tags          map[string][]elbTypes.Tag
+	err           error
+}
+
+func (f *fakeELB) DescribeTags(ctx context.Context, params *elasticloadbalancing.DescribeTagsInput, optFns ...func(*elasticloadbalancing.Options)) (*elasticloadbalancing.DescribeTagsOutput, error) {
+	if len(params.LoadBalancerNames) > 20 {
+		return nil, fmt.Errorf("DescribeTags: %d load balancers, at most 20 are allowed", len(params.LoadBalancerNames))
+	}
+	output := &elasticloadbalancing.DescribeTagsOutput{}
+	for _, name := range params.LoadBalancerNames {
+		output.TagDescriptions = append(output.TagDescriptions, elbTypes.TagDescription{LoadBalancerName: aws.String(name), Tags: f.tags[name]})
+	}
+	return output, nil
+}
+
+func (f *fakeELB) DescribeLoadBalancers(ctx context.Context, params *elasticloadbalancing.DescribeLoadBalancersInput, optFns ...func(*elasticloadbalancing.Options)) (*elasticloadbalancing.DescribeLoadBalancersOutput, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	p, next := page(f.loadBalancers, params.Marker)
+	return &elasticloadbalancing.DescribeLoadBalancersOutput{LoadBalancerDescriptions: p, NextMarker: next}, nil
+}
+
+type fakeELBv2 struct {
+	loadBalancers [][]elbv2Types.LoadBalancer
+	tags          map[string][]elbv2Types.Tag
+	err           error
+	tagsErr       error
+}
+
+func (f *fakeELBv2) DescribeTags(ctx context.Context, params *elasticloadbalancingv2.DescribeTagsInput, optFns ...func(*elasticloadbalancingv2.Options)) (*elasticloadbalancingv2.DescribeTagsOutput, error) {
+	if f.tagsErr != nil {
+		return nil, f.tagsErr
+	}
+	if len(params.ResourceArns) > 20 {
+		return nil, fmt.Errorf("DescribeTags: %d load balancers, at most 20 are allowed", len(params.ResourceArns))
+	}
+	output := &elasticloadbalancingv2.DescribeTagsOutput{}
+	for _, arn := range params.ResourceArns {
+		output.TagDescriptions = append(output.TagDescriptions, elbv2Types.TagDescription{ResourceArn: aws.String(arn), Tags: f.tags[arn]})
+	}
+	return output, nil
+}
+
+func (f *fakeELBv2) DescribeLoadBalancers(ctx context.Context, params *elasticloadbalancingv2.DescribeLoadBalancersInput, optFns ...func(*elasticloadbalancingv2.Options)) (*elasticloadbalancingv2.DescribeLoadBalancersOutput, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	p, next := page(f.loadBalancers, params.Marker)
+	return &elasticloadbalancingv2.DescribeLoadBalancersOutput{LoadBalancers: p, NextMarker: next}, nil
+}
+
+type fakeLambda struct {
+	functions [][]lambdaTypes.FunctionConfiguration
+	err       error
+}
+
+func (f *fakeLambda) ListFunctions(ctx context.Context, params *lambda.ListFunctionsInput, optFns ...func(*lambda.Options)) (*lambda.ListFunctionsOutput, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	p, next := page(f.functions, params.Marker)
+	return &lambda.ListFunctionsOutput{Functions: p, NextMarker: next}, nil
+}
+
+// useClients points newClients at fakes for the duration of a test
+type fakeOrganizations struct {
+	accounts [][]orgTypes.Account
+	err      error
+}
+
+func (f *fakeOrganizations) ListAccounts(ctx context.Context, params *organizations.ListAccountsInput, optFns ...func(*organizations.Options)) (*organizations.ListAccountsOutput, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	p, next := page(f.accounts, params.NextToken)
+	return &organizations.ListAccountsOutput{Accounts: p, NextToken: next}, nil
+}
+
+func useClients(t *testing.T, clients Clients) {
+	original := newClients
+	newClients = func(cfg aws.Config) Clients {
+		return clients
+	}
+	t.Cleanup(func() { newClients = original })
+}
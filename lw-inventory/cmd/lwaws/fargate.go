@@ -0,0 +1,79 @@
+package lwaws
+
+import (
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/lacework-dev/scripts/lw-inventory/report"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	FARGATE_VCPUS = "Fargate vCPUs"
+	//--only-services/--skip-services name of the Fargate sizing scan
+	FARGATE_SIZING = "fargate-vcpus"
+	//ECS task cpu is in cpu units, 1024 units are one vCPU
+	FARGATE_CPU_UNITS_PER_VCPU = 1024
+)
+
+// FargateSizing sums the task level cpu and memory of the running Fargate
+// tasks of a cluster
+type FargateSizing struct {
+	Region   string
+	Cluster  string
+	Tasks    int
+	CPUUnits int
+	MemoryMB int
+}
+
+func (s FargateSizing) VCPUs() float64 {
+	return float64(s.CPUUnits) / FARGATE_CPU_UNITS_PER_VCPU
+}
+
+// getFargateSizingByRegion returns one entry per cluster with running Fargate
+// tasks, in the order clusters are listed
+func getFargateSizingByRegion(snapshot *ECSSnapshot) []FargateSizing {
+	var sizing []FargateSizing
+	clusters := make(map[string]int)
+	for _, t := range snapshot.RunningFargateTasks() {
+		cluster := aws.ToString(t.ClusterArn)
+		i, ok := clusters[cluster]
+		if !ok {
+			i = len(sizing)
+			clusters[cluster] = i
+			sizing = append(sizing, FargateSizing{Region: snapshot.Region, Cluster: cluster})
+		}
+		sizing[i].Tasks++
+		sizing[i].CPUUnits += parseTaskSize(t.Cpu)
+		sizing[i].MemoryMB += parseTaskSize(t.Memory)
+	}
+
+	return sizing
+}
+
+// reportFargateSizing adds the sizing of every cluster to its region and to
+// the totals
+func reportFargateSizing(account *report.Account, sizing []FargateSizing, totals *Totals) {
+	for _, s := range sizing {
+		region := account.Region(s.Region)
+		region.ServerlessContainers = append(region.ServerlessContainers, fargateContainerSizing(s))
+		totals.Fargate.Tasks += s.Tasks
+		totals.Fargate.CPUUnits += s.CPUUnits
+		totals.Fargate.MemoryMB += s.MemoryMB
+	}
+}
+
+// parseTaskSize reads the cpu units or MiB of a described task, which are
+// always numeric strings once the task is running
+func parseTaskSize(size *string) int {
+	n, err := strconv.Atoi(aws.ToString(size))
+	if err != nil {
+		log.Debugf("unexpected task size %q\n", aws.ToString(size))
+		return 0
+	}
+	return n
+}
+
+func fargateContainerSizing(s FargateSizing) report.ContainerSizing {
+	return report.ContainerSizing{Cluster: s.Cluster, Tasks: s.Tasks, VCPUs: s.VCPUs(), MemoryMB: s.MemoryMB}
+}
@@ -0,0 +1,60 @@
+package lwaws
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ecsTypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+func sizedTask(cluster string, lastStatus string, cpu string, memory string) ecsTypes.Task {
+	t := fargateTask(lastStatus)
+	t.ClusterArn = aws.String(cluster)
+	t.Cpu = aws.String(cpu)
+	t.Memory = aws.String(memory)
+	return t
+}
+
+func TestGetFargateSizingByRegion(t *testing.T) {
+	ec2Task := sizedTask("cluster-a", "RUNNING", "4096", "8192")
+	ec2Task.LaunchType = ecsTypes.LaunchTypeEc2
+
+	service := &fakeECS{
+		clusters: [][]string{{"cluster-a", "cluster-b"}},
+		tasks: map[string][][]string{
+			"cluster-a": {{"small", "stopped"}, {"large", "ec2"}},
+			"cluster-b": {{"quarter"}},
+		},
+		taskDetails: map[string]ecsTypes.Task{
+			"small":   sizedTask("cluster-a", "RUNNING", "512", "1024"),
+			"stopped": sizedTask("cluster-a", "STOPPED", "1024", "2048"),
+			"large":   sizedTask("cluster-a", "RUNNING", "2048", "4096"),
+			"ec2":     ec2Task,
+			"quarter": sizedTask("cluster-b", "RUNNING", "256", "512"),
+		},
+	}
+
+	got := getFargateSizingByRegion(crawlSnapshot(t, service))
+	want := []FargateSizing{
+		{Region: "us-east-1", Cluster: "cluster-a", Tasks: 2, CPUUnits: 2560, MemoryMB: 5120},
+		{Region: "us-east-1", Cluster: "cluster-b", Tasks: 1, CPUUnits: 256, MemoryMB: 512},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if got[0].VCPUs() != 2.5 || got[1].VCPUs() != 0.25 {
+		t.Errorf("vCPUs = %v, %v, want 2.5, 0.25", got[0].VCPUs(), got[1].VCPUs())
+	}
+}
+
+func TestParseTaskSize(t *testing.T) {
+	for size, want := range map[string]int{"1024": 1024, "": 0, "1 vCPU": 0} {
+		if got := parseTaskSize(aws.String(size)); got != want {
+			t.Errorf("parseTaskSize(%q) = %d, want %d", size, got, want)
+		}
+	}
+	if got := parseTaskSize(nil); got != 0 {
+		t.Errorf("parseTaskSize(nil) = %d, want 0", got)
+	}
+}
@@ -0,0 +1,100 @@
+package lwaws
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/lacework-dev/scripts/lw-inventory/report"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	LAMBDA = "Lambda Function"
+	//--only-services/--skip-services name of the Lambda scan
+	LAMBDA_FUNCTIONS = "lambda"
+	//Lambda allocates one full vCPU at 1,769 MB of configured memory. This
+	//sizes configured functions as if always running, lw-billing converts
+	//billed GB-seconds instead so the two estimates differ
+	LAMBDA_MB_PER_VCPU = 1769
+)
+
+type LambdaFunction struct {
+	Region       string
+	Name         string
+	Runtime      string
+	Architecture string
+	MemorySize   int
+	PackageType  string
+}
+
+func getLambdaFunctionsByRegion(ctx context.Context, service LambdaAPI, region string) ([]LambdaFunction, error) {
+	output := lambda.NewListFunctionsPaginator(service, &lambda.ListFunctionsInput{})
+
+	var functions []LambdaFunction
+	for output.HasMorePages() {
+		page, err := output.NextPage(ctx)
+		if err != nil {
+			log.Errorln("getLambdaFunctionsByRegion ListFunctions ", region, err)
+			return functions, fmt.Errorf("ListFunctions: %w", err)
+		}
+
+		for _, f := range page.Functions {
+			//functions without an architecture predate arm64 support
+			architecture := "x86_64"
+			if len(f.Architectures) > 0 {
+				architecture = string(f.Architectures[0])
+			}
+			//container image functions have no runtime
+			runtime := string(f.Runtime)
+			if runtime == "" {
+				runtime = "none"
+			}
+			functions = append(functions, LambdaFunction{
+				Region:       region,
+				Name:         aws.ToString(f.FunctionName),
+				Runtime:      runtime,
+				Architecture: architecture,
+				MemorySize:   int(aws.ToInt32(f.MemorySize)),
+				PackageType:  string(f.PackageType),
+			})
+		}
+	}
+
+	return functions, nil
+}
+
+// reportLambdaFunctions adds the functions to the regions of the account and
+// to the totals
+func reportLambdaFunctions(account *report.Account, functions []LambdaFunction, totals *Totals, vcpus bool) {
+	for _, f := range functions {
+		region := account.Region(f.Region)
+		region.AddService(LAMBDA, report.CategoryServerless, 1)
+		if region.Functions == nil {
+			region.Functions = report.NewFunctions()
+		}
+		summarizeLambdaFunctions(region.Functions, f, vcpus)
+		totals.LambdaFunctions++
+		totals.LambdaVCPUs += lambdaVCPUs(f.MemorySize)
+	}
+}
+
+// summarizeLambdaFunctions adds the functions of one region to its report
+// summary, with the vCPU equivalent of the configured memory when asked for
+func summarizeLambdaFunctions(summary *report.Functions, f LambdaFunction, vcpus bool) {
+	summary.Count++
+	summary.MemoryMB += f.MemorySize
+	if vcpus {
+		summary.VCPUs += lambdaVCPUs(f.MemorySize)
+	}
+	summary.Runtimes[f.Runtime]++
+	summary.Architectures[f.Architecture]++
+	summary.PackageTypes[f.PackageType]++
+}
+
+// lambdaVCPUs is the vCPU share of a function with the given memory, the
+// most it can use while running
+func lambdaVCPUs(memorySize int) float64 {
+	return float64(memorySize) / LAMBDA_MB_PER_VCPU
+}
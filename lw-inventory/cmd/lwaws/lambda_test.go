@@ -0,0 +1,80 @@
+package lwaws
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	lambdaTypes "github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"github.com/lacework-dev/scripts/lw-inventory/helpers"
+	"github.com/lacework-dev/scripts/lw-inventory/report"
+)
+
+func TestGetLambdaFunctionsByRegion(t *testing.T) {
+	service := &fakeLambda{functions: [][]lambdaTypes.FunctionConfiguration{
+		{{
+			FunctionName:  aws.String("zip-arm"),
+			Runtime:       lambdaTypes.RuntimePython39,
+			Architectures: []lambdaTypes.Architecture{lambdaTypes.ArchitectureArm64},
+			MemorySize:    aws.Int32(1769),
+			PackageType:   lambdaTypes.PackageTypeZip,
+		}},
+		{{
+			FunctionName: aws.String("image-legacy"),
+			MemorySize:   aws.Int32(512),
+			PackageType:  lambdaTypes.PackageTypeImage,
+		}},
+	}}
+
+	got, err := getLambdaFunctionsByRegion(ctx, service, "us-east-1")
+	if err != nil {
+		t.Fatalf("getLambdaFunctionsByRegion() error = %v", err)
+	}
+	want := []LambdaFunction{
+		{Region: "us-east-1", Name: "zip-arm", Runtime: "python3.9", Architecture: "arm64", MemorySize: 1769, PackageType: "Zip"},
+		{Region: "us-east-1", Name: "image-legacy", Runtime: "none", Architecture: "x86_64", MemorySize: 512, PackageType: "Image"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	if _, err := getLambdaFunctionsByRegion(ctx, &fakeLambda{err: errAccessDenied}, "us-east-1"); !errors.Is(err, errAccessDenied) {
+		t.Errorf("error = %v, want %v", err, errAccessDenied)
+	}
+}
+
+func TestScanLambdaFunctions(t *testing.T) {
+	useClients(t, Clients{Lambda: &fakeLambda{functions: [][]lambdaTypes.FunctionConfiguration{{{FunctionName: aws.String("fn")}}}}})
+
+	_, results, failures := scanRegions(ctx, helpers.NewScheduler(2), regionScans(aws.Config{}, []string{"us-east-1", "eu-west-1"}, RegionScan{}), ServiceFilter{Only: []string{LAMBDA_FUNCTIONS}}.Counters())
+	if functions := results.LambdaFunctions; len(functions) != 2 || len(failures) != 0 {
+		t.Errorf("got %d functions and %d failures, want one function per region", len(functions), len(failures))
+	}
+}
+
+func TestSummarizeLambdaFunctions(t *testing.T) {
+	functions := []LambdaFunction{
+		{Runtime: "python3.9", Architecture: "arm64", MemorySize: 1769, PackageType: "Zip"},
+		{Runtime: "nodejs18.x", Architecture: "x86_64", MemorySize: 3538, PackageType: "Zip"},
+		{Runtime: "none", Architecture: "x86_64", MemorySize: 1769, PackageType: "Image"},
+	}
+
+	summary := report.NewFunctions()
+	for _, f := range functions {
+		summarizeLambdaFunctions(summary, f, true)
+	}
+	if summary.Count != 3 || summary.MemoryMB != 7076 || math.Abs(summary.VCPUs-4) > 0.001 {
+		t.Errorf("summary = %+v, want 3 functions, 7076 MB and 4 vCPUs", summary)
+	}
+	if summary.Architectures["x86_64"] != 2 || summary.PackageTypes["Zip"] != 2 || summary.Runtimes["none"] != 1 {
+		t.Errorf("breakdowns = %v %v %v", summary.Architectures, summary.PackageTypes, summary.Runtimes)
+	}
+
+	withoutVCPUs := report.NewFunctions()
+	summarizeLambdaFunctions(withoutVCPUs, functions[0], false)
+	if withoutVCPUs.VCPUs != 0 {
+		t.Errorf("vCPU equivalent = %f without --lambda-vcpus, want 0", withoutVCPUs.VCPUs)
+	}
+}
@@ -0,0 +1,185 @@
+package lwaws
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2Types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/lacework-dev/scripts/lw-inventory/report"
+)
+
+const (
+	OS_LINUX   = "linux"
+	OS_WINDOWS = "windows"
+	OS_OTHER   = "other"
+	//distribution of Linux instances whose image can't be described
+	DISTRO_UNKNOWN = "unknown"
+	//image-id filter values looked up per DescribeImages call
+	imagesBatchSize = 100
+)
+
+// licensedDistributions are the PlatformDetails of Linux AMIs billed with a
+// license, the distribution is known without describing the image
+var licensedDistributions = []struct {
+	prefix       string
+	distribution string
+}{
+	{"Red Hat", "rhel"},
+	{"SUSE", "suse"},
+	{"Ubuntu Pro", "ubuntu"},
+}
+
+// imageDistributions match the name or description of an image, first
+// match wins
+var imageDistributions = []struct {
+	match        string
+	distribution string
+}{
+	{"bottlerocket", "bottlerocket"},
+	{"amzn", "amazon-linux"},
+	{"amazon linux", "amazon-linux"},
+	{"al2023", "amazon-linux"},
+	//EKS optimized AMIs, named amazon-eks-node-* and described as
+	//"EKS Kubernetes Worker AMI with AmazonLinux2 image"
+	{"amazon-eks", "amazon-linux"},
+	{"amazonlinux", "amazon-linux"},
+	{"ubuntu", "ubuntu"},
+	{"debian", "debian"},
+	{"centos", "centos"},
+	{"rocky", "rocky"},
+	{"almalinux", "almalinux"},
+	{"rhel", "rhel"},
+	{"red hat", "rhel"},
+	{"suse", "suse"},
+	{"sles", "suse"},
+	{"oracle", "oracle-linux"},
+	{"fedora", "fedora"},
+	{"flatcar", "flatcar"},
+}
+
+// osFamily maps PlatformDetails and architecture to an OS family, and the
+// distribution when PlatformDetails names it
+func osFamily(platformDetails string, architecture string) (string, string) {
+	switch {
+	case strings.HasSuffix(architecture, "_mac"):
+		return OS_OTHER, "macos"
+	case strings.HasPrefix(platformDetails, "Windows"):
+		return OS_WINDOWS, OS_WINDOWS
+	case platformDetails == "Linux/UNIX" || strings.HasPrefix(platformDetails, "Linux with"):
+		return OS_LINUX, DISTRO_UNKNOWN
+	}
+	for _, l := range licensedDistributions {
+		if strings.HasPrefix(platformDetails, l.prefix) {
+			return OS_LINUX, l.distribution
+		}
+	}
+	return OS_OTHER, DISTRO_UNKNOWN
+}
+
+func imageDistribution(image ec2Types.Image) string {
+	text := strings.ToLower(aws.ToString(image.Name) + " " + aws.ToString(image.Description))
+	for _, d := range imageDistributions {
+		if strings.Contains(text, d.match) {
+			return d.distribution
+		}
+	}
+	return DISTRO_UNKNOWN
+}
+
+// normalizeArchitecture drops the _mac suffix, mac instances are told apart
+// by their OS family
+func normalizeArchitecture(architecture string) string {
+	return strings.TrimSuffix(architecture, "_mac")
+}
+
+// setImageDetails records the AMI name of instances and fills in the
+// distribution of Linux instances from it. AMIs that were deregistered or
+// aren't shared with the account aren't returned, their instances keep no
+// name and an unknown distribution
+func setImageDetails(ctx context.Context, service EC2API, instances []VMInfo) error {
+	var imageIds []string
+	seen := make(map[string]bool)
+	for _, vm := range instances {
+		if vm.AMI != "" && !seen[vm.AMI] {
+			seen[vm.AMI] = true
+			imageIds = append(imageIds, vm.AMI)
+		}
+	}
+
+	images := make(map[string]ec2Types.Image)
+	for start := 0; start < len(imageIds); start += imagesBatchSize {
+		end := start + imagesBatchSize
+		if end > len(imageIds) {
+			end = len(imageIds)
+		}
+
+		//a filter, unlike ImageIds, doesn't fail the call on a missing AMI
+		output, err := service.DescribeImages(ctx, &ec2.DescribeImagesInput{
+			Filters:           []ec2Types.Filter{{Name: aws.String("image-id"), Values: imageIds[start:end]}},
+			IncludeDeprecated: aws.Bool(true),
+		})
+		if err != nil {
+			return err
+		}
+		for _, image := range output.Images {
+			images[aws.ToString(image.ImageId)] = image
+		}
+	}
+
+	for i, vm := range instances {
+		image, ok := images[vm.AMI]
+		if !ok {
+			continue
+		}
+		instances[i].ImageName = aws.ToString(image.Name)
+		if vm.OSFamily == OS_LINUX && vm.Distribution == DISTRO_UNKNOWN {
+			instances[i].Distribution = imageDistribution(image)
+		}
+	}
+	return nil
+}
+
+// addOS adds n to the counter of an OS family
+func addOS(c *OSCounts, family string, n int) {
+	switch family {
+	case OS_LINUX:
+		c.Linux += n
+	case OS_WINDOWS:
+		c.Windows += n
+	default:
+		c.Other += n
+	}
+}
+
+func plusOS(a OSCounts, b OSCounts) OSCounts {
+	return OSCounts{Linux: a.Linux + b.Linux, Windows: a.Windows + b.Windows, Other: a.Other + b.Other}
+}
+
+func vmPlatform(vm VMInfo) report.PlatformCount {
+	return report.PlatformCount{
+		AgentType:    vm.AgentType,
+		Family:       vm.OSFamily,
+		Distribution: vm.Distribution,
+		Architecture: vm.Architecture,
+		Count:        1,
+		VCPUs:        vm.VCPU,
+	}
+}
+
+func printPlatforms(out io.Writer, platforms []report.PlatformCount) {
+	sorted := append([]report.PlatformCount{}, platforms...)
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		return a.AgentType+a.Family+a.Distribution+a.Architecture < b.AgentType+b.Family+b.Distribution+b.Architecture
+	})
+
+	fmt.Fprintln(out, "\nVM Platforms")
+	for _, p := range sorted {
+		fmt.Fprintf(out, "%s %s/%s/%s: %d VMs, %d vCPUs\n", p.AgentType, p.Family, p.Distribution, p.Architecture, p.Count, p.VCPUs)
+	}
+}
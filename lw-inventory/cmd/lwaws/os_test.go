@@ -0,0 +1,129 @@
+package lwaws
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ec2Types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+func TestOSFamily(t *testing.T) {
+	tests := []struct {
+		platformDetails string
+		architecture    string
+		family          string
+		distribution    string
+	}{
+		{"Linux/UNIX", "x86_64", OS_LINUX, DISTRO_UNKNOWN},
+		{"Linux with SQL Server Standard", "x86_64", OS_LINUX, DISTRO_UNKNOWN},
+		{"Red Hat Enterprise Linux", "x86_64", OS_LINUX, "rhel"},
+		{"Red Hat BYOL Linux", "arm64", OS_LINUX, "rhel"},
+		{"Red Hat Enterprise Linux with HA", "x86_64", OS_LINUX, "rhel"},
+		{"SUSE Linux", "arm64", OS_LINUX, "suse"},
+		{"Ubuntu Pro", "x86_64", OS_LINUX, "ubuntu"},
+		{"Windows", "x86_64", OS_WINDOWS, OS_WINDOWS},
+		{"Windows with SQL Server Enterprise", "x86_64", OS_WINDOWS, OS_WINDOWS},
+		{"Linux/UNIX", "arm64_mac", OS_OTHER, "macos"},
+		{"", "x86_64", OS_OTHER, DISTRO_UNKNOWN},
+	}
+
+	for _, tt := range tests {
+		family, distribution := osFamily(tt.platformDetails, tt.architecture)
+		if family != tt.family || distribution != tt.distribution {
+			t.Errorf("osFamily(%q, %q) = %s, %s, want %s, %s", tt.platformDetails, tt.architecture, family, distribution, tt.family, tt.distribution)
+		}
+	}
+}
+
+func TestImageDistribution(t *testing.T) {
+	tests := []struct {
+		name        string
+		description string
+		want        string
+	}{
+		{"al2023-ami-2023.1.20230705.0-kernel-6.1-arm64", "Amazon Linux 2023 AMI 2023.1.20230705.0 arm64 HVM kernel-6.1", "amazon-linux"},
+		{"amazon-eks-node-1.29-v20240213", "EKS Kubernetes Worker AMI with AmazonLinux2 image, (k8s: 1.29.0, containerd: 1.7.*)", "amazon-linux"},
+		{"amazon-eks-gpu-node-1.28-v20240213", "EKS-optimized Kubernetes node based on Amazon Linux 2 with GPU support", "amazon-linux"},
+		{"amazon-eks-arm64-node-1.27-v20240129", "", "amazon-linux"},
+		{"amazon-eks-node-al2023-x86_64-standard-1.29-v20240213", "EKS-optimized Kubernetes node based on Amazon Linux 2023, (k8s: 1.29.0, containerd: 1.7.*)", "amazon-linux"},
+		{"", "EKS Kubernetes Worker AMI with AmazonLinux2 image", "amazon-linux"},
+		{"bottlerocket-aws-k8s-1.29-x86_64-v1.19.2-29cc92cc", "bottlerocket-aws-k8s-1.29-x86_64-v1.19.2-29cc92cc", "bottlerocket"},
+		{"bottlerocket-aws-k8s-1.27-x86_64-v1.14.1", "Amazon Linux based", "bottlerocket"},
+		{"ubuntu-eks/k8s_1.29/images/hvm-ssd/ubuntu-jammy-22.04-amd64-server-20240207", "Canonical, Ubuntu EKS Node OS (k8s_1.29), 22.04 LTS, amd64 jammy image", "ubuntu"},
+		{"ubuntu/images/hvm-ssd/ubuntu-jammy-22.04-amd64-server-20230516", "", "ubuntu"},
+		{"", "Debian 12 (20230711-1438)", "debian"},
+		{"RHEL-9.2.0_HVM-20230503-x86_64-41-Hourly2-GP2", "", "rhel"},
+		{"golden-image-2023-07", "", "unknown"},
+	}
+	for _, tt := range tests {
+		image := ec2Types.Image{Name: aws.String(tt.name), Description: aws.String(tt.description)}
+		if got := imageDistribution(image); got != tt.want {
+			t.Errorf("imageDistribution(%q, %q) = %s, want %s", tt.name, tt.description, got, tt.want)
+		}
+	}
+}
+
+func TestSetImageDistributions(t *testing.T) {
+	service := &fakeEC2{images: map[string]ec2Types.Image{
+		"ami-ubuntu": {Name: aws.String("ubuntu/images/hvm-ssd/ubuntu-jammy-22.04-arm64-server")},
+		"ami-rhel":   {Name: aws.String("RHEL-9.2.0_HVM")},
+	}}
+	instances := []VMInfo{
+		{InstanceID: "i-ubuntu", AMI: "ami-ubuntu", OSFamily: OS_LINUX, Distribution: DISTRO_UNKNOWN},
+		{InstanceID: "i-gone", AMI: "ami-deregistered", OSFamily: OS_LINUX, Distribution: DISTRO_UNKNOWN},
+		{InstanceID: "i-suse", AMI: "ami-rhel", OSFamily: OS_LINUX, Distribution: "suse"},
+		{InstanceID: "i-windows", AMI: "ami-ubuntu", OSFamily: OS_WINDOWS, Distribution: OS_WINDOWS},
+	}
+	for i := 0; i < 250; i++ {
+		instances = append(instances, VMInfo{AMI: fmt.Sprintf("ami-custom-%d", i), OSFamily: OS_LINUX, Distribution: DISTRO_UNKNOWN})
+	}
+
+	if err := setImageDetails(ctx, service, instances); err != nil {
+		t.Fatalf("setImageDetails() error = %v", err)
+	}
+	for i, want := range []string{"ubuntu", DISTRO_UNKNOWN, "suse", OS_WINDOWS} {
+		if instances[i].Distribution != want {
+			t.Errorf("%s distribution = %s, want %s", instances[i].InstanceID, instances[i].Distribution, want)
+		}
+	}
+	for i, want := range []string{"ubuntu/images/hvm-ssd/ubuntu-jammy-22.04-arm64-server", "", "RHEL-9.2.0_HVM", "ubuntu/images/hvm-ssd/ubuntu-jammy-22.04-arm64-server"} {
+		if instances[i].ImageName != want {
+			t.Errorf("%s image name = %q, want %q", instances[i].InstanceID, instances[i].ImageName, want)
+		}
+	}
+
+	service.errs = map[string]error{"DescribeImages": errAccessDenied}
+	if err := setImageDetails(ctx, service, instances); !errors.Is(err, errAccessDenied) {
+		t.Errorf("error = %v, want %v", err, errAccessDenied)
+	}
+}
+
+func TestGetEC2InstancesPlatform(t *testing.T) {
+	arm := instance("i-arm", "Linux/UNIX")
+	arm.Architecture = ec2Types.ArchitectureValuesArm64
+	rhel := instance("i-rhel", "Red Hat Enterprise Linux")
+	rhel.Architecture = ec2Types.ArchitectureValuesX8664
+	service := &fakeEC2{
+		reservations: [][]ec2Types.Reservation{{reservation("123", arm, rhel)}},
+		images:       map[string]ec2Types.Image{"ami-i-arm": {Name: aws.String("amzn2-ami-kernel-5.10-hvm-arm64-gp2")}},
+	}
+
+	vms, err := getEC2InstancesByRegion(ctx, service, "us-east-1", nil)
+	if err != nil {
+		t.Fatalf("getEC2InstancesByRegion() error = %v", err)
+	}
+	got := make(map[string]string)
+	for _, vm := range vms {
+		got[vm.InstanceID] = vm.OSFamily + "/" + vm.Distribution + "/" + vm.Architecture
+	}
+	if got["i-arm"] != "linux/amazon-linux/arm64" || got["i-rhel"] != "linux/rhel/x86_64" {
+		t.Errorf("platforms = %v", got)
+	}
+
+	service.errs = map[string]error{"DescribeImages": errAccessDenied}
+	if vms, err := getEC2InstancesByRegion(ctx, service, "us-east-1", nil); len(vms) != 2 || !errors.Is(err, errAccessDenied) {
+		t.Errorf("on DescribeImages error got %d VMs, %v, want 2 and %v", len(vms), err, errAccessDenied)
+	}
+}
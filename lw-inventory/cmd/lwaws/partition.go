@@ -0,0 +1,141 @@
+package lwaws
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/lacework-dev/scripts/lw-inventory/helpers"
+	"github.com/spf13/cobra"
+)
+
+const (
+	PARTITION_AWS    = "aws"
+	PARTITION_US_GOV = "aws-us-gov"
+	PARTITION_CN     = "aws-cn"
+)
+
+// Partition is an isolated group of AWS regions, credentials of one partition
+// can't call the endpoints of another
+type Partition struct {
+	Name string
+	//region used for STS and DescribeRegions before the regions of the
+	//account are known
+	BootstrapRegion string
+	//region names of the partition start with one of these prefixes
+	RegionPrefixes []string
+}
+
+var partitions = []Partition{
+	{Name: PARTITION_US_GOV, BootstrapRegion: "us-gov-west-1", RegionPrefixes: []string{"us-gov-"}},
+	{Name: PARTITION_CN, BootstrapRegion: "cn-north-1", RegionPrefixes: []string{"cn-"}},
+	{Name: PARTITION_AWS, BootstrapRegion: "us-east-1"},
+}
+
+func getPartition(name string) (Partition, bool) {
+	for _, p := range partitions {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return Partition{}, false
+}
+
+// regionPartition returns the partition a region belongs to, regions without
+// another partition's prefix are in the aws partition
+func regionPartition(region string) string {
+	for _, p := range partitions {
+		for _, prefix := range p.RegionPrefixes {
+			if strings.HasPrefix(region, prefix) {
+				return p.Name
+			}
+		}
+	}
+	return PARTITION_AWS
+}
+
+// arnPartition returns the partition field of an ARN such as the caller
+// identity, arn:aws-us-gov:iam::123456789012:user/name
+func arnPartition(arn string) (string, error) {
+	fields := strings.SplitN(arn, ":", 3)
+	if len(fields) < 3 || fields[0] != "arn" || fields[1] == "" {
+		return "", fmt.Errorf("not an ARN: %q", arn)
+	}
+	return fields[1], nil
+}
+
+// bootstrapRegion is the region to start a scan in for the partition. The
+// region of the profile is used when it is in the partition, with no
+// partition given any region of the profile is used
+func bootstrapRegion(partition string, profileRegion string) string {
+	if partition == "" {
+		if profileRegion != "" {
+			return profileRegion
+		}
+		partition = PARTITION_AWS
+	}
+	if profileRegion != "" && regionPartition(profileRegion) == partition {
+		return profileRegion
+	}
+	p, ok := getPartition(partition)
+	if !ok {
+		//a partition the tool doesn't know, only the profile can tell
+		return profileRegion
+	}
+	return p.BootstrapRegion
+}
+
+// findCallerIdentity gets the caller identity of cfg. With no --partition a
+// profile without a region starts in the aws partition, where GovCloud and
+// China credentials fail, so the bootstrap region of every other partition is
+// tried before giving up. cfg is returned in the region the identity was found
+func findCallerIdentity(ctx context.Context, cfg aws.Config, partition string) (aws.Config, *sts.GetCallerIdentityOutput, error) {
+	identity, err := getCallerIdentity(ctx, cfg)
+	if err == nil || partition != "" {
+		return cfg, identity, err
+	}
+	for _, p := range partitions {
+		if p.BootstrapRegion == cfg.Region {
+			continue
+		}
+		retry := cfg.Copy()
+		retry.Region = p.BootstrapRegion
+		if identity, retryErr := getCallerIdentity(ctx, retry); retryErr == nil {
+			return retry, identity, nil
+		}
+	}
+	return cfg, nil, fmt.Errorf("%w, not found in any partition, set --partition %s or %s for GovCloud and China profiles", err, PARTITION_US_GOV, PARTITION_CN)
+}
+
+// partitionRegions keeps the regions of the partition, so --region names of
+// another partition are skipped instead of failing every scan. Regions of a
+// partition the tool doesn't know are all kept
+func partitionRegions(regions []string, partition string) ([]string, []string) {
+	if _, ok := getPartition(partition); !ok {
+		return regions, nil
+	}
+	var kept, skipped []string
+	for _, r := range regions {
+		if regionPartition(r) == partition {
+			kept = append(kept, r)
+		} else {
+			skipped = append(skipped, r)
+		}
+	}
+	return kept, skipped
+}
+
+// ParsePartition returns the --partition to scan, empty to detect it from the
+// caller identity of each profile
+func ParsePartition(cmd *cobra.Command) string {
+	partition := strings.ToLower(strings.TrimSpace(helpers.GetFlagEnvironmentString(cmd, "partition", "partition", "", false)))
+	if partition == "" {
+		return ""
+	}
+	if _, ok := getPartition(partition); !ok {
+		helpers.Bail(fmt.Sprintf("invalid --partition %q, must be %s, %s or %s", partition, PARTITION_AWS, PARTITION_US_GOV, PARTITION_CN), nil)
+	}
+	return partition
+}
@@ -0,0 +1,135 @@
+package lwaws
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+func TestArnPartition(t *testing.T) {
+	tests := map[string]string{
+		"arn:aws:iam::123456789012:user/ops":                   PARTITION_AWS,
+		"arn:aws-us-gov:sts::123456789012:assumed-role/ops/me": PARTITION_US_GOV,
+		"arn:aws-cn:iam::123456789012:root":                    PARTITION_CN,
+	}
+	for arn, want := range tests {
+		if got, err := arnPartition(arn); err != nil || got != want {
+			t.Errorf("arnPartition(%q) = %q, %v, want %q", arn, got, err, want)
+		}
+	}
+	if _, err := arnPartition("123456789012"); err == nil {
+		t.Error("arnPartition accepted an account ID")
+	}
+}
+
+func TestBootstrapRegion(t *testing.T) {
+	tests := []struct {
+		partition     string
+		profileRegion string
+		want          string
+	}{
+		{"", "", "us-east-1"},
+		{"", "eu-west-1", "eu-west-1"},
+		{"", "us-gov-east-1", "us-gov-east-1"},
+		{PARTITION_AWS, "us-gov-east-1", "us-east-1"},
+		{PARTITION_US_GOV, "", "us-gov-west-1"},
+		{PARTITION_US_GOV, "us-east-1", "us-gov-west-1"},
+		{PARTITION_US_GOV, "us-gov-east-1", "us-gov-east-1"},
+		{PARTITION_CN, "eu-west-1", "cn-north-1"},
+		{PARTITION_CN, "cn-northwest-1", "cn-northwest-1"},
+		{"aws-iso", "us-iso-east-1", "us-iso-east-1"},
+	}
+	for _, tt := range tests {
+		if got := bootstrapRegion(tt.partition, tt.profileRegion); got != tt.want {
+			t.Errorf("bootstrapRegion(%q, %q) = %q, want %q", tt.partition, tt.profileRegion, got, tt.want)
+		}
+	}
+}
+
+func TestPartitionRegions(t *testing.T) {
+	regions := []string{"us-east-1", "us-gov-west-1", "cn-north-1", "eu-west-1"}
+
+	kept, skipped := partitionRegions(regions, PARTITION_US_GOV)
+	if !reflect.DeepEqual(kept, []string{"us-gov-west-1"}) || !reflect.DeepEqual(skipped, []string{"us-east-1", "cn-north-1", "eu-west-1"}) {
+		t.Errorf("aws-us-gov kept %v, skipped %v", kept, skipped)
+	}
+	kept, skipped = partitionRegions(regions, PARTITION_AWS)
+	if !reflect.DeepEqual(kept, []string{"us-east-1", "eu-west-1"}) || !reflect.DeepEqual(skipped, []string{"us-gov-west-1", "cn-north-1"}) {
+		t.Errorf("aws kept %v, skipped %v", kept, skipped)
+	}
+	if kept, skipped = partitionRegions(regions, "aws-iso"); !reflect.DeepEqual(kept, regions) || skipped != nil {
+		t.Errorf("unknown partition kept %v, skipped %v", kept, skipped)
+	}
+}
+
+func TestGetSessionPartition(t *testing.T) {
+	useSharedConfig(t)
+
+	cfg, err := getSession(ctx, "default", PARTITION_US_GOV, CredentialOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Region != "us-gov-west-1" {
+		t.Errorf("region = %q, want the aws-us-gov bootstrap region over the profile's us-east-1", cfg.Region)
+	}
+}
+
+// useCallerIdentity fakes STS, only region answers with the identity of arn,
+// the regions STS was called in are recorded
+func useCallerIdentity(t *testing.T, region string, arn string) *[]string {
+	var calls []string
+	original := getCallerIdentity
+	getCallerIdentity = func(ctx context.Context, cfg aws.Config) (*sts.GetCallerIdentityOutput, error) {
+		calls = append(calls, cfg.Region)
+		if cfg.Region != region {
+			return nil, errAccessDenied
+		}
+		return &sts.GetCallerIdentityOutput{Account: aws.String("123456789012"), Arn: aws.String(arn)}, nil
+	}
+	t.Cleanup(func() { getCallerIdentity = original })
+	return &calls
+}
+
+func TestFindCallerIdentity(t *testing.T) {
+	calls := useCallerIdentity(t, "us-gov-west-1", "arn:aws-us-gov:iam::123456789012:user/ops")
+
+	cfg, identity, err := findCallerIdentity(ctx, aws.Config{Region: "us-east-1"}, "")
+	if err != nil {
+		t.Fatalf("findCallerIdentity() error = %v", err)
+	}
+	if cfg.Region != "us-gov-west-1" || aws.ToString(identity.Arn) != "arn:aws-us-gov:iam::123456789012:user/ops" {
+		t.Errorf("got %s %s, want the GovCloud identity", cfg.Region, aws.ToString(identity.Arn))
+	}
+	if want := []string{"us-east-1", "us-gov-west-1"}; !reflect.DeepEqual(*calls, want) {
+		t.Errorf("STS called in %v, want %v", *calls, want)
+	}
+}
+
+func TestFindCallerIdentityPartition(t *testing.T) {
+	calls := useCallerIdentity(t, "cn-north-1", "arn:aws-cn:iam::123456789012:root")
+
+	//a given partition isn't second guessed
+	if _, _, err := findCallerIdentity(ctx, aws.Config{Region: "us-gov-west-1"}, PARTITION_US_GOV); !errors.Is(err, errAccessDenied) {
+		t.Errorf("findCallerIdentity() error = %v, want %v", err, errAccessDenied)
+	}
+	if len(*calls) != 1 {
+		t.Errorf("STS called in %v, want one region", *calls)
+	}
+}
+
+func TestFindCallerIdentityNotFound(t *testing.T) {
+	calls := useCallerIdentity(t, "", "")
+
+	_, _, err := findCallerIdentity(ctx, aws.Config{Region: "us-east-1"}, "")
+	if !errors.Is(err, errAccessDenied) || !strings.Contains(err.Error(), "--partition") {
+		t.Errorf("findCallerIdentity() error = %v, want %v naming --partition", err, errAccessDenied)
+	}
+	if len(*calls) != len(partitions) {
+		t.Errorf("STS called in %v, want every partition once", *calls)
+	}
+}
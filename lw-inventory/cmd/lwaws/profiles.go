@@ -0,0 +1,123 @@
+package lwaws
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/ssocreds"
+	"github.com/lacework-dev/scripts/lw-inventory/helpers"
+	"github.com/spf13/cobra"
+)
+
+// sharedConfigFiles returns the shared config and credentials files the SDK
+// reads, honouring AWS_CONFIG_FILE and AWS_SHARED_CREDENTIALS_FILE
+func sharedConfigFiles() (string, string) {
+	configFile := os.Getenv("AWS_CONFIG_FILE")
+	if configFile == "" {
+		configFile = config.DefaultSharedConfigFilename()
+	}
+	credentialsFile := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if credentialsFile == "" {
+		credentialsFile = config.DefaultSharedCredentialsFilename()
+	}
+	return configFile, credentialsFile
+}
+
+// listProfiles returns the sorted profile names of the shared config files
+// matching the glob pattern
+func listProfiles(pattern string) ([]string, error) {
+	configFile, credentialsFile := sharedConfigFiles()
+	seen := make(map[string]bool)
+	for _, f := range []struct {
+		path   string
+		config bool
+	}{{configFile, true}, {credentialsFile, false}} {
+		names, err := readProfileNames(f.path, f.config)
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range names {
+			seen[n] = true
+		}
+	}
+
+	var profiles []string
+	for p := range seen {
+		if ok, _ := path.Match(pattern, p); ok {
+			profiles = append(profiles, p)
+		}
+	}
+	sort.Strings(profiles)
+	return profiles, nil
+}
+
+// readProfileNames reads the section names of a shared config file. Config
+// file profiles are [profile name] apart from [default], other sections such
+// as [sso-session name] aren't profiles. A missing file has no profiles
+func readProfileNames(file string, configFile bool) ([]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var names []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "[") || !strings.HasSuffix(line, "]") {
+			continue
+		}
+		section := strings.Fields(strings.TrimSuffix(strings.TrimPrefix(line, "["), "]"))
+		switch {
+		case len(section) == 1 && (!configFile || section[0] == config.DefaultSharedConfigProfile):
+			names = append(names, section[0])
+		case len(section) == 2 && configFile && section[0] == "profile":
+			names = append(names, section[1])
+		}
+	}
+	return names, scanner.Err()
+}
+
+// ssoLoginError turns an expired or missing SSO token into the command that
+// fixes it, other errors are returned as they are
+func ssoLoginError(profile string, err error) error {
+	var tokenErr *ssocreds.InvalidTokenError
+	if errors.As(err, &tokenErr) {
+		return fmt.Errorf("SSO session expired, run aws sso login --profile %s: %w", profile, err)
+	}
+	return err
+}
+
+// parseAllProfiles returns the profiles of --all-profiles, which takes an
+// optional glob pattern of profile names
+func parseAllProfiles(cmd *cobra.Command) []string {
+	pattern := helpers.GetFlagEnvironmentString(cmd, "all-profiles", "all-profiles", "", false)
+	if pattern == "" {
+		return nil
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		helpers.Bail(fmt.Sprintf("invalid --all-profiles pattern %q", pattern), err)
+	}
+	if cmd.Flag("profile").Changed {
+		helpers.Bail("--profile and --all-profiles can't be used together", nil)
+	}
+
+	profiles, err := listProfiles(pattern)
+	if err != nil {
+		helpers.Bail("error reading AWS profiles", err)
+	}
+	if len(profiles) == 0 {
+		helpers.Bail(fmt.Sprintf("no AWS profiles match %q", pattern), nil)
+	}
+	return profiles
+}
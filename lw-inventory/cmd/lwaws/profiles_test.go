@@ -0,0 +1,86 @@
+package lwaws
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeSharedConfig points the SDK at the given config and credentials files
+func writeSharedConfig(t *testing.T, configFile string, credentialsFile string) {
+	useSharedConfig(t)
+	dir := t.TempDir()
+	for env, content := range map[string]string{"AWS_CONFIG_FILE": configFile, "AWS_SHARED_CREDENTIALS_FILE": credentialsFile} {
+		path := filepath.Join(dir, env)
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		t.Setenv(env, path)
+	}
+}
+
+const testConfig = `[default]
+region = us-east-1
+
+[profile sso-dev]
+sso_start_url = https://lw-inventory-test.awsapps.com/start
+sso_region = us-east-1
+sso_account_id = 123456789012
+sso_role_name = ReadOnly
+
+[profile sso-prod]
+region = eu-west-1
+
+[sso-session corp]
+sso_start_url = https://corp.awsapps.com/start
+`
+
+const testCredentials = `[default]
+aws_access_key_id = AKIDTEST
+aws_secret_access_key = secret
+
+[legacy]
+aws_access_key_id = AKIDLEGACY
+aws_secret_access_key = secret
+`
+
+func TestListProfiles(t *testing.T) {
+	writeSharedConfig(t, testConfig, testCredentials)
+
+	tests := map[string][]string{
+		"*":     {"default", "legacy", "sso-dev", "sso-prod"},
+		"sso-*": {"sso-dev", "sso-prod"},
+		"corp":  nil,
+	}
+	for pattern, want := range tests {
+		got, err := listProfiles(pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("listProfiles(%q) = %v, want %v", pattern, got, want)
+		}
+	}
+}
+
+func TestListProfilesMissingFiles(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+
+	if got, err := listProfiles("*"); err != nil || len(got) != 0 {
+		t.Errorf("listProfiles = %v, %v, want no profiles", got, err)
+	}
+}
+
+func TestGetSessionExpiredSSO(t *testing.T) {
+	writeSharedConfig(t, testConfig, testCredentials)
+
+	//there is no cached token for the test start URL
+	_, err := getSession(ctx, "sso-dev", "", CredentialOptions{})
+	if err == nil || !strings.Contains(err.Error(), "run aws sso login --profile sso-dev") {
+		t.Errorf("getSession error = %v, want the aws sso login command", err)
+	}
+}
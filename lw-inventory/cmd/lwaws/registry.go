@@ -0,0 +1,397 @@
+package lwaws
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/lacework-dev/scripts/lw-inventory/helpers"
+	"github.com/lacework-dev/scripts/lw-inventory/report"
+	log "github.com/sirupsen/logrus"
+)
+
+// EC2_VMS is the --only-services/--skip-services name of the EC2 VM scan
+const EC2_VMS = "ec2"
+
+// Counter scans one service in every region of an account. Name is what
+// --only-services and --skip-services take, Service is the label used in the
+// report, totals and failures. Tags is set when the counter applies the tag
+// scan of the region scan, ECS and EKS when it reads its ECS snapshot or EKS
+// clusters.
+//
+// Count counts the resources of a region. Services the report breaks down
+// further than a count set Scan instead, which adds the resources of a region
+// to the account results, and Report, which adds the results to the account
+// report and totals once every region is scanned. Print shows the totals of
+// the service in the summary of an account and of all accounts
+type Counter struct {
+	Name     string
+	Service  string
+	Category string
+	Tags     bool
+	ECS      bool
+	EKS      bool
+	Count    func(ctx context.Context, scan RegionScan) (int, error)
+	Scan     func(ctx context.Context, scan RegionScan, results *ScanResults) error
+	Report   func(account *report.Account, results *ScanResults, totals *Totals, opts Options)
+	Print    func(out io.Writer, totals *Totals, opts Options)
+}
+
+// RegionScan is what every counter gets to scan one region of an account
+type RegionScan struct {
+	Region  string
+	Clients Clients
+	//Tags filters and groups the resources of the counters with Tags, it is
+	//shared by the regions of an account
+	Tags *tagScan
+	//instance tags that mark EKS nodes
+	K8sTags []string
+	//container images pushed after the cutoff are counted
+	ImageCutoff time.Time
+	//ECS is the crawl of the region, set for the counters with ECS
+	ECS *ECSSnapshot
+	//EKSClusters are the cluster names of the region, set for the counters
+	//with EKS
+	EKSClusters []string
+}
+
+// regionScans returns a copy of base for every region, with its clients
+func regionScans(cfg aws.Config, regions []string, base RegionScan) []RegionScan {
+	var scans []RegionScan
+	for _, r := range regions {
+		scan := base
+		scan.Region = r
+		scan.Clients = newClients(regionConfig(cfg, r))
+		scans = append(scans, scan)
+	}
+	return scans
+}
+
+// ServiceCount is the result of a counter in one region
+type ServiceCount struct {
+	Region   string
+	Service  string
+	Category string
+	Count    int
+}
+
+// ScanResults collect what the Scan of every counter lists in the regions of
+// an account. VMs are reconciled with the ECS container instances and EKS
+// clusters before the reports run
+type ScanResults struct {
+	sync.Mutex
+	VMs             []VMInfo
+	ECSVMs          []VMInfo
+	LambdaFunctions []LambdaFunction
+	Fargate         []FargateSizing
+	EKSClusters     []EKSCluster
+	Volumes         []EBSVolumes
+	Repositories    []ECRRepositories
+}
+
+// Totals sum the results of an account, or of every account
+type Totals struct {
+	Resources        int
+	StandardAgents   OSCounts
+	EnterpriseAgents OSCounts
+	StandardVCPUs    OSCounts
+	EnterpriseVCPUs  OSCounts
+	Platforms        []report.PlatformCount
+	States           report.StateCounts
+	TagGroups        []report.TagGroup
+	//counts of the counters with a Count, by service
+	Services        map[string]int
+	LambdaFunctions int
+	LambdaVCPUs     float64
+	Fargate         FargateSizing
+	Volumes         *report.Volumes
+	Repositories    []report.Repository
+	EKS             eksTotals
+}
+
+func newTotals() *Totals {
+	return &Totals{Services: make(map[string]int), Volumes: report.NewVolumes()}
+}
+
+func (t *Totals) add(o *Totals) {
+	t.Resources += o.Resources
+	t.StandardAgents = plusOS(t.StandardAgents, o.StandardAgents)
+	t.EnterpriseAgents = plusOS(t.EnterpriseAgents, o.EnterpriseAgents)
+	t.StandardVCPUs = plusOS(t.StandardVCPUs, o.StandardVCPUs)
+	t.EnterpriseVCPUs = plusOS(t.EnterpriseVCPUs, o.EnterpriseVCPUs)
+	for _, p := range o.Platforms {
+		t.Platforms = report.AddPlatform(t.Platforms, p)
+	}
+	t.States.Running += o.States.Running
+	t.States.Stopped += o.States.Stopped
+	for _, g := range o.TagGroups {
+		t.TagGroups = report.AddTagGroup(t.TagGroups, g)
+	}
+	for service, n := range o.Services {
+		t.Services[service] += n
+	}
+	t.LambdaFunctions += o.LambdaFunctions
+	t.LambdaVCPUs += o.LambdaVCPUs
+	t.Fargate.Tasks += o.Fargate.Tasks
+	t.Fargate.CPUUnits += o.Fargate.CPUUnits
+	t.Fargate.MemoryMB += o.Fargate.MemoryMB
+	t.Volumes.Add(o.Volumes)
+	t.Repositories = append(t.Repositories, o.Repositories...)
+	t.EKS.Clusters += o.EKS.Clusters
+	t.EKS.NodeGroups += o.EKS.NodeGroups
+	t.EKS.ManagedNodes += o.EKS.ManagedNodes
+	t.EKS.SelfManagedNodes += o.EKS.SelfManagedNodes
+}
+
+var counters []Counter
+
+// Register adds a counter to every scan, in registration order
+func Register(c Counter) {
+	counters = append(counters, c)
+}
+
+func init() {
+	Register(Counter{Name: EC2_VMS, Service: EC2, Category: report.CategoryAgent, Tags: true, ECS: true, Scan: func(ctx context.Context, scan RegionScan, results *ScanResults) error {
+		vms, err := getEC2InstancesByRegion(ctx, scan.Clients.EC2, scan.Region, scan.K8sTags)
+		results.Lock()
+		defer results.Unlock()
+		results.VMs = append(results.VMs, vms...)
+		results.ECSVMs = append(results.ECSVMs, getECSVMCountByRegion(scan.ECS)...)
+		return err
+	}, Report: reportVMs, Print: func(out io.Writer, totals *Totals, opts Options) {
+		helpers.PrintStates(out, totals.States)
+		printOSCounts(out, totals.StandardAgents, totals.EnterpriseAgents)
+		printVCPUs(out, totals.StandardVCPUs, totals.EnterpriseVCPUs)
+		printPlatforms(out, totals.Platforms)
+	}})
+
+	Register(Counter{Name: "rds", Service: RDS, Category: report.CategoryAgentless, Tags: true, Count: func(ctx context.Context, scan RegionScan) (int, error) {
+		c, err := getRDSInstanceCountByRegion(ctx, scan.Clients.RDS, scan.Region, scan.Tags)
+		return c.Count, err
+	}})
+	Register(Counter{Name: "redshift", Service: REDSHIFT, Category: report.CategoryAgentless, Tags: true, Count: func(ctx context.Context, scan RegionScan) (int, error) {
+		c, err := getRedshiftInstanceCountByRegion(ctx, scan.Clients.Redshift, scan.Region, scan.Tags)
+		return c.Count, err
+	}})
+	Register(Counter{Name: "elbv1", Service: ELBv1, Category: report.CategoryAgentless, Tags: true, Count: func(ctx context.Context, scan RegionScan) (int, error) {
+		c, err := getELBv1InstanceCountByRegion(ctx, scan.Clients.ELB, scan.Region, scan.Tags)
+		return c.Count, err
+	}})
+	Register(Counter{Name: "elbv2", Service: ELBv2, Category: report.CategoryAgentless, Tags: true, Count: func(ctx context.Context, scan RegionScan) (int, error) {
+		c, err := getELBv2InstanceCountByRegion(ctx, scan.Clients.ELBv2, scan.Region, scan.Tags)
+		return c.Count, err
+	}})
+	Register(Counter{Name: "nat-gateway", Service: NATGATEWAY, Category: report.CategoryAgentless, Tags: true, Count: func(ctx context.Context, scan RegionScan) (int, error) {
+		c, err := getNatGatewayInstanceCountByRegion(ctx, scan.Clients.EC2, scan.Region, scan.Tags)
+		return c.Count, err
+	}})
+
+	Register(Counter{Name: "ecs-task-definitions", Service: ECS_TASKS, Category: report.CategoryContainer, Count: func(ctx context.Context, scan RegionScan) (int, error) {
+		c, err := getECSTaskDefinitionsByRegion(ctx, scan.Clients.ECS, scan.Region)
+		return c.Count, err
+	}})
+	Register(Counter{Name: "fargate-running-tasks", Service: FARGATE_RUNNING_TASKS, Category: report.CategoryContainer, ECS: true, Count: func(ctx context.Context, scan RegionScan) (int, error) {
+		return getECSFargateRunningTasksByRegion(scan.ECS).Count, nil
+	}})
+	Register(Counter{Name: "fargate-running-containers", Service: FARGATE_RUNNING_CONTAINERS, Category: report.CategoryContainer, ECS: true, Count: func(ctx context.Context, scan RegionScan) (int, error) {
+		return getECSFargateRunningContainersByRegion(scan.ECS).Count, nil
+	}})
+	Register(Counter{Name: "fargate-total-containers", Service: FARGATE_TOTAL_CONTAINERS, Category: report.CategoryContainer, ECS: true, Count: func(ctx context.Context, scan RegionScan) (int, error) {
+		return getECSFargateTotalContainersByRegion(scan.ECS).Count, nil
+	}})
+	Register(Counter{Name: "fargate-active-services", Service: FARGATE_ACTIVE_SERVICES, Category: report.CategoryContainer, ECS: true, Count: func(ctx context.Context, scan RegionScan) (int, error) {
+		return getECSFargateActiveServicesByRegion(scan.ECS).Count, nil
+	}})
+	Register(Counter{Name: "eks-fargate-profiles", Service: EKS_FARGATE_ACTIVE_PROFILES, Category: report.CategoryContainer, EKS: true, Count: func(ctx context.Context, scan RegionScan) (int, error) {
+		c, err := getEKSFargateActiveProfilesByRegion(ctx, scan.Clients.EKS, scan.Region, scan.EKSClusters)
+		return c.Count, err
+	}})
+
+	Register(Counter{Name: LAMBDA_FUNCTIONS, Service: LAMBDA, Category: report.CategoryServerless, Scan: func(ctx context.Context, scan RegionScan, results *ScanResults) error {
+		functions, err := getLambdaFunctionsByRegion(ctx, scan.Clients.Lambda, scan.Region)
+		results.Lock()
+		defer results.Unlock()
+		results.LambdaFunctions = append(results.LambdaFunctions, functions...)
+		return err
+	}, Report: func(account *report.Account, results *ScanResults, totals *Totals, opts Options) {
+		reportLambdaFunctions(account, results.LambdaFunctions, totals, opts.LambdaVCPUs)
+	}, Print: func(out io.Writer, totals *Totals, opts Options) {
+		printLambda(out, totals.LambdaFunctions, totals.LambdaVCPUs, opts.LambdaVCPUs)
+	}})
+	Register(Counter{Name: FARGATE_SIZING, Service: FARGATE_VCPUS, Category: report.CategoryContainer, ECS: true, Scan: func(ctx context.Context, scan RegionScan, results *ScanResults) error {
+		results.Lock()
+		defer results.Unlock()
+		results.Fargate = append(results.Fargate, getFargateSizingByRegion(scan.ECS)...)
+		return nil
+	}, Report: func(account *report.Account, results *ScanResults, totals *Totals, opts Options) {
+		reportFargateSizing(account, results.Fargate, totals)
+	}, Print: func(out io.Writer, totals *Totals, opts Options) {
+		printFargate(out, totals.Fargate)
+	}})
+	Register(Counter{Name: EKS_CLUSTERS, Service: EKS_CLUSTER, Category: report.CategoryContainer, EKS: true, Scan: func(ctx context.Context, scan RegionScan, results *ScanResults) error {
+		clusters, err := getEKSClustersByRegion(ctx, scan.Clients.EKS, scan.Clients.AutoScaling, scan.Region, scan.EKSClusters)
+		results.Lock()
+		defer results.Unlock()
+		results.EKSClusters = append(results.EKSClusters, clusters...)
+		return err
+	}, Report: func(account *report.Account, results *ScanResults, totals *Totals, opts Options) {
+		reportEKSClusters(account, results.EKSClusters, results.VMs, totals)
+	}, Print: func(out io.Writer, totals *Totals, opts Options) {
+		printEKS(out, totals.EKS)
+	}})
+	Register(Counter{Name: EBS_SIZING, Service: EBS_VOLUMES, Tags: true, Scan: func(ctx context.Context, scan RegionScan, results *ScanResults) error {
+		volumes, err := getEBSVolumesByRegion(ctx, scan.Clients.EC2, scan.Region, scan.Tags)
+		results.Lock()
+		defer results.Unlock()
+		results.Volumes = append(results.Volumes, volumes)
+		return err
+	}, Report: func(account *report.Account, results *ScanResults, totals *Totals, opts Options) {
+		for _, v := range results.Volumes {
+			account.Region(v.Region).Volumes = v.Volumes
+			totals.Volumes.Add(v.Volumes)
+		}
+	}, Print: func(out io.Writer, totals *Totals, opts Options) {
+		printVolumes(out, totals.Volumes)
+	}})
+	Register(Counter{Name: ECR_IMAGES, Service: ECR_REPOSITORIES, Scan: func(ctx context.Context, scan RegionScan, results *ScanResults) error {
+		repositories, err := getECRRepositoriesByRegion(ctx, scan.Clients.ECR, scan.Region, scan.ImageCutoff)
+		results.Lock()
+		defer results.Unlock()
+		results.Repositories = append(results.Repositories, repositories)
+		return err
+	}, Report: func(account *report.Account, results *ScanResults, totals *Totals, opts Options) {
+		for _, r := range results.Repositories {
+			region := account.Region(r.Region)
+			region.Repositories = append(region.Repositories, r.Repositories...)
+			totals.Repositories = append(totals.Repositories, r.Repositories...)
+		}
+	}, Print: func(out io.Writer, totals *Totals, opts Options) {
+		helpers.PrintRepositories(out, totals.Repositories, opts.ImageLookbackDays)
+	}})
+}
+
+// ServiceNames lists every name --only-services and --skip-services accept
+func ServiceNames() []string {
+	var names []string
+	for _, c := range counters {
+		names = append(names, c.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ServiceFilter picks the services a scan runs, everything when both lists
+// are empty
+type ServiceFilter struct {
+	Only []string
+	Skip []string
+}
+
+func (f ServiceFilter) Enabled(name string) bool {
+	if len(f.Only) > 0 && !helpers.Contains(f.Only, name) {
+		return false
+	}
+	return !helpers.Contains(f.Skip, name)
+}
+
+// Validate returns an error naming the first service that isn't registered
+func (f ServiceFilter) Validate() error {
+	names := ServiceNames()
+	for _, n := range append(append([]string{}, f.Only...), f.Skip...) {
+		if !helpers.Contains(names, n) {
+			return fmt.Errorf("unknown service %q, valid services: %s", n, strings.Join(names, ", "))
+		}
+	}
+	return nil
+}
+
+// Counters returns the registered counters the filter enables
+func (f ServiceFilter) Counters() []Counter {
+	var enabled []Counter
+	for _, c := range counters {
+		if f.Enabled(c.Name) {
+			enabled = append(enabled, c)
+		}
+	}
+	return enabled
+}
+
+// ECS reports whether any enabled counter reads the ECS snapshot, the ECS
+// crawl only runs for those
+func (f ServiceFilter) ECS() bool {
+	for _, c := range f.Counters() {
+		if c.ECS {
+			return true
+		}
+	}
+	return false
+}
+
+// EKS reports whether any enabled counter reads the EKS clusters, they are
+// only listed for those
+func (f ServiceFilter) EKS() bool {
+	for _, c := range f.Counters() {
+		if c.EKS {
+			return true
+		}
+	}
+	return false
+}
+
+// scanRegions runs every counter in every region of an account. Counts are
+// returned, the other counters add what they list to the results
+func scanRegions(ctx context.Context, scheduler *helpers.Scheduler, scans []RegionScan, counters []Counter) ([]ServiceCount, *ScanResults, []ScanFailure) {
+	log.Debugf("start scanRegions\n")
+	start := time.Now()
+
+	results := &ScanResults{}
+	var tasks []scanTask[[]ServiceCount]
+	for _, scan := range scans {
+		scan := scan
+		for _, c := range counters {
+			c := c
+			tasks = append(tasks, scanTask[[]ServiceCount]{Region: scan.Region, Service: c.Service, Scan: func(ctx context.Context) ([]ServiceCount, error) {
+				if c.Scan != nil {
+					return nil, c.Scan(ctx, scan, results)
+				}
+				count, err := c.Count(ctx, scan)
+				return []ServiceCount{{Region: scan.Region, Service: c.Service, Category: c.Category, Count: count}}, err
+			}})
+		}
+	}
+
+	var serviceCounts []ServiceCount
+	counts, failures := runScanTasks(ctx, scheduler, tasks)
+	for _, c := range counts {
+		serviceCounts = append(serviceCounts, c...)
+	}
+
+	elapsed := time.Since(start)
+	log.Debugf("end scanRegions - %s\n", elapsed)
+	return serviceCounts, results, failures
+}
+
+// printTotals shows the totals of an account or of all accounts
+func printTotals(out io.Writer, totals *Totals, counters []Counter, opts Options) {
+	fmt.Fprintf(out, "Total Resources  %d\n", totals.Resources)
+	fmt.Fprintf(out, "Standard Agent VMs: %d\n", totals.StandardAgents.Total())
+	fmt.Fprintf(out, "Enterprise Agent VMs: %d\n", totals.EnterpriseAgents.Total())
+	for _, c := range counters {
+		if c.Count != nil && c.Category == report.CategoryContainer {
+			fmt.Fprintf(out, "%s: %d\n", c.Service, totals.Services[c.Service])
+		}
+	}
+	for _, c := range counters {
+		if c.Print != nil {
+			c.Print(out, totals, opts)
+		}
+	}
+	if opts.GroupBy != "" {
+		helpers.PrintTagGroups(out, opts.GroupBy, totals.TagGroups)
+	}
+}
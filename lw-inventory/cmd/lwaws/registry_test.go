@@ -0,0 +1,142 @@
+package lwaws
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ec2Types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	elbv2Types "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+	rdsTypes "github.com/aws/aws-sdk-go-v2/service/rds/types"
+	"github.com/lacework-dev/scripts/lw-inventory/helpers"
+	"github.com/lacework-dev/scripts/lw-inventory/report"
+)
+
+var agentlessFilter = ServiceFilter{Only: []string{"rds", "redshift", "elbv1", "elbv2", "nat-gateway"}}
+
+func TestScanRegions(t *testing.T) {
+	useClients(t, Clients{
+		EC2:      &fakeEC2{natGateways: [][]ec2Types.NatGateway{make([]ec2Types.NatGateway, 1)}},
+		RDS:      &fakeRDS{instances: [][]rdsTypes.DBInstance{make([]rdsTypes.DBInstance, 2)}},
+		Redshift: &fakeRedshift{err: errAccessDenied},
+		ELB:      &fakeELB{},
+		ELBv2:    &fakeELBv2{loadBalancers: [][]elbv2Types.LoadBalancer{make([]elbv2Types.LoadBalancer, 3)}},
+	})
+
+	counts, _, failures := scanRegions(ctx, helpers.NewScheduler(2), regionScans(aws.Config{}, []string{"us-east-1", "eu-west-1"}, RegionScan{}), agentlessFilter.Counters())
+	if len(counts) != 10 {
+		t.Fatalf("got %d counts, want 5 services in 2 regions", len(counts))
+	}
+
+	if len(failures) != 2 {
+		t.Fatalf("got %d failures, want redshift in 2 regions", len(failures))
+	}
+	for _, f := range failures {
+		if f.Service != REDSHIFT || !errors.Is(f.Err, errAccessDenied) {
+			t.Errorf("failure = %+v, want %s %v", f, REDSHIFT, errAccessDenied)
+		}
+	}
+
+	got := make(map[string]int)
+	for _, c := range counts {
+		if c.Category != report.CategoryAgentless {
+			t.Errorf("%s category = %s, want %s", c.Service, c.Category, report.CategoryAgentless)
+		}
+		got[c.Region+" "+c.Service] = c.Count
+	}
+	for _, region := range []string{"us-east-1", "eu-west-1"} {
+		for service, want := range map[string]int{RDS: 2, REDSHIFT: 0, ELBv1: 0, ELBv2: 3, NATGATEWAY: 1} {
+			if got[region+" "+service] != want {
+				t.Errorf("%s %s = %d, want %d", region, service, got[region+" "+service], want)
+			}
+		}
+	}
+}
+
+func TestScanRegionsCancelled(t *testing.T) {
+	useClients(t, Clients{EC2: &fakeEC2{}, RDS: &fakeRDS{}, Redshift: &fakeRedshift{}, ELB: &fakeELB{}, ELBv2: &fakeELBv2{}})
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+
+	counts, _, failures := scanRegions(cancelled, helpers.NewScheduler(2), regionScans(aws.Config{}, []string{"us-east-1"}, RegionScan{}), agentlessFilter.Counters())
+	if len(counts) != 0 || len(failures) != 5 {
+		t.Fatalf("got %d counts and %d failures, want every service to fail", len(counts), len(failures))
+	}
+	for _, f := range failures {
+		if f.Region != "us-east-1" || !errors.Is(f.Err, context.Canceled) {
+			t.Errorf("failure = %+v, want us-east-1 %v", f, context.Canceled)
+		}
+	}
+}
+
+func TestServiceFilter(t *testing.T) {
+	names := func(counters []Counter) []string {
+		var n []string
+		for _, c := range counters {
+			n = append(n, c.Name)
+		}
+		return n
+	}
+
+	if got := names(ServiceFilter{}.Counters()); len(got) != len(counters) {
+		t.Errorf("empty filter enabled %v, want every counter", got)
+	}
+
+	only := ServiceFilter{Only: []string{"elbv2", "rds"}}
+	if got, want := names(only.Counters()), []string{"rds", "elbv2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("only filter enabled %v, want %v in registration order", got, want)
+	}
+	if only.Enabled(EC2_VMS) {
+		t.Errorf("only filter enabled %s", EC2_VMS)
+	}
+
+	skip := ServiceFilter{Skip: []string{EC2_VMS, "rds"}}
+	if skip.Enabled(EC2_VMS) || skip.Enabled("rds") || !skip.Enabled("redshift") {
+		t.Errorf("skip filter enabled the wrong services")
+	}
+
+	if err := (ServiceFilter{Skip: []string{"lightsail"}}).Validate(); err == nil {
+		t.Errorf("unknown service validated")
+	}
+	if err := (ServiceFilter{Only: []string{EC2_VMS, "fargate-running-tasks"}}).Validate(); err != nil {
+		t.Errorf("Validate() = %v, want nil", err)
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	for _, c := range counters {
+		if (c.Count == nil) == (c.Scan == nil) {
+			t.Errorf("%s sets %s, want either Count or Scan", c.Name, map[bool]string{true: "both", false: "neither"}[c.Count != nil])
+		}
+		if c.Scan != nil && c.Report == nil {
+			t.Errorf("%s scans without a Report", c.Name)
+		}
+	}
+
+	names := ServiceNames()
+	for _, name := range []string{EC2_VMS, LAMBDA_FUNCTIONS, FARGATE_SIZING, EKS_CLUSTERS, EBS_SIZING, ECR_IMAGES, "rds"} {
+		if !helpers.Contains(names, name) {
+			t.Errorf("ServiceNames() = %v, missing %s", names, name)
+		}
+	}
+}
+
+func TestTotalsAdd(t *testing.T) {
+	account := newTotals()
+	account.Resources = 3
+	account.StandardAgents.Linux = 2
+	account.Services[RDS] = 1
+	account.Fargate = FargateSizing{Tasks: 1, CPUUnits: 512}
+	account.Volumes.Count = 4
+	account.EKS.Clusters = 1
+
+	all := newTotals()
+	all.add(account)
+	all.add(account)
+	if all.Resources != 6 || all.StandardAgents.Linux != 4 || all.Services[RDS] != 2 || all.Fargate.CPUUnits != 1024 || all.Volumes.Count != 8 || all.EKS.Clusters != 2 {
+		t.Errorf("totals = %+v, want both accounts added up", all)
+	}
+}
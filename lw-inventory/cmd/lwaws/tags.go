@@ -0,0 +1,151 @@
+package lwaws
+
+import (
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ec2Types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	elbTypes "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancing/types"
+	elbv2Types "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+	rdsTypes "github.com/aws/aws-sdk-go-v2/service/rds/types"
+	redshiftTypes "github.com/aws/aws-sdk-go-v2/service/redshift/types"
+	"github.com/lacework-dev/scripts/lw-inventory/helpers"
+	"github.com/lacework-dev/scripts/lw-inventory/report"
+)
+
+// tagScan carries the tag filter and group-by key of an account scan to the
+// counters of resources with tags, and collects their counts per group. A nil
+// tag scan keeps every resource and groups none
+type tagScan struct {
+	filter  helpers.TagFilter
+	groupBy string
+	mu      sync.Mutex
+	//agentless resources by region and group value
+	groups map[string]map[string]int
+}
+
+// newTagScan returns a tag scan for one account
+func newTagScan(filter helpers.TagFilter, groupBy string) *tagScan {
+	return &tagScan{filter: filter, groupBy: groupBy, groups: make(map[string]map[string]int)}
+}
+
+// keep reports whether a resource of the region passes the tag filter, and
+// counts it in its group when it does
+func (s *tagScan) keep(region string, tags map[string]string) bool {
+	if s == nil {
+		return true
+	}
+	if !s.filter.Match(tags) {
+		return false
+	}
+	if s.groupBy != "" {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.groups[region] == nil {
+			s.groups[region] = make(map[string]int)
+		}
+		s.groups[region][helpers.GroupValue(tags, s.groupBy)]++
+	}
+	return true
+}
+
+// lookup reports whether resources listed without tags need them read, for
+// the tag filter or the group-by key
+func (s *tagScan) lookup() bool {
+	return s != nil && (s.filter.Enabled() || s.groupBy != "")
+}
+
+// match reports whether tags pass the tag filter without counting a group,
+// for resources that aren't agentless resources themselves
+func (s *tagScan) match(tags map[string]string) bool {
+	return s == nil || s.filter.Match(tags)
+}
+
+// tagGroups returns the agentless resources counted per region and group
+func (s *tagScan) tagGroups() map[string][]report.TagGroup {
+	groups := make(map[string][]report.TagGroup)
+	if s == nil {
+		return groups
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for region, values := range s.groups {
+		for value, n := range values {
+			groups[region] = append(groups[region], report.TagGroup{Value: value, AgentlessResources: n})
+		}
+	}
+	return groups
+}
+
+// vmTagGroup is the group of one VM, with its vCPUs
+func vmTagGroup(vm VMInfo, groupBy string) report.TagGroup {
+	g := report.TagGroup{Value: helpers.GroupValue(vm.Tags, groupBy)}
+	if vm.AgentType == STANDARD_AGENT {
+		g.StandardVMs, g.StandardVCPUs = 1, vm.VCPU
+	} else {
+		g.EnterpriseVMs, g.EnterpriseVCPUs = 1, vm.VCPU
+	}
+	return g
+}
+
+// untaggedServices lists the enabled counters whose resources are listed
+// without tags, they are neither filtered nor grouped by tag
+func untaggedServices(services ServiceFilter) []string {
+	var names []string
+	for _, c := range services.Counters() {
+		if !c.Tags {
+			names = append(names, c.Name)
+		}
+	}
+	return names
+}
+
+func filterVMTags(vms []VMInfo, filter helpers.TagFilter) []VMInfo {
+	var kept []VMInfo
+	for _, vm := range vms {
+		if filter.Match(vm.Tags) {
+			kept = append(kept, vm)
+		}
+	}
+	return kept
+}
+
+func ec2Tags(tags []ec2Types.Tag) map[string]string {
+	m := make(map[string]string)
+	for _, t := range tags {
+		m[aws.ToString(t.Key)] = aws.ToString(t.Value)
+	}
+	return m
+}
+
+func rdsTags(tags []rdsTypes.Tag) map[string]string {
+	m := make(map[string]string)
+	for _, t := range tags {
+		m[aws.ToString(t.Key)] = aws.ToString(t.Value)
+	}
+	return m
+}
+
+func elbTags(tags []elbTypes.Tag) map[string]string {
+	m := make(map[string]string)
+	for _, t := range tags {
+		m[aws.ToString(t.Key)] = aws.ToString(t.Value)
+	}
+	return m
+}
+
+func elbv2Tags(tags []elbv2Types.Tag) map[string]string {
+	m := make(map[string]string)
+	for _, t := range tags {
+		m[aws.ToString(t.Key)] = aws.ToString(t.Value)
+	}
+	return m
+}
+
+func redshiftTags(tags []redshiftTypes.Tag) map[string]string {
+	m := make(map[string]string)
+	for _, t := range tags {
+		m[aws.ToString(t.Key)] = aws.ToString(t.Value)
+	}
+	return m
+}
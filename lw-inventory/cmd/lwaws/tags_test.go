@@ -0,0 +1,147 @@
+package lwaws
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ec2Types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	elbTypes "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancing/types"
+	elbv2Types "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+	rdsTypes "github.com/aws/aws-sdk-go-v2/service/rds/types"
+	redshiftTypes "github.com/aws/aws-sdk-go-v2/service/redshift/types"
+	"github.com/lacework-dev/scripts/lw-inventory/helpers"
+	"github.com/lacework-dev/scripts/lw-inventory/report"
+)
+
+func TestTagFilteredCounters(t *testing.T) {
+	prod := helpers.TagFilter{Include: []helpers.Tag{{Key: "env", Value: "prod"}}}
+	filter := newTagScan(prod, "")
+
+	db := func(env string) rdsTypes.DBInstance {
+		return rdsTypes.DBInstance{TagList: []rdsTypes.Tag{{Key: aws.String("env"), Value: aws.String(env)}}}
+	}
+	rdsCount, _ := getRDSInstanceCountByRegion(ctx, &fakeRDS{instances: [][]rdsTypes.DBInstance{{db("prod"), db("dev")}, {db("prod"), {}}}}, "us-east-1", filter)
+	if rdsCount.Count != 2 {
+		t.Errorf("rds count = %d, want 2", rdsCount.Count)
+	}
+
+	cluster := redshiftTypes.Cluster{Tags: []redshiftTypes.Tag{{Key: aws.String("env"), Value: aws.String("prod")}}}
+	redshiftCount, _ := getRedshiftInstanceCountByRegion(ctx, &fakeRedshift{clusters: [][]redshiftTypes.Cluster{{cluster, {}}}}, "us-east-1", filter)
+	if redshiftCount.Count != 1 {
+		t.Errorf("redshift count = %d, want 1", redshiftCount.Count)
+	}
+
+	nat := ec2Types.NatGateway{Tags: []ec2Types.Tag{{Key: aws.String("env"), Value: aws.String("prod")}}}
+	natCount, _ := getNatGatewayInstanceCountByRegion(ctx, &fakeEC2{natGateways: [][]ec2Types.NatGateway{{nat, {}, {}}}}, "us-east-1", filter)
+	if natCount.Count != 1 {
+		t.Errorf("nat gateway count = %d, want 1", natCount.Count)
+	}
+
+	//without a filter everything is counted
+	if c, _ := getNatGatewayInstanceCountByRegion(ctx, &fakeEC2{natGateways: [][]ec2Types.NatGateway{{nat, {}, {}}}}, "us-east-1", nil); c.Count != 3 {
+		t.Errorf("unfiltered nat gateway count = %d, want 3", c.Count)
+	}
+}
+
+func TestFilterVMTags(t *testing.T) {
+	vms := []VMInfo{
+		{InstanceID: "i-prod", Tags: map[string]string{"env": "prod"}},
+		{InstanceID: "i-prod-scratch", Tags: map[string]string{"env": "prod", "scratch": "yes"}},
+		{InstanceID: "i-untagged"},
+	}
+	filter := helpers.TagFilter{Include: []helpers.Tag{{Key: "env", Value: "prod"}}, Exclude: []helpers.Tag{{Key: "scratch"}}}
+	if got := filterVMTags(vms, filter); len(got) != 1 || got[0].InstanceID != "i-prod" {
+		t.Errorf("filterVMTags() = %+v, want i-prod", got)
+	}
+	if got := filterVMTags(vms, helpers.TagFilter{}); len(got) != 3 {
+		t.Errorf("filterVMTags() without a filter kept %d VMs, want 3", len(got))
+	}
+}
+
+func TestUntaggedServices(t *testing.T) {
+	got := untaggedServices(ServiceFilter{Only: []string{EC2_VMS, "rds", "elbv2", LAMBDA_FUNCTIONS, "ecr"}})
+	want := []string{LAMBDA_FUNCTIONS, "ecr"}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("untaggedServices() = %v, want %v", got, want)
+	}
+}
+
+func TestELBTags(t *testing.T) {
+	prod := newTagScan(helpers.TagFilter{Include: []helpers.Tag{{Key: "env", Value: "prod"}}}, "")
+
+	//more load balancers than one DescribeTags call takes
+	v1 := &fakeELB{loadBalancers: [][]elbTypes.LoadBalancerDescription{make([]elbTypes.LoadBalancerDescription, 25)}, tags: map[string][]elbTypes.Tag{}}
+	for i := range v1.loadBalancers[0] {
+		name := fmt.Sprintf("lb-%d", i)
+		v1.loadBalancers[0][i].LoadBalancerName = aws.String(name)
+		if i%5 == 0 {
+			v1.tags[name] = []elbTypes.Tag{{Key: aws.String("env"), Value: aws.String("prod")}}
+		}
+	}
+	if c, err := getELBv1InstanceCountByRegion(ctx, v1, "us-east-1", prod); c.Count != 5 || err != nil {
+		t.Errorf("elbv1 count = %d, %v, want 5", c.Count, err)
+	}
+	if c, _ := getELBv1InstanceCountByRegion(ctx, v1, "us-east-1", nil); c.Count != 25 {
+		t.Errorf("elbv1 count without a filter = %d, want 25", c.Count)
+	}
+
+	v2 := &fakeELBv2{
+		loadBalancers: [][]elbv2Types.LoadBalancer{{{LoadBalancerArn: aws.String("arn-prod")}, {LoadBalancerArn: aws.String("arn-dev")}}},
+		tags:          map[string][]elbv2Types.Tag{"arn-prod": {{Key: aws.String("env"), Value: aws.String("prod")}}},
+	}
+	if c, err := getELBv2InstanceCountByRegion(ctx, v2, "us-east-1", prod); c.Count != 1 || err != nil {
+		t.Errorf("elbv2 count = %d, %v, want 1", c.Count, err)
+	}
+
+	//tags are only read when the filter or group-by needs them
+	v2.tagsErr = errAccessDenied
+	if c, err := getELBv2InstanceCountByRegion(ctx, v2, "us-east-1", newTagScan(helpers.TagFilter{}, "")); c.Count != 2 || err != nil {
+		t.Errorf("elbv2 count without a filter = %d, %v, want 2", c.Count, err)
+	}
+	if _, err := getELBv2InstanceCountByRegion(ctx, v2, "us-east-1", prod); !errors.Is(err, errAccessDenied) {
+		t.Errorf("elbv2 DescribeTags error = %v, want %v", err, errAccessDenied)
+	}
+}
+
+func TestTagScanGroups(t *testing.T) {
+	groups := newTagScan(helpers.TagFilter{Exclude: []helpers.Tag{{Key: "scratch"}}}, "team")
+	db := func(tags ...string) rdsTypes.DBInstance {
+		var list []rdsTypes.Tag
+		for i := 0; i < len(tags); i += 2 {
+			list = append(list, rdsTypes.Tag{Key: aws.String(tags[i]), Value: aws.String(tags[i+1])})
+		}
+		return rdsTypes.DBInstance{TagList: list}
+	}
+	service := &fakeRDS{instances: [][]rdsTypes.DBInstance{{db("team", "payments"), db("team", "payments"), db("team", ""), db(), db("team", "search", "scratch", "yes")}}}
+	if c, _ := getRDSInstanceCountByRegion(ctx, service, "us-east-1", groups); c.Count != 4 {
+		t.Errorf("rds count = %d, want 4", c.Count)
+	}
+	getRDSInstanceCountByRegion(ctx, &fakeRDS{instances: [][]rdsTypes.DBInstance{{db("team", "search")}}}, "eu-west-1", groups)
+
+	got := groups.tagGroups()
+	want := map[string][]report.TagGroup{
+		"us-east-1": {{Value: "payments", AgentlessResources: 2}, {Value: report.Untagged, AgentlessResources: 2}},
+		"eu-west-1": {{Value: "search", AgentlessResources: 1}},
+	}
+	for region, groups := range want {
+		for _, g := range groups {
+			found := false
+			for _, o := range got[region] {
+				found = found || o == g
+			}
+			if !found {
+				t.Errorf("%s groups = %+v, missing %+v", region, got[region], g)
+			}
+		}
+	}
+
+	vm := VMInfo{AgentType: ENTERPRISE_AGENT, VCPU: 4, Tags: map[string]string{"team": "payments"}}
+	if g := vmTagGroup(vm, "team"); g != (report.TagGroup{Value: "payments", EnterpriseVMs: 1, EnterpriseVCPUs: 4}) {
+		t.Errorf("vmTagGroup() = %+v", g)
+	}
+	if g := vmTagGroup(VMInfo{AgentType: STANDARD_AGENT, VCPU: 2}, "team"); g != (report.TagGroup{Value: report.Untagged, StandardVMs: 1, StandardVCPUs: 2}) {
+		t.Errorf("vmTagGroup() of an untagged VM = %+v", g)
+	}
+}
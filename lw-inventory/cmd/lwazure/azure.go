@@ -5,19 +5,104 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/lacework-dev/scripts/lw-inventory/helpers"
+	"github.com/lacework-dev/scripts/lw-inventory/report"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"io"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
+	"time"
 )
 
-func Run(subscriptionsToIgnore []string, debug bool) {
-	if debug {
+const (
+	VM             = "VM"
+	VM_SCALE_SET   = "VM Scale Set Instance"
+	SQL_SERVER     = "SQL Server"
+	LOADBALANCER   = "Load Balancer"
+	VNET_GATEWAY   = "VNet Gateway"
+	AKS_NODE       = "AKS Node"
+	ACR_REPOSITORY = "Container Registry Repository"
+	UNKNOWN_REGION = "unknown"
+	//agent type of the hosts in the report
+	STANDARD_AGENT = "Standard Agent"
+)
+
+// AgentlessServiceCount is a count of one service, Group is the value of the
+// --group-by-tag tag
+type AgentlessServiceCount struct {
+	Region  string
+	Service string
+	Group   string
+	Count   int
+}
+
+// RegistryRepository is a repository of a container registry in a location,
+// with the images pushed since the cutoff
+type RegistryRepository struct {
+	Location   string
+	Repository report.Repository
+}
+
+// ScanFailure is a service that could not be fully counted in a subscription,
+// Location is empty for listings of the whole subscription
+type ScanFailure struct {
+	Location string
+	Service  string
+	Err      error
+}
+
+type SubscriptionInfo struct {
+	ID   string
+	Name string
+}
+
+// Options are the settings of an Azure inventory scan
+type Options struct {
+	SubscriptionsToIgnore []string
+	Debug                 bool
+	//record every agent VM and its image in the report
+	Hosts bool
+	//VM lifecycle states to inventory: running, stopped or all
+	States string
+	Tags   helpers.TagFilter
+	//tag key to break VMs and agentless resources down by
+	GroupBy string
+	//container images pushed within this many days are counted
+	ImageLookbackDays int
+	//progress and summary text, stdout when nil
+	Progress io.Writer
+}
+
+// Run inventories the subscriptions of the az login and prints the summary to
+// the progress writer
+func Run(opts Options) *report.Report {
+	subscriptionsToIgnore, hosts, states := opts.SubscriptionsToIgnore, opts.Hosts, opts.States
+	tags, groupBy, imageLookbackDays := opts.Tags, opts.GroupBy, opts.ImageLookbackDays
+	if opts.Debug {
 		log.SetLevel(log.DebugLevel)
 	}
+	out := opts.Progress
+	if out == nil {
+		out = os.Stdout
+	}
 
-	subscriptions := getSubscriptions()
+	inventory := report.New("azure")
+	if len(subscriptionsToIgnore) > 0 {
+		inventory.Metadata.Options["ignore-subscriptions"] = strings.Join(subscriptionsToIgnore, ",")
+	}
+	inventory.Metadata.Options["states"] = states
+	inventory.Metadata.Options["image-lookback-days"] = strconv.Itoa(imageLookbackDays)
+	tags.SetOptions(inventory.Metadata.Options)
+	if groupBy != "" {
+		inventory.Metadata.Options["group-by-tag"] = groupBy
+	}
+
+	subscriptions, err := getSubscriptions()
+	if err != nil {
+		inventory.AddFailure("", "", "Subscriptions", err)
+	}
 
 	totalAgentlessCount := 0
 	totalStandardAgents := 0
@@ -26,47 +111,116 @@ func Run(subscriptionsToIgnore []string, debug bool) {
 	totalStandardAgentWindowsCount := 0
 	totalEnterpriseAgentLinuxCount := 0
 	totalEnterpriseAgentWindowsCount := 0
+	var totalStates report.StateCounts
+	var totalTagGroups []report.TagGroup
+	var totalRepositories []report.Repository
 
 	subscriptionsInventoried := 0
 	for _, subscription := range subscriptions {
-		if !helpers.Contains(subscriptionsToIgnore, subscription) {
+		if !helpers.Contains(subscriptionsToIgnore, subscription.ID) && !helpers.Contains(subscriptionsToIgnore, subscription.Name) {
 			subscriptionsInventoried++
-			fmt.Println("Scanning Subscription", subscription)
-			setSubscription(subscription)
-			rgs := getResourceGroups()
-			agentlessCount := getAgentlessCount(rgs)
-			standardAgents := getStandardAgents(rgs)
-			enterpriseAgents := getEntepriseAgents()
+			fmt.Fprintln(out, "Scanning Subscription", subscription.ID)
+			account := inventory.Account(subscription.ID, subscription.Name)
+			if err := setSubscription(subscription.ID); err != nil {
+				//az would scan the previous subscription instead
+				inventory.AddFailure(subscription.ID, "", "Subscription", err)
+				continue
+			}
+			agentlessCounts, failures := getAgentlessCounts(tags, groupBy)
+			standardAgents, f := getStandardAgents(states, tags)
+			failures = append(failures, f...)
+			enterpriseAgents, f := getEntepriseAgents(states, tags)
+			failures = append(failures, f...)
+			repositories, f := getRepositories(tags, helpers.ImageCutoff(imageLookbackDays))
+			failures = append(failures, f...)
+			for _, f := range failures {
+				inventory.AddFailure(subscription.ID, f.Location, f.Service, f.Err)
+			}
+
+			//VMs are resources as well as standard agents
+			agentlessCount := len(standardAgents)
+			var tagGroups []report.TagGroup
+			for _, c := range agentlessCounts {
+				agentlessCount += c.Count
+				region := account.Region(c.Region)
+				region.AddService(c.Service, report.CategoryAgentless, c.Count)
+				if groupBy != "" {
+					g := report.TagGroup{Value: c.Group, AgentlessResources: c.Count}
+					region.AddTagGroup(g)
+					tagGroups = report.AddTagGroup(tagGroups, g)
+				}
+			}
 
-			fmt.Println("\nResources", agentlessCount)
-			fmt.Println("Standard Agents", len(standardAgents))
-			fmt.Println("Enterprise Agents", len(enterpriseAgents))
+			fmt.Fprintln(out, "\nResources", agentlessCount)
+			fmt.Fprintln(out, "Standard Agents", len(standardAgents))
+			fmt.Fprintln(out, "Enterprise Agents", len(enterpriseAgents))
 
+			var vmStates report.StateCounts
 			standardAgentWindowsCount := 0
 			standardAgentLinuxCount := 0
 			for _, vm := range standardAgents {
+				region := account.Region(vm.Location)
+				region.AddService(VM, report.CategoryAgent, 1)
+				region.States.Add(vm.State)
+				vmStates.Add(vm.State)
 				if vm.OS == "Linux" {
 					standardAgentLinuxCount++
+					region.Agents.Standard.Linux++
 				} else {
 					standardAgentWindowsCount++
+					region.Agents.Standard.Windows++
+				}
+				if hosts {
+					region.Hosts = append(region.Hosts, report.Host{ID: vm.ID, AgentType: STANDARD_AGENT, Family: strings.ToLower(vm.OS), Image: vm.Image})
+				}
+				if groupBy != "" {
+					g := report.TagGroup{Value: helpers.GroupValue(vm.Tags, groupBy), StandardVMs: 1}
+					region.AddTagGroup(g)
+					tagGroups = report.AddTagGroup(tagGroups, g)
 				}
 			}
 
 			enterpriseAgentLinuxCount := 0
 			enterpriseAgentWindowsCount := 0
 			for _, vm := range enterpriseAgents {
+				region := account.Region(vm.Location)
+				region.States.Add(vm.State)
+				vmStates.Add(vm.State)
+				if groupBy != "" {
+					g := report.TagGroup{Value: helpers.GroupValue(vm.Tags, groupBy), EnterpriseVMs: 1}
+					region.AddTagGroup(g)
+					tagGroups = report.AddTagGroup(tagGroups, g)
+				}
 				if vm.OS == "Linux" {
 					enterpriseAgentLinuxCount++
+					region.Agents.Enterprise.Linux++
 				} else {
 					enterpriseAgentWindowsCount++
+					region.Agents.Enterprise.Windows++
 				}
 			}
 
-			fmt.Println("\nVM OS Counts")
-			fmt.Printf("Standard Linux VMs %d\n", standardAgentLinuxCount)
-			fmt.Printf("Standard Windows VMs %d\n", standardAgentWindowsCount)
-			fmt.Printf("Enterprise Linux VMs %d\n", enterpriseAgentLinuxCount)
-			fmt.Printf("Enterprise Windows VMs %d\n\n", enterpriseAgentWindowsCount)
+			fmt.Fprintln(out, "\nVM OS Counts")
+			fmt.Fprintf(out, "Standard Linux VMs %d\n", standardAgentLinuxCount)
+			fmt.Fprintf(out, "Standard Windows VMs %d\n", standardAgentWindowsCount)
+			fmt.Fprintf(out, "Enterprise Linux VMs %d\n", enterpriseAgentLinuxCount)
+			fmt.Fprintf(out, "Enterprise Windows VMs %d\n", enterpriseAgentWindowsCount)
+			helpers.PrintStates(out, vmStates)
+			if groupBy != "" {
+				helpers.PrintTagGroups(out, groupBy, tagGroups)
+			}
+
+			var subscriptionRepositories []report.Repository
+			for _, r := range repositories {
+				region := account.Region(r.Location)
+				region.Repositories = append(region.Repositories, r.Repository)
+				subscriptionRepositories = append(subscriptionRepositories, r.Repository)
+			}
+			helpers.PrintRepositories(out, subscriptionRepositories, imageLookbackDays)
+			if len(failures) > 0 {
+				fmt.Fprintf(out, "Totals for subscription %s are incomplete, %d scan(s) failed\n", subscription.ID, len(failures))
+			}
+			fmt.Fprintln(out)
 
 			totalAgentlessCount += agentlessCount
 			totalStandardAgents += len(standardAgents)
@@ -76,86 +230,168 @@ func Run(subscriptionsToIgnore []string, debug bool) {
 			totalStandardAgentWindowsCount += standardAgentWindowsCount
 			totalEnterpriseAgentLinuxCount += enterpriseAgentLinuxCount
 			totalEnterpriseAgentWindowsCount += enterpriseAgentWindowsCount
+			totalStates.Running += vmStates.Running
+			totalStates.Stopped += vmStates.Stopped
+			for _, g := range tagGroups {
+				totalTagGroups = report.AddTagGroup(totalTagGroups, g)
+			}
+			totalRepositories = append(totalRepositories, subscriptionRepositories...)
 		}
 	}
 
-	fmt.Println("----------------------------------------------")
-	fmt.Println("Total Resources", totalAgentlessCount)
-	fmt.Println("Standard Agents", totalStandardAgents)
-	fmt.Println("Enterprise Agents", totalEnterpriseAgents)
+	fmt.Fprintln(out, "----------------------------------------------")
+	fmt.Fprintln(out, "Total Resources", totalAgentlessCount)
+	fmt.Fprintln(out, "Standard Agents", totalStandardAgents)
+	fmt.Fprintln(out, "Enterprise Agents", totalEnterpriseAgents)
+
+	fmt.Fprintln(out, "\nTotal VM OS Counts")
+	fmt.Fprintf(out, "Standard Linux VMs %d\n", totalStandardAgentLinuxCount)
+	fmt.Fprintf(out, "Standard Windows VMs %d\n", totalStandardAgentWindowsCount)
+	fmt.Fprintf(out, "Enterprise Linux VMs %d\n", totalEnterpriseAgentLinuxCount)
+	fmt.Fprintf(out, "Enterprise Windows VMs %d\n", totalEnterpriseAgentWindowsCount)
+	helpers.PrintStates(out, totalStates)
+	if groupBy != "" {
+		helpers.PrintTagGroups(out, groupBy, totalTagGroups)
+	}
+	helpers.PrintRepositories(out, totalRepositories, imageLookbackDays)
 
-	fmt.Println("\nTotal VM OS Counts")
-	fmt.Printf("Standard Linux VMs %d\n", totalStandardAgentLinuxCount)
-	fmt.Printf("Standard Windows VMs %d\n", totalStandardAgentWindowsCount)
-	fmt.Printf("Enterprise Linux VMs %d\n", totalEnterpriseAgentLinuxCount)
-	fmt.Printf("Enterprise Windows VMs %d\n", totalEnterpriseAgentWindowsCount)
+	fmt.Fprintln(out, "\nNumber of Azure subscriptions inventoried", subscriptionsInventoried)
+	fmt.Fprintln(out, "----------------------------------------------")
+	helpers.PrintFailures(out, inventory)
 
-	fmt.Println("\nNumber of Azure subscriptions inventoried", subscriptionsInventoried)
-	fmt.Println("----------------------------------------------")
+	inventory.Finish()
+	return inventory
 }
 
 type VMInfo struct {
-	OS string
-	ID string
+	OS       string
+	ID       string
+	Location string
+	//Image is the publisher:offer:sku:version of marketplace images, empty
+	//for custom images
+	Image string
+	//State is running or stopped
+	State string
+	Tags  map[string]string
+}
+
+func getStandardAgents(states string, tags helpers.TagFilter) ([]VMInfo, []ScanFailure) {
+	log.Infoln("Gathering Standard Agent Count")
+	vms, err := getVMs(states, tags)
+	if err != nil {
+		return nil, []ScanFailure{{Service: VM, Err: err}}
+	}
+	return vms, nil
 }
 
-func getStandardAgents(resourceGroups []string) []VMInfo {
-	fmt.Println("Gathering Standard Agent Count")
-	vmCount := getVMs()
-	return vmCount
+func getEntepriseAgents(states string, tags helpers.TagFilter) ([]VMInfo, []ScanFailure) {
+	log.Infoln("Gathering Enterprise Agent Count")
+	nodes, err := getAKSNodes(states, tags)
+	if err != nil {
+		return nil, []ScanFailure{{Service: AKS_NODE, Err: err}}
+	}
+	return nodes, nil
 }
 
-func getEntepriseAgents() []VMInfo {
-	fmt.Println("Gathering Enterprise Agent Count")
-	nodes := getAKSNodes()
-	return nodes
+// agentlessResult is what one agentless counter found, failures are the
+// services it couldn't count
+type agentlessResult struct {
+	counts   []AgentlessServiceCount
+	failures []ScanFailure
 }
 
-func getAgentlessCount(resourceGroups []string) int {
-	fmt.Println("Gathering resource count")
-	resourceCount := 0
+func getAgentlessCounts(tags helpers.TagFilter, groupBy string) ([]AgentlessServiceCount, []ScanFailure) {
+	log.Infoln("Gathering resource count")
+	var resourceCounts []AgentlessServiceCount
+	var failures []ScanFailure
 	numFuncs := 0
-	channel := make(chan int)
+	channel := make(chan agentlessResult)
 
-	numFuncs += 1
-	go func() {
-		channel <- len(getVMs())
-	}()
+	counters := map[string]func(helpers.TagFilter, string) ([]AgentlessServiceCount, error){
+		VM_SCALE_SET: getVMScaleSet,
+		SQL_SERVER:   getSQLServers,
+		LOADBALANCER: getLoadBalancers,
+	}
+	for service, counter := range counters {
+		numFuncs += 1
+		go func(service string, counter func(helpers.TagFilter, string) ([]AgentlessServiceCount, error)) {
+			counts, err := counter(tags, groupBy)
+			if err != nil {
+				channel <- agentlessResult{failures: []ScanFailure{{Service: service, Err: err}}}
+				return
+			}
+			channel <- agentlessResult{counts: counts}
+		}(service, counter)
+	}
 
 	numFuncs += 1
 	go func() {
-		channel <- getVMScaleSet()
+		counts, failures := getGatewayCount(tags, groupBy)
+		channel <- agentlessResult{counts: counts, failures: failures}
 	}()
 
-	numFuncs += 1
-	go func() {
-		channel <- getSQLServers()
-	}()
+	for i := 0; i < numFuncs; i++ {
+		result := <-channel
+		resourceCounts = append(resourceCounts, result.counts...)
+		failures = append(failures, result.failures...)
+	}
 
-	numFuncs += 1
-	go func() {
-		channel <- getLoadBalancers()
-	}()
+	return resourceCounts, failures
+}
 
-	numFuncs += 1
-	go func(rgs []string) {
-		channel <- getGatewayCount(rgs)
-	}(resourceGroups)
+// getGatewayCount counts the gateways of every resource group, a resource
+// group that can't be listed is a failure and the others are still counted
+func getGatewayCount(tags helpers.TagFilter, groupBy string) ([]AgentlessServiceCount, []ScanFailure) {
+	resourceGroups, err := getResourceGroups()
+	if err != nil {
+		return nil, []ScanFailure{{Service: VNET_GATEWAY, Err: err}}
+	}
 
-	for i := 0; i < numFuncs; i++ {
-		counts := <-channel
-		resourceCount += counts
+	var gateways []AgentlessServiceCount
+	var failures []ScanFailure
+	for _, rg := range resourceGroups {
+		counts, err := getGateways(rg, tags, groupBy)
+		if err != nil {
+			failures = append(failures, ScanFailure{Service: VNET_GATEWAY, Err: err})
+			continue
+		}
+		gateways = append(gateways, counts...)
 	}
+	return gateways, failures
+}
 
-	return resourceCount
+// resourceLocation is the region of a resource and its --group-by-tag group
+type resourceLocation struct {
+	Location string
+	Group    string
 }
 
-func getGatewayCount(resourceGroups []string) int {
-	gatewayCount := 0
-	for _, rg := range resourceGroups {
-		gatewayCount += getGateways(rg)
+func locate(location string, tags map[string]string, groupBy string) resourceLocation {
+	l := resourceLocation{Location: location}
+	if groupBy != "" {
+		l.Group = helpers.GroupValue(tags, groupBy)
+	}
+	return l
+}
+
+// countByLocation turns a list of resource locations into one count per
+// region and group
+func countByLocation(service string, locations []resourceLocation) []AgentlessServiceCount {
+	var counts []AgentlessServiceCount
+	byLocation := make(map[resourceLocation]int)
+	for _, l := range locations {
+		if l.Location == "" {
+			l.Location = UNKNOWN_REGION
+		}
+		if _, ok := byLocation[l]; !ok {
+			counts = append(counts, AgentlessServiceCount{Region: l.Location, Service: service, Group: l.Group})
+		}
+		byLocation[l]++
 	}
-	return gatewayCount
+	for i := range counts {
+		counts[i].Count = byLocation[resourceLocation{Location: counts[i].Region, Group: counts[i].Group}]
+	}
+	return counts
 }
 
 func ParseIgnoreSubscriptions(cmd *cobra.Command) []string {
@@ -172,37 +408,48 @@ func ParseIgnoreSubscriptions(cmd *cobra.Command) []string {
 }
 
 type getGatewayListResponse struct {
-	Name string `json:"name"`
+	Name     string            `json:"name"`
+	Location string            `json:"location"`
+	Tags     map[string]string `json:"tags"`
 }
 
-func getGateways(resourceGroup string) int {
+func getGateways(resourceGroup string, tags helpers.TagFilter, groupBy string) ([]AgentlessServiceCount, error) {
 	buf := bytes.NewBuffer([]byte{})
 
 	cmd := exec.Command("az", "network", "vnet-gateway", "list", "-g", resourceGroup)
 	cmd.Stdout = buf
 	cmd.Stderr = os.Stderr
 	if err := cmd.Run(); err != nil {
-		helpers.Bail("error running az network vnet-gateway list", err)
+		log.Errorln("error running az network vnet-gateway list", err)
+		return nil, fmt.Errorf("az network vnet-gateway list: %w", err)
 	}
 
 	response := []getGatewayListResponse{}
 	if err := json.NewDecoder(buf).Decode(&response); err != nil {
-		helpers.Bail("Error decoding vnet gateways json", err)
+		return nil, fmt.Errorf("decoding vnet gateways json: %w", err)
 	}
 
-	gateways := len(response)
+	var locations []resourceLocation
+	for _, gateway := range response {
+		if !tags.Match(gateway.Tags) {
+			continue
+		}
+		locations = append(locations, locate(gateway.Location, gateway.Tags, groupBy))
+	}
 
-	log.Debugln("gateways returned", resourceGroup, gateways)
-	return gateways
+	log.Debugln("gateways returned", resourceGroup, len(response))
+	return countByLocation(VNET_GATEWAY, locations), nil
 }
 
 type getVMScaleSetResponse struct {
 	SKU struct {
 		Capacity int `json:"capacity"`
 	} `json:"sku"`
+	Location string            `json:"location"`
+	Tags     map[string]string `json:"tags"`
 }
 
-func getVMScaleSet() int {
+func getVMScaleSet(tags helpers.TagFilter, groupBy string) ([]AgentlessServiceCount, error) {
 	buf := bytes.NewBuffer([]byte{})
 
 	//az group list | jq -r '.[] | .name'
@@ -210,121 +457,255 @@ func getVMScaleSet() int {
 	cmd.Stdout = buf
 	cmd.Stderr = os.Stderr
 	if err := cmd.Run(); err != nil {
-		helpers.Bail("error running az vmss list", err)
+		log.Errorln("error running az vmss list", err)
+		return nil, fmt.Errorf("az vmss list: %w", err)
 	}
 
 	response := []getVMScaleSetResponse{}
 	if err := json.NewDecoder(buf).Decode(&response); err != nil {
-		helpers.Bail("Error decoding scalesets json", err)
+		return nil, fmt.Errorf("decoding scalesets json: %w", err)
 	}
 
 	var scalesets int
+	var locations []resourceLocation
 	for _, ss := range response {
+		if !tags.Match(ss.Tags) {
+			continue
+		}
 		scalesets += ss.SKU.Capacity
+		//one entry per instance so the capacity is counted per region
+		for i := 0; i < ss.SKU.Capacity; i++ {
+			locations = append(locations, locate(ss.Location, ss.Tags, groupBy))
+		}
 	}
 
 	log.Debugln("scalesets returned", scalesets)
-	return scalesets
+	return countByLocation(VM_SCALE_SET, locations), nil
 }
 
 type getAKSNodesResponse struct {
+	Location          string            `json:"location"`
+	Tags              map[string]string `json:"tags"`
 	AgentPoolProfiles []struct {
 		PowerState struct {
 			Code string `json:"code"`
 		} `json:"powerState"`
-		Count  int    `json:"count"`
-		Min    int    `json:"minCount"`
-		Max    int    `json:"maxCount"`
-		Mode   string `json:"mode"`
-		OSType string `json:"osType"`
+		Count  int               `json:"count"`
+		Min    int               `json:"minCount"`
+		Max    int               `json:"maxCount"`
+		Mode   string            `json:"mode"`
+		OSType string            `json:"osType"`
+		Tags   map[string]string `json:"tags"`
 	} `json:"agentPoolProfiles"`
 }
 
-func getAKSNodes() []VMInfo {
+func getAKSNodes(states string, tags helpers.TagFilter) ([]VMInfo, error) {
 	buf := bytes.NewBuffer([]byte{})
 
 	cmd := exec.Command("az", "aks", "list")
 	cmd.Stdout = buf
 	cmd.Stderr = os.Stderr
 	if err := cmd.Run(); err != nil {
-		helpers.Bail("error running az aks list", err)
+		log.Errorln("error running az aks list", err)
+		return nil, fmt.Errorf("az aks list: %w", err)
 	}
 
 	response := []getAKSNodesResponse{}
 	if err := json.NewDecoder(buf).Decode(&response); err != nil {
-		helpers.Bail("Error decoding aks nodes json", err)
+		return nil, fmt.Errorf("decoding aks nodes json: %w", err)
 	}
 
 	var nodes []VMInfo
 	for _, cluster := range response {
 		for _, pool := range cluster.AgentPoolProfiles {
 			//both user and system pools, daemonset is installed on all nodes
-			if pool.PowerState.Code == "Running" {
-				nodes = append(nodes, VMInfo{OS: pool.OSType})
+			state := strings.ToLower(pool.PowerState.Code)
+			poolTags := mergeTags(cluster.Tags, pool.Tags)
+			if helpers.IncludeState(states, state) && tags.Match(poolTags) {
+				nodes = append(nodes, VMInfo{OS: pool.OSType, Location: cluster.Location, State: state, Tags: poolTags})
 				//nodes += pool.Count
 			}
 		}
 	}
 
 	log.Debugln("aks nodes returned", nodes)
-	return nodes
+	return nodes, nil
 }
 
 type getSQLServerListResponse struct {
-	Name string `json:"name"`
+	Name     string            `json:"name"`
+	Location string            `json:"location"`
+	Tags     map[string]string `json:"tags"`
 }
 
-func getSQLServers() int {
+func getSQLServers(tags helpers.TagFilter, groupBy string) ([]AgentlessServiceCount, error) {
 	buf := bytes.NewBuffer([]byte{})
 
 	cmd := exec.Command("az", "sql", "server", "list")
 	cmd.Stdout = buf
 	cmd.Stderr = os.Stderr
 	if err := cmd.Run(); err != nil {
-		helpers.Bail("error running az sql server list", err)
+		log.Errorln("error running az sql server list", err)
+		return nil, fmt.Errorf("az sql server list: %w", err)
 	}
 
 	response := []getSQLServerListResponse{}
 	if err := json.NewDecoder(buf).Decode(&response); err != nil {
-		helpers.Bail("Error decoding sqlservers json", err)
+		return nil, fmt.Errorf("decoding sqlservers json: %w", err)
 	}
 
-	sqlservers := len(response)
+	var locations []resourceLocation
+	for _, server := range response {
+		if !tags.Match(server.Tags) {
+			continue
+		}
+		locations = append(locations, locate(server.Location, server.Tags, groupBy))
+	}
 
-	log.Debugln("sqlservers returned", sqlservers)
-	return sqlservers
+	log.Debugln("sqlservers returned", len(response))
+	return countByLocation(SQL_SERVER, locations), nil
 }
 
 type getLoadBalancerListResponse struct {
-	Name string `json:"name"`
+	Name     string            `json:"name"`
+	Location string            `json:"location"`
+	Tags     map[string]string `json:"tags"`
 }
 
-func getLoadBalancers() int {
+func getLoadBalancers(tags helpers.TagFilter, groupBy string) ([]AgentlessServiceCount, error) {
 	buf := bytes.NewBuffer([]byte{})
 
 	cmd := exec.Command("az", "network", "lb", "list")
 	cmd.Stdout = buf
 	cmd.Stderr = os.Stderr
 	if err := cmd.Run(); err != nil {
-		helpers.Bail("error running az network lb list", err)
+		log.Errorln("error running az network lb list", err)
+		return nil, fmt.Errorf("az network lb list: %w", err)
 	}
 
 	response := []getLoadBalancerListResponse{}
 	if err := json.NewDecoder(buf).Decode(&response); err != nil {
-		helpers.Bail("Error decoding loadbalancers json", err)
+		return nil, fmt.Errorf("decoding loadbalancers json: %w", err)
+	}
+
+	var locations []resourceLocation
+	for _, lb := range response {
+		if !tags.Match(lb.Tags) {
+			continue
+		}
+		locations = append(locations, locate(lb.Location, lb.Tags, groupBy))
+	}
+
+	log.Debugln("loadbalancers returned", len(response))
+	return countByLocation(LOADBALANCER, locations), nil
+}
+
+type getRegistryListResponse struct {
+	Name        string            `json:"name"`
+	LoginServer string            `json:"loginServer"`
+	Location    string            `json:"location"`
+	Tags        map[string]string `json:"tags"`
+}
+
+type getManifestListResponse struct {
+	CreatedTime string   `json:"createdTime"`
+	Tags        []string `json:"tags"`
+}
+
+// getRepositories counts the images of each container registry repository
+// pushed after the cutoff and their tags. Listing repositories and manifests
+// needs data plane access to the registry, a registry that denies it is
+// returned as a failure of its location
+func getRepositories(tags helpers.TagFilter, cutoff time.Time) ([]RegistryRepository, []ScanFailure) {
+	buf := bytes.NewBuffer([]byte{})
+
+	cmd := exec.Command("az", "acr", "list")
+	cmd.Stdout = buf
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		log.Errorln("error running az acr list", err)
+		return nil, []ScanFailure{{Service: ACR_REPOSITORY, Err: fmt.Errorf("az acr list: %w", err)}}
+	}
+
+	registries := []getRegistryListResponse{}
+	if err := json.NewDecoder(buf).Decode(&registries); err != nil {
+		return nil, []ScanFailure{{Service: ACR_REPOSITORY, Err: fmt.Errorf("decoding registries json: %w", err)}}
+	}
+
+	var repositories []RegistryRepository
+	var failures []ScanFailure
+	for _, registry := range registries {
+		if !tags.Match(registry.Tags) {
+			continue
+		}
+		names, err := getRegistryRepositories(registry.Name)
+		if err != nil {
+			failures = append(failures, ScanFailure{Location: registry.Location, Service: ACR_REPOSITORY, Err: err})
+			continue
+		}
+		for _, name := range names {
+			repo := report.Repository{Registry: registry.LoginServer, Name: name}
+			manifests, err := getManifests(registry.Name, name)
+			if err != nil {
+				failures = append(failures, ScanFailure{Location: registry.Location, Service: ACR_REPOSITORY, Err: err})
+				continue
+			}
+			for _, m := range manifests {
+				created, err := time.Parse(time.RFC3339, m.CreatedTime)
+				if err == nil && created.After(cutoff) {
+					repo.Images++
+					repo.Tags += len(m.Tags)
+				}
+			}
+			repositories = append(repositories, RegistryRepository{Location: registry.Location, Repository: repo})
+		}
+	}
+
+	log.Debugln("registries returned", len(registries))
+	return repositories, failures
+}
+
+func getRegistryRepositories(registry string) ([]string, error) {
+	buf := bytes.NewBuffer([]byte{})
+
+	cmd := exec.Command("az", "acr", "repository", "list", "--name", registry)
+	cmd.Stdout = buf
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		log.Errorln("error running az acr repository list", registry, err)
+		return nil, fmt.Errorf("az acr repository list %s: %w", registry, err)
 	}
 
-	loadbalancers := len(response)
+	var names []string
+	if err := json.NewDecoder(buf).Decode(&names); err != nil {
+		return nil, fmt.Errorf("decoding repositories of %s: %w", registry, err)
+	}
+	return names, nil
+}
+
+func getManifests(registry string, repository string) ([]getManifestListResponse, error) {
+	buf := bytes.NewBuffer([]byte{})
+
+	cmd := exec.Command("az", "acr", "manifest", "list-metadata", "--registry", registry, "--name", repository)
+	cmd.Stdout = buf
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		log.Errorln("error running az acr manifest list-metadata", registry, repository, err)
+		return nil, fmt.Errorf("az acr manifest list-metadata %s/%s: %w", registry, repository, err)
+	}
 
-	log.Debugln("loadbalancers returned", loadbalancers)
-	return loadbalancers
+	response := []getManifestListResponse{}
+	if err := json.NewDecoder(buf).Decode(&response); err != nil {
+		return nil, fmt.Errorf("decoding manifests of %s/%s: %w", registry, repository, err)
+	}
+	return response, nil
 }
 
 type getGroupListResponse struct {
 	Name string `json:"name"`
 }
 
-func getResourceGroups() []string {
+func getResourceGroups() ([]string, error) {
 	buf := bytes.NewBuffer([]byte{})
 
 	//az group list | jq -r '.[] | .name'
@@ -332,12 +713,13 @@ func getResourceGroups() []string {
 	cmd.Stdout = buf
 	cmd.Stderr = os.Stderr
 	if err := cmd.Run(); err != nil {
-		helpers.Bail("error running az group list", err)
+		log.Errorln("error running az group list", err)
+		return nil, fmt.Errorf("az group list: %w", err)
 	}
 
 	response := []getGroupListResponse{}
 	if err := json.NewDecoder(buf).Decode(&response); err != nil {
-		helpers.Bail("Error decoding groups json", err)
+		return nil, fmt.Errorf("decoding groups json: %w", err)
 	}
 
 	var groups []string
@@ -347,26 +729,28 @@ func getResourceGroups() []string {
 	}
 
 	log.Debugln("resource groups returned", groups)
-	return groups
+	return groups, nil
 }
 
-func setSubscription(subscription string) bool {
+func setSubscription(subscription string) error {
 	buf := bytes.NewBuffer([]byte{})
 
 	cmd := exec.Command("az", "account", "set", "--subscription", subscription)
 	cmd.Stdout = buf
 	cmd.Stderr = os.Stderr
 	if err := cmd.Run(); err != nil {
-		helpers.Bail("error running az set subscription", err)
+		log.Errorln("error running az set subscription", err)
+		return fmt.Errorf("az account set: %w", err)
 	}
 
-	return true
+	return nil
 }
 
 type getVMsListResponse struct {
 	StorageProfile struct {
 		ImageReference struct {
 			Publisher    string `json:"publisher"`
+			Offer        string `json:"offer"`
 			SKU          string `json:"sku"`
 			ExactVersion string `json:"exactVersion"`
 		} `json:"imageReference"`
@@ -374,56 +758,100 @@ type getVMsListResponse struct {
 			OSType string `json:"osType"`
 		} `json:"osDisk"`
 	} `json:"storageProfile"`
-	ID string `json:"vmId"`
+	ID         string            `json:"vmId"`
+	Location   string            `json:"location"`
+	PowerState string            `json:"powerState"`
+	Tags       map[string]string `json:"tags"`
+}
+
+// vmState maps a VM power state to running or stopped, starting VMs count
+// as running and deallocated ones as stopped
+func vmState(powerState string) string {
+	switch powerState {
+	case "VM starting", "VM running":
+		return report.StateRunning
+	case "VM stopping", "VM stopped", "VM deallocating", "VM deallocated":
+		return report.StateStopped
+	}
+	return ""
 }
 
-func getVMs() []VMInfo {
+func getVMs(states string, tags helpers.TagFilter) ([]VMInfo, error) {
 	buf := bytes.NewBuffer([]byte{})
 
-	cmd := exec.Command("az", "vm", "list", "-d", "--query", "[?powerState=='VM running']")
+	cmd := exec.Command("az", "vm", "list", "-d")
 	cmd.Stdout = buf
 	cmd.Stderr = os.Stderr
 	if err := cmd.Run(); err != nil {
-		helpers.Bail("error running az vm list", err)
+		log.Errorln("error running az vm list", err)
+		return nil, fmt.Errorf("az vm list: %w", err)
 	}
 
 	response := []getVMsListResponse{}
 	if err := json.NewDecoder(buf).Decode(&response); err != nil {
-		helpers.Bail("Error decoding vms json", err)
+		return nil, fmt.Errorf("decoding vms json: %w", err)
 	}
 
 	var vms = []VMInfo{}
 	for _, vm := range response {
-		vms = append(vms, VMInfo{OS: vm.StorageProfile.OSDisk.OSType, ID: vm.ID})
+		state := vmState(vm.PowerState)
+		if !helpers.IncludeState(states, state) || !tags.Match(vm.Tags) {
+			continue
+		}
+		vms = append(vms, VMInfo{OS: vm.StorageProfile.OSDisk.OSType, ID: vm.ID, Location: vm.Location, Image: imageURN(vm), State: state, Tags: vm.Tags})
 	}
 
 	log.Debugln("vms returned", vms)
-	return vms
+	return vms, nil
+}
+
+// mergeTags returns the tags of a resource over the tags of its parent
+func mergeTags(parent map[string]string, tags map[string]string) map[string]string {
+	merged := make(map[string]string)
+	for k, v := range parent {
+		merged[k] = v
+	}
+	for k, v := range tags {
+		merged[k] = v
+	}
+	return merged
+}
+
+// imageURN joins the image reference of a VM the way az vm image list prints
+// it, custom images are referenced by ID only and have none
+func imageURN(vm getVMsListResponse) string {
+	image := vm.StorageProfile.ImageReference
+	if image.Publisher == "" {
+		return ""
+	}
+	return strings.Join([]string{image.Publisher, image.Offer, image.SKU, image.ExactVersion}, ":")
 }
 
 type getAccountListResponse struct {
-	ID string `json:"id"`
+	ID   string `json:"id"`
+	Name string `json:"name"`
 }
 
-func getSubscriptions() []string {
+func getSubscriptions() ([]SubscriptionInfo, error) {
 	buf := bytes.NewBuffer([]byte{})
 
 	cmd := exec.Command("az", "account", "list")
 	cmd.Stdout = buf
 	cmd.Stderr = os.Stderr
 	if err := cmd.Run(); err != nil {
-		helpers.Bail("error running az account list", err)
+		log.Errorln("error running az account list", err)
+		return nil, fmt.Errorf("az account list: %w", err)
 	}
 
 	response := []getAccountListResponse{}
 	if err := json.NewDecoder(buf).Decode(&response); err != nil {
-		helpers.Bail("Error decoding subscription json", err)
+		return nil, fmt.Errorf("decoding subscription json: %w", err)
 	}
 
-	var subscriptions []string
+	var subscriptions []SubscriptionInfo
 	for _, account := range response {
-		subscriptions = append(subscriptions, account.ID)
+		subscriptions = append(subscriptions, SubscriptionInfo{ID: account.ID, Name: account.Name})
 	}
 
-	return subscriptions
+	return subscriptions, nil
 }
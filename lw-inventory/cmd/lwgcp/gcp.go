@@ -5,20 +5,33 @@ import (
 	"context"
 	"fmt"
 	"github.com/lacework-dev/scripts/lw-inventory/helpers"
+	"github.com/lacework-dev/scripts/lw-inventory/report"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"google.golang.org/api/artifactregistry/v1"
 	"google.golang.org/api/cloudresourcemanager/v1"
 	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 	"google.golang.org/api/serviceusage/v1"
 	"google.golang.org/api/sqladmin/v1"
 	computepb "google.golang.org/genproto/googleapis/cloud/compute/v1"
+	"io"
+	"os"
+	"strconv"
 	"strings"
+	"time"
 )
 
 const (
-	GCE_VM = "GCE VM"
-	GKE_VM = "GKE VM"
+	GCE_VM       = "GCE VM"
+	GKE_VM       = "GKE VM"
+	LOADBALANCER = "Load Balancer"
+	GATEWAY      = "Gateway"
+	SQL_INSTANCE = "SQL Instance"
+	REPOSITORY   = "Artifact Registry Repository"
+	//agent types of the hosts in the report
+	STANDARD_AGENT   = "Standard Agent"
+	ENTERPRISE_AGENT = "Enterprise Agent"
 )
 
 type ProjectInfo struct {
@@ -27,17 +40,43 @@ type ProjectInfo struct {
 	Number int64
 }
 
+// AgentlessServiceCount is a count of one service, Group is the value of the
+// --group-by-tag label for services with labels
 type AgentlessServiceCount struct {
+	Project string
 	Region  string
 	Service string
+	Group   string
 	Count   int
 }
 
 type VMInstanceInfo struct {
-	Zone   string
+	Project string
+	Zone    string
+	Name    string
+	//Image is the comma separated licenses of the boot disk, which name the
+	//OS of public images
 	Image  string
 	VMType string
 	OS     string
+	//State is running or stopped
+	State  string
+	Labels map[string]string
+}
+
+// ScanFailure is a project and service that could not be fully counted
+type ScanFailure struct {
+	Project string
+	Service string
+	Err     error
+}
+
+// RegistryRepository is a Docker repository of Artifact Registry in a
+// location, with the images pushed since the cutoff
+type RegistryRepository struct {
+	Project    string
+	Location   string
+	Repository report.Repository
 }
 
 type OSCounts struct {
@@ -45,53 +84,199 @@ type OSCounts struct {
 	Linux   int
 }
 
-func Run(projectsToIgnore []string, credentials string, debug bool) {
-	if debug {
+// Options are the settings of a GCP inventory scan
+type Options struct {
+	ProjectsToIgnore []string
+	//credentials file, empty for application default credentials
+	Credentials string
+	Debug       bool
+	//record every agent VM and its image in the report
+	Hosts bool
+	//VM lifecycle states to inventory: running, stopped or all
+	States string
+	Tags   helpers.TagFilter
+	//label key to break VMs and agentless resources down by
+	GroupBy string
+	//container images pushed within this many days are counted
+	ImageLookbackDays int
+	//progress and summary text, stdout when nil
+	Progress io.Writer
+}
+
+// Run inventories the projects of the credentials and prints the summary to
+// the progress writer
+func Run(opts Options) *report.Report {
+	projectsToIgnore, credentials, hosts, states := opts.ProjectsToIgnore, opts.Credentials, opts.Hosts, opts.States
+	tags, groupBy, imageLookbackDays := opts.Tags, opts.GroupBy, opts.ImageLookbackDays
+	if opts.Debug {
 		log.SetLevel(log.DebugLevel)
 	}
+	out := opts.Progress
+	if out == nil {
+		out = os.Stdout
+	}
+
+	inventory := report.New("gcp")
+	if len(projectsToIgnore) > 0 {
+		inventory.Metadata.Options["projects-to-ignore"] = strings.Join(projectsToIgnore, ",")
+	}
+	inventory.Metadata.Options["states"] = states
+	inventory.Metadata.Options["image-lookback-days"] = strconv.Itoa(imageLookbackDays)
+	tags.SetOptions(inventory.Metadata.Options)
+	if groupBy != "" {
+		inventory.Metadata.Options["group-by-tag"] = groupBy
+	}
+	if tags.Enabled() || groupBy != "" {
+		//routers carry no labels
+		inventory.Metadata.Options["tags-unavailable"] = GATEWAY
+		fmt.Fprintln(out, "Labels aren't available for", GATEWAY)
+	}
+
+	projects, err := getProjects(credentials, projectsToIgnore)
+	if err != nil {
+		inventory.AddFailure("", "", "Projects", err)
+	}
+	for _, project := range projects {
+		inventory.Account(project.ID, project.Name)
+	}
 
-	projects := getProjects(credentials, projectsToIgnore)
-	agentlessCount := getAgentlessCount(credentials, projects)
+	agentlessCount := 0
+	var tagGroups []report.TagGroup
+	agentlessCounts, failures := getAgentlessCounts(credentials, projects, tags, groupBy)
+	for _, c := range agentlessCounts {
+		agentlessCount += c.Count
+		region := inventory.Account(c.Project, "").Region(c.Region)
+		region.AddService(c.Service, report.CategoryAgentless, c.Count)
+		if c.Group != "" {
+			g := report.TagGroup{Value: c.Group, AgentlessResources: c.Count}
+			region.AddTagGroup(g)
+			tagGroups = report.AddTagGroup(tagGroups, g)
+		}
+	}
 
-	vms := getVMInstances(credentials, projects)
+	vms, vmFailures := getVMInstances(credentials, projects, states, tags)
+	failures = append(failures, vmFailures...)
+	repositories, repositoryFailures := getRepositories(credentials, projects, tags, helpers.ImageCutoff(imageLookbackDays))
+	failures = append(failures, repositoryFailures...)
+	var totalRepositories []report.Repository
+	for _, r := range repositories {
+		region := inventory.Account(r.Project, "").Region(r.Location)
+		region.Repositories = append(region.Repositories, r.Repository)
+		totalRepositories = append(totalRepositories, r.Repository)
+	}
+	for _, f := range failures {
+		inventory.AddFailure(f.Project, "", f.Service, f.Err)
+	}
 	enterpriseVMs := getEntepriseAgents(vms)
 	standardVMs := getStandardAgents(vms)
 
-	fmt.Println("----------------------------------------------")
-	fmt.Printf("Total Resources %d\n", agentlessCount+len(vms))
-	fmt.Printf("Standard VM Agents: %d\n", len(standardVMs))
-	fmt.Printf("Enterprise VM Agents: %d\n", len(enterpriseVMs))
-	fmt.Println("Number of GCP projects inventoried", len(projects))
-	fmt.Println("----------------------------------------------")
+	var vmStates report.StateCounts
+	for _, vm := range vms {
+		region := inventory.Account(vm.Project, "").Region(regionFromKey(vm.Zone))
+		region.AddService(vm.VMType, report.CategoryAgent, 1)
+		region.States.Add(vm.State)
+		vmStates.Add(vm.State)
+		counts := &region.Agents.Standard
+		agentType := STANDARD_AGENT
+		if vm.VMType == GKE_VM {
+			counts = &region.Agents.Enterprise
+			agentType = ENTERPRISE_AGENT
+		}
+		if hosts {
+			region.Hosts = append(region.Hosts, report.Host{ID: vm.Name, AgentType: agentType, Family: strings.ToLower(vm.OS), Image: vm.Image})
+		}
+		if groupBy != "" {
+			g := report.TagGroup{Value: helpers.GroupValue(vm.Labels, groupBy)}
+			if agentType == STANDARD_AGENT {
+				g.StandardVMs = 1
+			} else {
+				g.EnterpriseVMs = 1
+			}
+			region.AddTagGroup(g)
+			tagGroups = report.AddTagGroup(tagGroups, g)
+		}
+		if vm.OS == "Windows" {
+			counts.Windows++
+		} else {
+			counts.Linux++
+		}
+	}
+
+	fmt.Fprintln(out, "----------------------------------------------")
+	fmt.Fprintf(out, "Total Resources %d\n", agentlessCount+len(vms))
+	fmt.Fprintf(out, "Standard VM Agents: %d\n", len(standardVMs))
+	fmt.Fprintf(out, "Enterprise VM Agents: %d\n", len(enterpriseVMs))
+	helpers.PrintStates(out, vmStates)
+	if groupBy != "" {
+		helpers.PrintTagGroups(out, groupBy, tagGroups)
+	}
+	helpers.PrintRepositories(out, totalRepositories, imageLookbackDays)
+	fmt.Fprintln(out, "Number of GCP projects inventoried", len(projects))
+	fmt.Fprintln(out, "----------------------------------------------")
+	helpers.PrintFailures(out, inventory)
+
+	inventory.Finish()
+	return inventory
+}
+
+// regionFromKey turns an aggregated list key such as zones/us-central1-a or
+// regions/us-central1 into the region name
+func regionFromKey(key string) string {
+	if strings.HasPrefix(key, "zones/") {
+		zone := strings.TrimPrefix(key, "zones/")
+		return zone[:strings.LastIndex(zone, "-")]
+	}
+	return strings.TrimPrefix(key, "regions/")
 }
 
-func getAgentlessCount(credentials string, projects []ProjectInfo) int {
-	fmt.Println("Gathering resource count")
-	resourceCount := 0
+// agentlessResult is what one agentless getter counted and where it failed
+type agentlessResult struct {
+	counts   []AgentlessServiceCount
+	failures []ScanFailure
+}
+
+func getAgentlessCounts(credentials string, projects []ProjectInfo, tags helpers.TagFilter, groupBy string) ([]AgentlessServiceCount, []ScanFailure) {
+	log.Infoln("Gathering resource count")
+	var resourceCounts []AgentlessServiceCount
+	var failures []ScanFailure
 	numFuncs := 0
-	channel := make(chan int)
+	channel := make(chan agentlessResult)
 
 	numFuncs += 1
 	go func(credentials string, projects []ProjectInfo) {
-		channel <- getLoadBalancers(credentials, projects)
+		counts, failures := getLoadBalancers(credentials, projects, tags, groupBy)
+		channel <- agentlessResult{counts, failures}
 	}(credentials, projects)
 
 	numFuncs += 1
 	go func(credentials string, projects []ProjectInfo) {
-		channel <- getGateways(credentials, projects)
+		counts, failures := getGateways(credentials, projects)
+		channel <- agentlessResult{counts, failures}
 	}(credentials, projects)
 
 	numFuncs += 1
 	go func(credentials string, projects []ProjectInfo) {
-		channel <- getSQLServerInstances(credentials, projects)
+		counts, failures := getSQLServerInstances(credentials, projects, tags, groupBy)
+		channel <- agentlessResult{counts, failures}
 	}(credentials, projects)
 
 	for i := 0; i < numFuncs; i++ {
-		counts := <-channel
-		resourceCount += counts
+		result := <-channel
+		resourceCounts = append(resourceCounts, result.counts...)
+		failures = append(failures, result.failures...)
 	}
 
-	return resourceCount
+	return resourceCounts, failures
+}
+
+// failProjects marks a service as failed in every project, for errors that
+// happen before any project is scanned
+func failProjects(projects []ProjectInfo, service string, err error) []ScanFailure {
+	var failures []ScanFailure
+	for _, project := range projects {
+		failures = append(failures, ScanFailure{Project: project.ID, Service: service, Err: err})
+	}
+	return failures
 }
 
 func getStandardAgents(vms []VMInstanceInfo) []VMInstanceInfo {
@@ -116,22 +301,28 @@ func getEntepriseAgents(vms []VMInstanceInfo) []VMInstanceInfo {
 	return enterpriseVMs
 }
 
-func getLoadBalancers(credentials string, projects []ProjectInfo) int {
-	fmt.Println("Inventorying LoadBalancers")
+func getLoadBalancers(credentials string, projects []ProjectInfo, tags helpers.TagFilter, groupBy string) ([]AgentlessServiceCount, []ScanFailure) {
+	log.Infoln("Inventorying LoadBalancers")
 	ctx := context.Background()
 
 	loadbalancerCount := 0
+	var counts []AgentlessServiceCount
+	var failures []ScanFailure
 
-	for _, project := range projects {
-		if isServiceEnabled(project, "compute.googleapis.com", credentials) {
-			instancesClient, err := compute.NewForwardingRulesRESTClient(ctx)
-
-			if err != nil {
-				fmt.Errorf("NewInstancesRESTClient: %v", err)
-				return 0
-			}
-			defer instancesClient.Close()
+	instancesClient, err := compute.NewForwardingRulesRESTClient(ctx)
+	if err != nil {
+		log.Errorf("NewInstancesRESTClient: %v", err)
+		return counts, failProjects(projects, LOADBALANCER, err)
+	}
+	defer instancesClient.Close()
 
+	for _, project := range projects {
+		enabled, err := isServiceEnabled(project, "compute.googleapis.com", credentials)
+		if err != nil {
+			failures = append(failures, ScanFailure{Project: project.ID, Service: LOADBALANCER, Err: err})
+			continue
+		}
+		if enabled {
 			req := &computepb.AggregatedListForwardingRulesRequest{
 				Project: project.ID,
 			}
@@ -146,40 +337,66 @@ func getLoadBalancers(credentials string, projects []ProjectInfo) int {
 					break
 				}
 				if err != nil {
-					fmt.Errorf("getLoadBalancers pair iterator: %v", err)
-					return 0
+					log.Errorf("getLoadBalancers pair iterator: %v", err)
+					failures = append(failures, ScanFailure{Project: project.ID, Service: LOADBALANCER, Err: err})
+					break
 				}
 				//fmt.Println(pair)
 				if pair.Value.ForwardingRules != nil {
 					//fmt.Println(pair)
-					loadbalancerCount += len(pair.Value.ForwardingRules)
+					//one count per group, a single one when not grouping
+					groups := make(map[string]int)
+					var order []string
+					for _, rule := range pair.Value.ForwardingRules {
+						if !tags.Match(rule.GetLabels()) {
+							continue
+						}
+						group := ""
+						if groupBy != "" {
+							group = helpers.GroupValue(rule.GetLabels(), groupBy)
+						}
+						if _, ok := groups[group]; !ok {
+							order = append(order, group)
+						}
+						groups[group]++
+						loadbalancerCount++
+					}
+					for _, group := range order {
+						counts = append(counts, AgentlessServiceCount{Project: project.ID, Region: regionFromKey(pair.Key), Service: LOADBALANCER, Group: group, Count: groups[group]})
+					}
 				}
 			}
 		} else {
-			fmt.Println("Compute not enabled for ", project.Name, "("+project.ID+")")
+			log.Infoln("Compute not enabled for ", project.Name, "("+project.ID+")")
 		}
 	}
 
 	log.Debugln("LoadBalancers found", loadbalancerCount)
-	return loadbalancerCount
+	return counts, failures
 }
 
-func getGateways(credentials string, projects []ProjectInfo) int {
-	fmt.Println("Inventorying Gateways")
+func getGateways(credentials string, projects []ProjectInfo) ([]AgentlessServiceCount, []ScanFailure) {
+	log.Infoln("Inventorying Gateways")
 	ctx := context.Background()
 
 	routerCount := 0
+	var counts []AgentlessServiceCount
+	var failures []ScanFailure
 
-	for _, project := range projects {
-		if isServiceEnabled(project, "compute.googleapis.com", credentials) {
-			instancesClient, err := compute.NewRoutersRESTClient(ctx)
-
-			if err != nil {
-				fmt.Errorf("NewInstancesRESTClient: %v", err)
-				return 0
-			}
-			defer instancesClient.Close()
+	instancesClient, err := compute.NewRoutersRESTClient(ctx)
+	if err != nil {
+		log.Errorf("NewInstancesRESTClient: %v", err)
+		return counts, failProjects(projects, GATEWAY, err)
+	}
+	defer instancesClient.Close()
 
+	for _, project := range projects {
+		enabled, err := isServiceEnabled(project, "compute.googleapis.com", credentials)
+		if err != nil {
+			failures = append(failures, ScanFailure{Project: project.ID, Service: GATEWAY, Err: err})
+			continue
+		}
+		if enabled {
 			req := &computepb.AggregatedListRoutersRequest{
 				Project: project.ID,
 			}
@@ -194,22 +411,24 @@ func getGateways(credentials string, projects []ProjectInfo) int {
 					break
 				}
 				if err != nil {
-					fmt.Errorf("getGateways pair iterator: %v", err)
-					return 0
+					log.Errorf("getGateways pair iterator: %v", err)
+					failures = append(failures, ScanFailure{Project: project.ID, Service: GATEWAY, Err: err})
+					break
 				}
 				//fmt.Println(pair)
 				if pair.Value.Routers != nil {
 					//fmt.Println(pair)
 					routerCount += len(pair.Value.Routers)
+					counts = append(counts, AgentlessServiceCount{Project: project.ID, Region: regionFromKey(pair.Key), Service: GATEWAY, Count: len(pair.Value.Routers)})
 				}
 			}
 		} else {
-			fmt.Println("Compute not enabled for ", project.Name, "("+project.ID+")")
+			log.Infoln("Compute not enabled for ", project.Name, "("+project.ID+")")
 		}
 	}
 
 	log.Debugln("Gateways found", routerCount)
-	return routerCount
+	return counts, failures
 }
 
 func ParseCredentials(cmd *cobra.Command) string {
@@ -229,36 +448,137 @@ func ParseProjectsToIgnore(cmd *cobra.Command) []string {
 	return projectsToIgnore
 }
 
-func getSQLServerInstances(credentials string, projects []ProjectInfo) int {
-	fmt.Println("Inventorying SQL")
+func getSQLServerInstances(credentials string, projects []ProjectInfo, tags helpers.TagFilter, groupBy string) ([]AgentlessServiceCount, []ScanFailure) {
+	log.Infoln("Inventorying SQL")
 	ctx := context.Background()
+
+	sqlCount := 0
+	var counts []AgentlessServiceCount
+	var failures []ScanFailure
+
 	sqlService, err := sqladmin.NewService(ctx, option.WithCredentialsFile(credentials))
 	if err != nil {
-		log.Fatalln("error in getSQLServerInstances", err)
+		log.Errorln("error in getSQLServerInstances", err)
+		return counts, failProjects(projects, SQL_INSTANCE, err)
 	}
 
-	sqlCount := 0
-
 	for _, project := range projects {
-		if isServiceEnabled(project, "sqladmin.googleapis.com", credentials) {
+		enabled, err := isServiceEnabled(project, "sqladmin.googleapis.com", credentials)
+		if err != nil {
+			failures = append(failures, ScanFailure{Project: project.ID, Service: SQL_INSTANCE, Err: err})
+			continue
+		}
+		if enabled {
 			//fmt.Println("got in enabled")
 			req := sqlService.Instances.List(project.ID)
 			if err := req.Pages(ctx, func(page *sqladmin.InstancesListResponse) error {
-				//for _, db := range page.Items {
-				//	fmt.Println(db.Name)
-				//}
-				sqlCount += len(page.Items)
+				for _, db := range page.Items {
+					var labels map[string]string
+					if db.Settings != nil {
+						labels = db.Settings.UserLabels
+					}
+					if !tags.Match(labels) {
+						continue
+					}
+					group := ""
+					if groupBy != "" {
+						group = helpers.GroupValue(labels, groupBy)
+					}
+					counts = append(counts, AgentlessServiceCount{Project: project.ID, Region: db.Region, Service: SQL_INSTANCE, Group: group, Count: 1})
+					sqlCount++
+				}
 				return nil
 			}); err != nil {
-				log.Fatal("err in getSQLServerInstances", err)
+				log.Errorln("err in getSQLServerInstances", err)
+				failures = append(failures, ScanFailure{Project: project.ID, Service: SQL_INSTANCE, Err: err})
 			}
 		} else {
-			fmt.Println("SQL not enabled for", project.Name, "("+project.ID+")")
+			log.Infoln("SQL not enabled for", project.Name, "("+project.ID+")")
 		}
 	}
 
 	log.Debugln("SQL Servers found", sqlCount)
-	return sqlCount
+	return counts, failures
+}
+
+// getRepositories counts the images of the Docker repositories of Artifact
+// Registry pushed after the cutoff and their tags, gcr.io repositories hosted
+// by Artifact Registry are included
+func getRepositories(credentials string, projects []ProjectInfo, tags helpers.TagFilter, cutoff time.Time) ([]RegistryRepository, []ScanFailure) {
+	log.Infoln("Inventorying Artifact Registry")
+	ctx := context.Background()
+
+	var repositories []RegistryRepository
+	var failures []ScanFailure
+
+	registryService, err := artifactregistry.NewService(ctx, option.WithCredentialsFile(credentials))
+	if err != nil {
+		log.Errorln("error in getRepositories", err)
+		return repositories, failProjects(projects, REPOSITORY, err)
+	}
+
+	for _, project := range projects {
+		enabled, err := isServiceEnabled(project, "artifactregistry.googleapis.com", credentials)
+		if err != nil {
+			failures = append(failures, ScanFailure{Project: project.ID, Service: REPOSITORY, Err: err})
+			continue
+		}
+		if !enabled {
+			log.Infoln("Artifact Registry not enabled for", project.Name, "("+project.ID+")")
+			continue
+		}
+
+		var locations []*artifactregistry.Location
+		if err := registryService.Projects.Locations.List("projects/"+project.ID).Pages(ctx, func(page *artifactregistry.ListLocationsResponse) error {
+			locations = append(locations, page.Locations...)
+			return nil
+		}); err != nil {
+			log.Errorln("err in getRepositories locations", err)
+			failures = append(failures, ScanFailure{Project: project.ID, Service: REPOSITORY, Err: err})
+			continue
+		}
+
+		for _, location := range locations {
+			var dockerRepositories []*artifactregistry.Repository
+			if err := registryService.Projects.Locations.Repositories.List(location.Name).Pages(ctx, func(page *artifactregistry.ListRepositoriesResponse) error {
+				for _, r := range page.Repositories {
+					if r.Format == "DOCKER" && tags.Match(r.Labels) {
+						dockerRepositories = append(dockerRepositories, r)
+					}
+				}
+				return nil
+			}); err != nil {
+				log.Errorln("err in getRepositories", location.LocationId, err)
+				failures = append(failures, ScanFailure{Project: project.ID, Service: REPOSITORY, Err: err})
+				continue
+			}
+
+			for _, r := range dockerRepositories {
+				repo := report.Repository{
+					Registry: location.LocationId + "-docker.pkg.dev/" + project.ID,
+					Name:     r.Name[strings.LastIndex(r.Name, "/")+1:],
+				}
+				if err := registryService.Projects.Locations.Repositories.DockerImages.List(r.Name).Pages(ctx, func(page *artifactregistry.ListDockerImagesResponse) error {
+					for _, image := range page.DockerImages {
+						uploaded, err := time.Parse(time.RFC3339, image.UploadTime)
+						if err == nil && uploaded.After(cutoff) {
+							repo.Images++
+							repo.Tags += len(image.Tags)
+						}
+					}
+					return nil
+				}); err != nil {
+					log.Errorln("err in getRepositories images", r.Name, err)
+					failures = append(failures, ScanFailure{Project: project.ID, Service: REPOSITORY, Err: err})
+					continue
+				}
+				repositories = append(repositories, RegistryRepository{Project: project.ID, Location: location.LocationId, Repository: repo})
+			}
+		}
+	}
+
+	log.Debugln("Artifact Registry repositories found", len(repositories))
+	return repositories, failures
 }
 
 func isProjectValid(project *cloudresourcemanager.Project, projectsToIgnore []string) bool {
@@ -266,38 +586,43 @@ func isProjectValid(project *cloudresourcemanager.Project, projectsToIgnore []st
 	return yesno
 }
 
-func isServiceEnabled(project ProjectInfo, service string, credentials string) bool {
+func isServiceEnabled(project ProjectInfo, service string, credentials string) (bool, error) {
 	ctx := context.Background()
 	c, err := serviceusage.NewService(ctx, option.WithCredentialsFile(credentials))
 	if err != nil {
-		fmt.Println("service usage new service error for project ", project.ID, err)
-		return false
+		log.Errorln("service usage new service error for project ", project.ID, err)
+		return false, err
 	}
 
 	resp, err := c.Services.Get(fmt.Sprintf("projects/%d/services/%s", project.Number, service)).Do()
 	if err != nil {
 		log.Println("services get error", project.ID, err)
-		return false
+		return false, err
 	}
-	return resp.State == "ENABLED"
+	return resp.State == "ENABLED", nil
 }
 
-func getVMInstances(credentials string, projects []ProjectInfo) []VMInstanceInfo {
-	fmt.Println("Inventorying Compute")
+func getVMInstances(credentials string, projects []ProjectInfo, states string, tags helpers.TagFilter) ([]VMInstanceInfo, []ScanFailure) {
+	log.Infoln("Inventorying Compute")
 	ctx := context.Background()
 
 	var vms []VMInstanceInfo
+	var failures []ScanFailure
 
-	for _, project := range projects {
-		if isServiceEnabled(project, "compute.googleapis.com", credentials) {
-			instancesClient, err := compute.NewInstancesRESTClient(ctx)
-
-			if err != nil {
-				fmt.Errorf("NewInstancesRESTClient: %v", err)
-				return nil
-			}
-			defer instancesClient.Close()
+	instancesClient, err := compute.NewInstancesRESTClient(ctx)
+	if err != nil {
+		log.Errorf("NewInstancesRESTClient: %v", err)
+		return nil, failProjects(projects, GCE_VM, err)
+	}
+	defer instancesClient.Close()
 
+	for _, project := range projects {
+		enabled, err := isServiceEnabled(project, "compute.googleapis.com", credentials)
+		if err != nil {
+			failures = append(failures, ScanFailure{Project: project.ID, Service: GCE_VM, Err: err})
+			continue
+		}
+		if enabled {
 			req := &computepb.AggregatedListInstancesRequest{
 				Project: project.ID,
 			}
@@ -312,38 +637,87 @@ func getVMInstances(credentials string, projects []ProjectInfo) []VMInstanceInfo
 					break
 				}
 				if err != nil {
-					fmt.Errorf("NewInstancesRESTClient pair iterator: %v", err)
-					return nil
+					log.Errorf("NewInstancesRESTClient pair iterator: %v", err)
+					failures = append(failures, ScanFailure{Project: project.ID, Service: GCE_VM, Err: err})
+					break
 				}
 				instances := pair.Value.Instances
 				if len(instances) > 0 {
 					for _, instance := range instances {
 						//fmt.Println(instance)
-						if instance.GetStatus() == "RUNNING" {
+						state := instanceState(instance.GetStatus())
+						if helpers.IncludeState(states, state) && tags.Match(instance.GetLabels()) {
+							vm := VMInstanceInfo{Project: project.ID, Zone: pair.Key, Name: instance.GetName(), Image: getInstanceLicenses(instance), VMType: GCE_VM, OS: getInstanceOS(instance), State: state, Labels: instance.GetLabels()}
 							if _, ok := instance.GetLabels()["goog-gke-node"]; ok {
-								vms = append(vms, VMInstanceInfo{Zone: pair.Key, VMType: GKE_VM})
-							} else {
-								vms = append(vms, VMInstanceInfo{Zone: pair.Key, VMType: GCE_VM})
+								vm.VMType = GKE_VM
 							}
+							vms = append(vms, vm)
 						}
 					}
 				}
 			}
 		} else {
-			fmt.Println("Compute not enabled for ", project.Name, "("+project.ID+")")
+			log.Infoln("Compute not enabled for ", project.Name, "("+project.ID+")")
 		}
 	}
 
 	log.Debugln("VMs found", len(vms))
-	return vms
+	return vms, failures
+}
+
+// instanceState maps an instance status to running or stopped, instances
+// being provisioned or repaired count as running and suspended or
+// terminated ones as stopped
+func instanceState(status string) string {
+	switch status {
+	case "PROVISIONING", "STAGING", "RUNNING", "REPAIRING":
+		return report.StateRunning
+	case "STOPPING", "SUSPENDING", "SUSPENDED", "TERMINATED":
+		return report.StateStopped
+	}
+	return ""
+}
+
+// getInstanceOS reports Windows when the boot disk image declares the WINDOWS
+// guest OS feature, otherwise Linux
+func getInstanceOS(instance *computepb.Instance) string {
+	for _, disk := range instance.GetDisks() {
+		if !disk.GetBoot() {
+			continue
+		}
+		for _, feature := range disk.GetGuestOsFeatures() {
+			if feature.GetType() == computepb.GuestOsFeature_WINDOWS.String() {
+				return "Windows"
+			}
+		}
+	}
+	return "Linux"
+}
+
+// getInstanceLicenses returns the license names of the boot disk, such as
+// ubuntu-2204-lts, without their project path
+func getInstanceLicenses(instance *computepb.Instance) string {
+	var licenses []string
+	for _, disk := range instance.GetDisks() {
+		if !disk.GetBoot() {
+			continue
+		}
+		for _, l := range disk.GetLicenses() {
+			licenses = append(licenses, l[strings.LastIndex(l, "/")+1:])
+		}
+	}
+	return strings.Join(licenses, ",")
 }
 
-func getProjects(credentials string, projectsToIgnore []string) []ProjectInfo {
-	fmt.Println("Inventorying Compute")
+// getProjects lists the active projects to inventory, the projects listed
+// before an error are returned with it
+func getProjects(credentials string, projectsToIgnore []string) ([]ProjectInfo, error) {
+	log.Infoln("Inventorying Compute")
 	ctx := context.Background()
 	service, err := cloudresourcemanager.NewService(ctx, option.WithCredentialsFile(credentials))
 	if err != nil {
-		log.Fatalln(err)
+		log.Errorln("error in getProjects", err)
+		return nil, fmt.Errorf("NewService: %w", err)
 	}
 
 	var projects []ProjectInfo
@@ -351,7 +725,7 @@ func getProjects(credentials string, projectsToIgnore []string) []ProjectInfo {
 	if err := req.Pages(ctx, func(page *cloudresourcemanager.ListProjectsResponse) error {
 		for _, project := range page.Projects {
 			if isProjectValid(project, projectsToIgnore) {
-				fmt.Println("Scanning project", project.ProjectId)
+				log.Infoln("Scanning project", project.ProjectId)
 
 				projects = append(projects, ProjectInfo{
 					ID:     project.ProjectId,
@@ -362,9 +736,10 @@ func getProjects(credentials string, projectsToIgnore []string) []ProjectInfo {
 		}
 		return nil
 	}); err != nil {
-		log.Fatal(err)
+		log.Errorln("err in getProjects", err)
+		return projects, fmt.Errorf("Projects.List: %w", err)
 	}
 
 	log.Debugln("Projects found", projects)
-	return projects
+	return projects, nil
 }
@@ -0,0 +1,217 @@
+package lwsupport
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/lacework-dev/scripts/lw-inventory/helpers"
+	"github.com/lacework-dev/scripts/lw-inventory/report"
+	"github.com/spf13/cobra"
+)
+
+// matrixJSON is the supported OS matrix shipped with the tool, --matrix
+// points at an updated copy
+//
+//go:embed matrix.json
+var matrixJSON []byte
+
+// Matrix lists operating systems and whether the agent supports them, the
+// first OS with an image pattern matching a host wins. Version is the date of
+// the Source page the entries were taken from
+type Matrix struct {
+	Version          string            `json:"version"`
+	Source           string            `json:"source"`
+	OperatingSystems []OperatingSystem `json:"operating_systems"`
+}
+
+// OperatingSystem holds image patterns per cloud, * matches any text and
+// patterns are case insensitive
+type OperatingSystem struct {
+	Name    string   `json:"name"`
+	Support string   `json:"support"`
+	AWS     []string `json:"aws"`
+	GCP     []string `json:"gcp"`
+	Azure   []string `json:"azure"`
+	//compiled patterns per cloud
+	patterns map[string][]*regexp.Regexp
+}
+
+// LoadMatrix reads the matrix file at path, or the embedded matrix when path
+// is empty
+func LoadMatrix(path string) (*Matrix, error) {
+	data := matrixJSON
+	if path != "" {
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	return parseMatrix(data)
+}
+
+func parseMatrix(data []byte) (*Matrix, error) {
+	m := &Matrix{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("decoding support matrix: %w", err)
+	}
+
+	for i, o := range m.OperatingSystems {
+		if o.Support != report.SupportSupported && o.Support != report.SupportUnsupported {
+			return nil, fmt.Errorf("%s: support must be %s or %s, not %q", o.Name, report.SupportSupported, report.SupportUnsupported, o.Support)
+		}
+		m.OperatingSystems[i].patterns = map[string][]*regexp.Regexp{}
+		for cloud, patterns := range map[string][]string{"aws": o.AWS, "gcp": o.GCP, "azure": o.Azure} {
+			for _, p := range patterns {
+				re, err := compilePattern(p)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %s pattern %q: %w", o.Name, cloud, p, err)
+				}
+				m.OperatingSystems[i].patterns[cloud] = append(m.OperatingSystems[i].patterns[cloud], re)
+			}
+		}
+	}
+	return m, nil
+}
+
+// compilePattern turns a pattern where * matches any text into an anchored,
+// case insensitive regexp
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	quoted := strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*")
+	return regexp.Compile("(?i)^" + quoted + "$")
+}
+
+// Classify returns the OS and support status of an image of the cloud. An
+// image can name several comma separated values, such as GCP licenses, any
+// of which may match
+func (m *Matrix) Classify(cloud string, image string) (string, string) {
+	if image == "" {
+		return "", report.SupportUnknown
+	}
+	names := strings.Split(image, ",")
+	for _, o := range m.OperatingSystems {
+		for _, p := range o.patterns[cloud] {
+			for _, name := range names {
+				if p.MatchString(strings.TrimSpace(name)) {
+					return o.Name, o.Support
+				}
+			}
+		}
+	}
+	return "", report.SupportUnknown
+}
+
+// Check classifies every host of the report and totals the result
+func (m *Matrix) Check(r *report.Report) {
+	for _, a := range r.Accounts {
+		for _, region := range a.Regions {
+			for i, h := range region.Hosts {
+				region.Hosts[i].OS, region.Hosts[i].Support = m.Classify(r.Cloud, h.Image)
+			}
+		}
+	}
+	r.Metadata.Options["support-matrix"] = m.Version
+	r.Total()
+}
+
+// Run checks the hosts of a JSON inventory report against the matrix and
+// prints supported, unsupported and unknown hosts per account to out
+func Run(reportPath string, matrixPath string, out io.Writer) *report.Report {
+	m, err := LoadMatrix(matrixPath)
+	if err != nil {
+		helpers.Bail("error loading support matrix", err)
+	}
+
+	in := os.Stdin
+	if reportPath != "-" {
+		if in, err = os.Open(reportPath); err != nil {
+			helpers.Bail("error opening report", err)
+		}
+		defer in.Close()
+	}
+	inventory, err := report.ReadJSON(in)
+	if err != nil {
+		helpers.Bail("error reading report", err)
+	}
+	if countHosts(inventory) == 0 {
+		helpers.Bail("the report has no hosts, rerun the inventory with --hosts --output json", nil)
+	}
+
+	m.Check(inventory)
+
+	fmt.Fprintln(out, "Agent support matrix", m.Version)
+	for _, a := range inventory.Accounts {
+		support := a.Totals.AgentSupport
+		if support == nil {
+			continue
+		}
+		fmt.Fprintln(out, "----------------------------------------------")
+		fmt.Fprintln(out, "Account", a.ID, a.Name)
+		fmt.Fprintf(out, "Supported Hosts: %d\n", support.Supported)
+		fmt.Fprintf(out, "Unsupported Hosts: %d\n", support.Unsupported)
+		fmt.Fprintf(out, "Unknown Hosts: %d\n", support.Unknown)
+		printHosts(out, a)
+	}
+
+	fmt.Fprintln(out, "----------------------------------------------")
+	if support := inventory.Totals.AgentSupport; support != nil {
+		fmt.Fprintf(out, "Total Supported Hosts: %d\n", support.Supported)
+		fmt.Fprintf(out, "Total Unsupported Hosts: %d\n", support.Unsupported)
+		fmt.Fprintf(out, "Total Unknown Hosts: %d\n", support.Unknown)
+	}
+	fmt.Fprintln(out, "----------------------------------------------")
+
+	return inventory
+}
+
+func countHosts(r *report.Report) int {
+	hosts := 0
+	for _, a := range r.Accounts {
+		for _, region := range a.Regions {
+			hosts += len(region.Hosts)
+		}
+	}
+	return hosts
+}
+
+// printHosts lists unsupported hosts one by one and unknown hosts by image,
+// unknown images are the ones to add to the matrix
+func printHosts(out io.Writer, a *report.Account) {
+	unknown := make(map[string]int)
+	for _, region := range a.Regions {
+		for _, h := range region.Hosts {
+			switch h.Support {
+			case report.SupportUnsupported:
+				fmt.Fprintf(out, "Unsupported: %s %s %s (%s)\n", region.Name, h.ID, h.OS, h.Image)
+			case report.SupportUnknown:
+				unknown[h.Image]++
+			}
+		}
+	}
+
+	var images []string
+	for image := range unknown {
+		images = append(images, image)
+	}
+	sort.Strings(images)
+	for _, image := range images {
+		name := image
+		if name == "" {
+			name = "no image name"
+		}
+		fmt.Fprintf(out, "Unknown: %s, %d hosts\n", name, unknown[image])
+	}
+}
+
+func ParseReport(cmd *cobra.Command) string {
+	return helpers.GetFlagEnvironmentString(cmd, "report", "report", "Missing --report, the JSON report of an inventory run with --hosts", true)
+}
+
+func ParseMatrix(cmd *cobra.Command) string {
+	return helpers.GetFlagEnvironmentString(cmd, "matrix", "matrix", "", false)
+}
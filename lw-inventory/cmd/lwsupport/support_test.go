@@ -0,0 +1,128 @@
+package lwsupport
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lacework-dev/scripts/lw-inventory/report"
+)
+
+func TestClassify(t *testing.T) {
+	m, err := LoadMatrix("")
+	if err != nil {
+		t.Fatalf("LoadMatrix() error = %v", err)
+	}
+
+	tests := []struct {
+		cloud   string
+		image   string
+		os      string
+		support string
+	}{
+		{"aws", "amzn2-ami-kernel-5.10-hvm-2.0.20230628.0-x86_64-gp2", "Amazon Linux 2", report.SupportSupported},
+		{"aws", "amazon-eks-node-al2023-x86_64-standard-1.29-v20240213", "Amazon Linux 2023", report.SupportSupported},
+		{"aws", "ubuntu/images/hvm-ssd/ubuntu-jammy-22.04-amd64-server-20230516", "Ubuntu 22.04", report.SupportSupported},
+		{"aws", "ubuntu/images/hvm-ssd/ubuntu-trusty-14.04-amd64-server-20190514", "Ubuntu 14.04 and older", report.SupportUnsupported},
+		{"aws", "rhel-6.10_hvm-20180622-x86_64", "Red Hat Enterprise Linux 6 and older", report.SupportUnsupported},
+		{"aws", "Windows_Server-2022-English-Full-Base-2023.07.12", "Windows Server 2016, 2019 and 2022", report.SupportSupported},
+		{"aws", "golden-image-2023-07", "", report.SupportUnknown},
+		{"aws", "", "", report.SupportUnknown},
+		{"gcp", "ubuntu-pro-2204-lts", "Ubuntu 22.04", report.SupportSupported},
+		{"gcp", "cos-pcid,cos", "Container-Optimized OS", report.SupportSupported},
+		{"gcp", "centos-6", "CentOS 6 and older", report.SupportUnsupported},
+		{"azure", "Canonical:0001-com-ubuntu-server-jammy:22_04-lts-gen2:22.04.202307010", "Ubuntu 22.04", report.SupportSupported},
+		{"azure", "MicrosoftWindowsServer:WindowsServer:2008-R2-SP1:2.127.20180315", "Windows Server 2012 and older", report.SupportUnsupported},
+		{"azure", "RedHat:RHEL:8-lvm-gen2:8.8.2023060613", "Red Hat Enterprise Linux 8", report.SupportSupported},
+		//patterns of one cloud don't apply to another
+		{"azure", "amzn2-ami-kernel-5.10-hvm-2.0.20230628.0-x86_64-gp2", "", report.SupportUnknown},
+	}
+	for _, tt := range tests {
+		name, support := m.Classify(tt.cloud, tt.image)
+		if name != tt.os || support != tt.support {
+			t.Errorf("Classify(%s, %q) = %q, %s, want %q, %s", tt.cloud, tt.image, name, support, tt.os, tt.support)
+		}
+	}
+}
+
+func TestEmbeddedMatrix(t *testing.T) {
+	m, err := parseMatrix(matrixJSON)
+	if err != nil {
+		t.Fatalf("parseMatrix() of the embedded matrix error = %v", err)
+	}
+	if m.Version == "" || m.Source == "" {
+		t.Errorf("embedded matrix version %q, source %q, want both stamped", m.Version, m.Source)
+	}
+
+	names := make(map[string]bool)
+	for _, o := range m.OperatingSystems {
+		if names[o.Name] {
+			t.Errorf("%s is listed twice", o.Name)
+		}
+		names[o.Name] = true
+
+		n := 0
+		for cloud, patterns := range map[string][]string{"aws": o.AWS, "gcp": o.GCP, "azure": o.Azure} {
+			if len(o.patterns[cloud]) != len(patterns) {
+				t.Errorf("%s: compiled %d of %d %s patterns", o.Name, len(o.patterns[cloud]), len(patterns), cloud)
+			}
+			n += len(patterns)
+		}
+		if n == 0 {
+			t.Errorf("%s has no image patterns", o.Name)
+		}
+	}
+}
+
+func TestLoadMatrixFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "matrix.json")
+	if err := os.WriteFile(path, []byte(`{"version": "test", "operating_systems": [{"name": "Golden", "support": "supported", "aws": ["golden-*"]}]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	m, err := LoadMatrix(path)
+	if err != nil {
+		t.Fatalf("LoadMatrix() error = %v", err)
+	}
+	if name, support := m.Classify("aws", "golden-image-2023-07"); name != "Golden" || support != report.SupportSupported {
+		t.Errorf("Classify() = %s, %s, want Golden, supported", name, support)
+	}
+
+	if _, err := parseMatrix([]byte(`{"operating_systems": [{"name": "Golden", "support": "maybe"}]}`)); err == nil {
+		t.Error("parseMatrix() accepted an invalid support value")
+	}
+	if _, err := LoadMatrix(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("LoadMatrix() of a missing file returned no error")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	m, err := LoadMatrix("")
+	if err != nil {
+		t.Fatalf("LoadMatrix() error = %v", err)
+	}
+	r := report.New("aws")
+	region := r.Account("123", "prod").Region("us-east-1")
+	region.Hosts = []report.Host{
+		{ID: "i-1", Image: "amzn2-ami-hvm-2.0.20230628.0-x86_64-gp2"},
+		{ID: "i-2", Image: "CentOS Linux 6 x86_64 HVM EBS 1901_01"},
+		{ID: "i-3", Image: "golden-image"},
+		{ID: "i-4"},
+	}
+	r.Account("456", "dev").Region("eu-west-1")
+	r.Finish()
+
+	m.Check(r)
+	if region.Hosts[1].OS != "CentOS 6 and older" || region.Hosts[1].Support != report.SupportUnsupported {
+		t.Errorf("host = %+v, want unsupported CentOS 6", region.Hosts[1])
+	}
+	want := report.SupportCounts{Supported: 1, Unsupported: 1, Unknown: 2}
+	if got := r.Accounts[0].Totals.AgentSupport; got == nil || *got != want {
+		t.Errorf("account support = %+v, want %+v", got, want)
+	}
+	if got := r.Totals.AgentSupport; got == nil || *got != want {
+		t.Errorf("total support = %+v, want %+v", got, want)
+	}
+	if r.Accounts[1].Totals.AgentSupport != nil {
+		t.Errorf("account without hosts has support counts %+v", r.Accounts[1].Totals.AgentSupport)
+	}
+}
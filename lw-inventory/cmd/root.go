@@ -1,12 +1,18 @@
 package cmd
 
 import (
+	"os"
+
 	"github.com/lacework-dev/scripts/lw-inventory/helpers"
 	"github.com/spf13/cobra"
 )
 
 var cfgFile string
 
+// exitCode is set by the command that ran instead of exiting, so its
+// deferred calls run first
+var exitCode int
+
 var rootCmd = &cobra.Command{
 	Use:   "lw-inventory",
 	Short: "",
@@ -17,4 +23,5 @@ func Execute() {
 	if err := rootCmd.Execute(); err != nil {
 		helpers.Bail("error starting app", err)
 	}
+	os.Exit(exitCode)
 }
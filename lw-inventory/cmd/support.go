@@ -0,0 +1,28 @@
+package cmd
+
+import (
+	"github.com/lacework-dev/scripts/lw-inventory/cmd/lwsupport"
+	"github.com/lacework-dev/scripts/lw-inventory/helpers"
+	"github.com/spf13/cobra"
+)
+
+var supportCmd = &cobra.Command{
+	Use:   "agent-support",
+	Short: "Check inventoried hosts against the agent supported OS matrix",
+	Long:  `Check the hosts of a JSON inventory report, taken with --hosts, against the agent supported OS matrix`,
+	Run: func(cmd *cobra.Command, args []string) {
+		reportPath := lwsupport.ParseReport(cmd)
+		matrixPath := lwsupport.ParseMatrix(cmd)
+		output := helpers.ParseOutput(cmd)
+		inventory := lwsupport.Run(reportPath, matrixPath, output.Progress())
+		helpers.WriteReport(output, inventory)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(supportCmd)
+	supportCmd.Flags().String("report", "", "JSON report of an inventory run with --hosts, - reads stdin")
+	supportCmd.Flags().String("matrix", "", "Supported OS matrix file to use instead of the built in one")
+	supportCmd.Flags().String("output", "table", "Report format: table, json or csv")
+	supportCmd.Flags().String("output-file", "", "Write the report to a file instead of stdout")
+}
@@ -0,0 +1,43 @@
+package helpers
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/lacework-dev/scripts/lw-inventory/report"
+	"github.com/spf13/cobra"
+)
+
+// ParseImageLookback returns the number of days back images count from
+func ParseImageLookback(cmd *cobra.Command) int {
+	days, _ := cmd.Flags().GetInt("image-lookback-days")
+	if days <= 0 {
+		Bail(fmt.Sprintf("invalid --image-lookback-days %d, must be at least 1", days), nil)
+	}
+	return days
+}
+
+// ImageCutoff is the oldest push time of the images to count
+func ImageCutoff(days int) time.Time {
+	return time.Now().AddDate(0, 0, -days)
+}
+
+// PrintRepositories prints the images and tags pushed to every repository
+// within the lookback window, and their totals
+func PrintRepositories(out io.Writer, repositories []report.Repository, days int) {
+	sorted := append([]report.Repository{}, repositories...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Registry+"/"+sorted[i].Name < sorted[j].Registry+"/"+sorted[j].Name
+	})
+
+	images, tags := 0, 0
+	fmt.Fprintf(out, "\nContainer Images pushed in the last %d days\n", days)
+	for _, r := range sorted {
+		fmt.Fprintf(out, "%s/%s: %d images, %d tags\n", r.Registry, r.Name, r.Images, r.Tags)
+		images += r.Images
+		tags += r.Tags
+	}
+	fmt.Fprintf(out, "Repositories: %d, Images: %d, Tags: %d\n", len(sorted), images, tags)
+}
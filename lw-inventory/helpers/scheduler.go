@@ -0,0 +1,75 @@
+package helpers
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Scheduler bounds how many scan tasks run at the same time across everything
+// sharing it
+type Scheduler struct {
+	slots chan struct{}
+}
+
+func NewScheduler(concurrency int) *Scheduler {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &Scheduler{slots: make(chan struct{}, concurrency)}
+}
+
+// Collect runs every task on the scheduler and returns the results of the ones
+// that ran. Tasks still waiting for a slot when ctx is cancelled are skipped
+func Collect[T any](ctx context.Context, s *Scheduler, tasks []func(context.Context) T) []T {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var results []T
+
+	for _, task := range tasks {
+		wg.Add(1)
+		go func(task func(context.Context) T) {
+			defer wg.Done()
+			select {
+			case s.slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			defer func() { <-s.slots }()
+			if ctx.Err() != nil {
+				return
+			}
+
+			result := task(ctx)
+			mu.Lock()
+			results = append(results, result)
+			mu.Unlock()
+		}(task)
+	}
+
+	wg.Wait()
+	return results
+}
+
+// ScanContext is cancelled on Ctrl-C or SIGTERM, or once timeout has elapsed
+// when it is greater than zero. After the first signal the default handling
+// is restored, so a second Ctrl-C exits right away
+func ScanContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	if timeout <= 0 {
+		return ctx, stop
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return ctx, func() {
+		cancel()
+		stop()
+	}
+}
@@ -0,0 +1,56 @@
+package helpers
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCollectBoundsConcurrency(t *testing.T) {
+	scheduler := NewScheduler(3)
+	var running, peak int32
+
+	var tasks []func(context.Context) int
+	for i := 0; i < 20; i++ {
+		i := i
+		tasks = append(tasks, func(ctx context.Context) int {
+			n := atomic.AddInt32(&running, 1)
+			for {
+				p := atomic.LoadInt32(&peak)
+				if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+			return i
+		})
+	}
+
+	results := Collect(context.Background(), scheduler, tasks)
+	if len(results) != 20 {
+		t.Errorf("got %d results, want 20", len(results))
+	}
+	if peak > 3 {
+		t.Errorf("peak concurrency %d, want at most 3", peak)
+	}
+}
+
+func TestCollectSkipsTasksAfterCancel(t *testing.T) {
+	scheduler := NewScheduler(1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var tasks []func(context.Context) int
+	for i := 0; i < 10; i++ {
+		tasks = append(tasks, func(ctx context.Context) int {
+			cancel()
+			return 1
+		})
+	}
+
+	if results := Collect(ctx, scheduler, tasks); len(results) != 1 {
+		t.Errorf("got %d results after cancel, want 1", len(results))
+	}
+}
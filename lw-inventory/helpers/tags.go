@@ -0,0 +1,123 @@
+package helpers
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/lacework-dev/scripts/lw-inventory/report"
+	"github.com/spf13/cobra"
+)
+
+// Tag matches a tag or label key, and its value unless Value is empty
+type Tag struct {
+	Key   string
+	Value string
+}
+
+func (t Tag) String() string {
+	if t.Value == "" {
+		return t.Key
+	}
+	return t.Key + "=" + t.Value
+}
+
+// TagFilter keeps resources by their tags or labels. A resource is kept when
+// it has any of the Include tags, or Include is empty, and none of the
+// Exclude tags
+type TagFilter struct {
+	Include []Tag
+	Exclude []Tag
+}
+
+func ParseTagFilter(cmd *cobra.Command) TagFilter {
+	return TagFilter{
+		Include: parseTags(GetFlagEnvironmentString(cmd, "include-tag", "include-tag", "", false)),
+		Exclude: parseTags(GetFlagEnvironmentString(cmd, "exclude-tag", "exclude-tag", "", false)),
+	}
+}
+
+// parseTags splits a comma separated list of key=value or bare key entries
+func parseTags(flag string) []Tag {
+	var tags []Tag
+	for _, t := range strings.Split(flag, ",") {
+		t = strings.TrimSpace(t)
+		if t == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(t, "=")
+		tags = append(tags, Tag{Key: strings.TrimSpace(key), Value: strings.TrimSpace(value)})
+	}
+	return tags
+}
+
+func (f TagFilter) Enabled() bool {
+	return len(f.Include) > 0 || len(f.Exclude) > 0
+}
+
+func (f TagFilter) Match(tags map[string]string) bool {
+	for _, t := range f.Exclude {
+		if t.matches(tags) {
+			return false
+		}
+	}
+	if len(f.Include) == 0 {
+		return true
+	}
+	for _, t := range f.Include {
+		if t.matches(tags) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t Tag) matches(tags map[string]string) bool {
+	value, ok := tags[t.Key]
+	return ok && (t.Value == "" || value == t.Value)
+}
+
+// SetOptions records the filter in the report metadata options
+func (f TagFilter) SetOptions(options map[string]string) {
+	if len(f.Include) > 0 {
+		options["include-tag"] = joinTags(f.Include)
+	}
+	if len(f.Exclude) > 0 {
+		options["exclude-tag"] = joinTags(f.Exclude)
+	}
+}
+
+// ParseGroupByTag returns the tag key to break the inventory down by, empty
+// when it isn't broken down
+func ParseGroupByTag(cmd *cobra.Command) string {
+	return strings.TrimSpace(GetFlagEnvironmentString(cmd, "group-by-tag", "group-by-tag", "", false))
+}
+
+// GroupValue returns the value of the group-by key in tags, report.Untagged
+// when the key is missing or empty
+func GroupValue(tags map[string]string, key string) string {
+	if value := tags[key]; value != "" {
+		return value
+	}
+	return report.Untagged
+}
+
+// PrintTagGroups prints the counts of every value of the group-by key
+func PrintTagGroups(out io.Writer, key string, groups []report.TagGroup) {
+	sorted := append([]report.TagGroup{}, groups...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Value < sorted[j].Value })
+
+	fmt.Fprintf(out, "\nCounts by %s\n", key)
+	for _, g := range sorted {
+		fmt.Fprintf(out, "%s: Standard Agent VMs %d (%d vCPUs), Enterprise Agent VMs %d (%d vCPUs), Agentless Resources %d\n", g.Value, g.StandardVMs, g.StandardVCPUs, g.EnterpriseVMs, g.EnterpriseVCPUs, g.AgentlessResources)
+	}
+}
+
+func joinTags(tags []Tag) string {
+	var s []string
+	for _, t := range tags {
+		s = append(s, t.String())
+	}
+	return strings.Join(s, ",")
+}
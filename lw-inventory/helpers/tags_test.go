@@ -0,0 +1,48 @@
+package helpers
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTags(t *testing.T) {
+	got := parseTags(" env=prod, team ,cost-center=a=b,")
+	want := []Tag{{Key: "env", Value: "prod"}, {Key: "team"}, {Key: "cost-center", Value: "a=b"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseTags() = %+v, want %+v", got, want)
+	}
+}
+
+func TestTagFilterMatch(t *testing.T) {
+	filter := TagFilter{
+		Include: []Tag{{Key: "env", Value: "prod"}, {Key: "env", Value: "production"}},
+		Exclude: []Tag{{Key: "lacework", Value: "ignore"}, {Key: "scratch"}},
+	}
+	tests := []struct {
+		tags map[string]string
+		want bool
+	}{
+		{map[string]string{"env": "prod"}, true},
+		{map[string]string{"env": "production", "team": "a"}, true},
+		{map[string]string{"env": "dev"}, false},
+		{map[string]string{"env": "prod", "scratch": ""}, false},
+		{map[string]string{"env": "prod", "lacework": "ignore"}, false},
+		{map[string]string{"env": "prod", "lacework": "keep"}, true},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		if got := filter.Match(tt.tags); got != tt.want {
+			t.Errorf("Match(%v) = %v, want %v", tt.tags, got, tt.want)
+		}
+	}
+
+	if !(TagFilter{Exclude: []Tag{{Key: "scratch"}}}).Match(nil) {
+		t.Error("an exclude only filter dropped an untagged resource")
+	}
+
+	options := map[string]string{}
+	filter.SetOptions(options)
+	if options["include-tag"] != "env=prod,env=production" || options["exclude-tag"] != "lacework=ignore,scratch" {
+		t.Errorf("options = %v", options)
+	}
+}
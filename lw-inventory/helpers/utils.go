@@ -2,12 +2,18 @@ package helpers
 
 import (
 	"fmt"
+	"io"
 	"os"
+	"time"
 
+	"github.com/lacework-dev/scripts/lw-inventory/report"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
+// exit code for a scan that finished with failures, Bail exits with 1
+const EXIT_INCOMPLETE = 2
+
 func GetFlagEnvironmentString(cmd *cobra.Command, flag string, env string, message string, required bool) string {
 	value := cmd.Flag(flag).Value.String()
 	if value == "" {
@@ -27,6 +33,84 @@ func ParseDebug(cmd *cobra.Command) bool {
 	return GetFlagEnvironmentBool(cmd, "debug", "debug", false)
 }
 
+// ParseHosts reports whether every agent VM and its image goes in the report
+func ParseHosts(cmd *cobra.Command) bool {
+	return GetFlagEnvironmentBool(cmd, "hosts", "hosts", false)
+}
+
+// ParseStates returns the VM lifecycle states to inventory, running by
+// default
+func ParseStates(cmd *cobra.Command) string {
+	states := GetFlagEnvironmentString(cmd, "states", "states", "", false)
+	switch states {
+	case report.StateRunning, report.StateStopped, report.StatesAll:
+	default:
+		Bail(fmt.Sprintf("invalid --states %q, valid states: %s, %s, %s", states, report.StateRunning, report.StateStopped, report.StatesAll), nil)
+	}
+	return states
+}
+
+// IncludeState reports whether a VM in state is inventoried under the
+// states option
+func IncludeState(states string, state string) bool {
+	return states == report.StatesAll || states == state
+}
+
+// PrintStates prints the number of running and stopped VMs
+func PrintStates(out io.Writer, states report.StateCounts) {
+	fmt.Fprintln(out, "\nVM States")
+	fmt.Fprintf(out, "Running VMs: %d\n", states.Running)
+	fmt.Fprintf(out, "Stopped VMs: %d\n", states.Stopped)
+}
+
+func ParseOutput(cmd *cobra.Command) *report.Output {
+	format := GetFlagEnvironmentString(cmd, "output", "output", "", false)
+	file := GetFlagEnvironmentString(cmd, "output-file", "output-file", "", false)
+	output, err := report.NewOutput(format, file)
+	if err != nil {
+		Bail("invalid output option", err)
+	}
+	return output
+}
+
+func WriteReport(output *report.Output, inventory *report.Report) {
+	if err := output.Write(inventory); err != nil {
+		Bail("error writing report", err)
+	}
+}
+
+// PrintFailures lists the scan units that failed, so a summary is never read
+// as complete when it isn't
+func PrintFailures(out io.Writer, inventory *report.Report) {
+	if len(inventory.Failures) == 0 {
+		return
+	}
+	fmt.Fprintf(out, "\nWARNING: totals are incomplete, %d scan(s) failed\n", len(inventory.Failures))
+	for _, f := range inventory.Failures {
+		fmt.Fprintf(out, "%s %s %s: %s\n", f.Account, f.Region, f.Service, f.Error)
+	}
+	fmt.Fprintln(out, "----------------------------------------------")
+}
+
+// ExitCode is EXIT_INCOMPLETE when any part of the scan failed, the command
+// exits with it once its deferred calls have run
+func ExitCode(inventory *report.Report) int {
+	if inventory.Incomplete {
+		return EXIT_INCOMPLETE
+	}
+	return 0
+}
+
+func ParseConcurrency(cmd *cobra.Command) int {
+	value, _ := cmd.Flags().GetInt("concurrency")
+	return value
+}
+
+func ParseTimeout(cmd *cobra.Command) time.Duration {
+	value, _ := cmd.Flags().GetDuration("timeout")
+	return value
+}
+
 func GetFlagEnvironmentBool(cmd *cobra.Command, flag string, env string, required bool) bool {
 	value, _ := cmd.Flags().GetBool(flag)
 	return value
@@ -34,9 +118,9 @@ func GetFlagEnvironmentBool(cmd *cobra.Command, flag string, env string, require
 
 func Bail(message string, err error) {
 	if err == nil {
-		fmt.Println(message)
+		fmt.Fprintln(os.Stderr, message)
 	} else {
-		fmt.Println(message, err)
+		fmt.Fprintln(os.Stderr, message, err)
 	}
 	os.Exit(1)
 }
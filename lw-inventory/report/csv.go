@@ -0,0 +1,231 @@
+package report
+
+import (
+	"encoding/csv"
+	"io"
+	"sort"
+	"strconv"
+)
+
+const (
+	CategoryAgentOS = "agent_os"
+	CategoryVCPU    = "agent_vcpu"
+	CategorySupport = "agent_support"
+	CategoryState   = "vm_state"
+	CategoryTag     = "tag_group"
+	CategoryVolume  = "volume"
+	CategoryImage   = "container_image"
+	CategoryTotal   = "total"
+	CategoryFailed  = "failed"
+	TotalAccount    = "TOTAL"
+	TotalRegion     = "ALL"
+)
+
+var csvHeader = []string{"cloud", "account_id", "account_name", "region", "service", "category", "count"}
+
+// writeCSV emits one row per account, region and service followed by total
+// rows, with the same columns for every cloud
+func writeCSV(w io.Writer, r *Report) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(csvHeader); err != nil {
+		return err
+	}
+
+	row := func(accountId string, accountName string, region string, service string, category string, count int) []string {
+		return []string{r.Cloud, accountId, accountName, region, service, category, strconv.Itoa(count)}
+	}
+
+	for _, a := range r.Accounts {
+		for _, region := range a.Regions {
+			for _, s := range region.Services {
+				if err := writer.Write(row(a.ID, a.Name, region.Name, s.Service, s.Category, s.Count)); err != nil {
+					return err
+				}
+			}
+			for _, c := range append(agentRows(region.Agents, "", CategoryAgentOS), agentRows(region.VCPUs, " vCPUs", CategoryVCPU)...) {
+				if err := writer.Write(row(a.ID, a.Name, region.Name, c.Service, c.Category, c.Count)); err != nil {
+					return err
+				}
+			}
+			regionRows := append(stateRows(region.States), tagGroupRows(r.Metadata.Options["group-by-tag"], region.TagGroups)...)
+			regionRows = append(regionRows, volumeRows(region.Volumes)...)
+			regionRows = append(regionRows, repositoryRows(region.Repositories)...)
+			for _, c := range append(regionRows, supportRows(region.totals().AgentSupport)...) {
+				if err := writer.Write(row(a.ID, a.Name, region.Name, c.Service, c.Category, c.Count)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	//failed scan units are flagged so spreadsheet totals aren't taken as complete
+	for _, f := range r.Failures {
+		if err := writer.Write(row(f.Account, "", f.Region, f.Service, CategoryFailed, 1)); err != nil {
+			return err
+		}
+	}
+
+	var services []string
+	for s := range r.Totals.Services {
+		services = append(services, s)
+	}
+	sort.Strings(services)
+	for _, s := range services {
+		if err := writer.Write(row(TotalAccount, "", TotalRegion, s, categoryOf(r, s), r.Totals.Services[s])); err != nil {
+			return err
+		}
+	}
+	totalRows := append(agentRows(r.Totals.Agents, "", CategoryAgentOS), agentRows(r.Totals.VCPUs, " vCPUs", CategoryVCPU)...)
+	totalRows = append(totalRows, stateRows(r.Totals.States)...)
+	totalRows = append(totalRows, tagGroupRows(r.Metadata.Options["group-by-tag"], r.Totals.TagGroups)...)
+	totalRows = append(totalRows, volumeRows(r.Totals.Volumes)...)
+	if r.Totals.Repositories > 0 {
+		totalRows = append(totalRows,
+			ServiceCount{Service: "Repositories", Category: CategoryImage, Count: r.Totals.Repositories},
+			ServiceCount{Service: "Images", Category: CategoryImage, Count: r.Totals.Images},
+			ServiceCount{Service: "Image Tags", Category: CategoryImage, Count: r.Totals.ImageTags},
+		)
+	}
+	for _, c := range append(totalRows, supportRows(r.Totals.AgentSupport)...) {
+		if err := writer.Write(row(TotalAccount, "", TotalRegion, c.Service, c.Category, c.Count)); err != nil {
+			return err
+		}
+	}
+	if err := writer.Write(row(TotalAccount, "", TotalRegion, "Resources", CategoryTotal, r.Totals.Resources)); err != nil {
+		return err
+	}
+	if err := writer.Write(row(TotalAccount, "", TotalRegion, "Accounts", CategoryTotal, r.Totals.Accounts)); err != nil {
+		return err
+	}
+	if r.Incomplete {
+		if err := writer.Write(row(TotalAccount, "", TotalRegion, "Failures", CategoryFailed, len(r.Failures))); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// agentRows turns agent counts, either VMs or vCPUs, into rows named after the
+// agent type and OS with suffix appended
+func agentRows(agents AgentCounts, suffix string, category string) []ServiceCount {
+	counts := []ServiceCount{
+		{Service: "Standard Agent Linux" + suffix, Category: category, Count: agents.Standard.Linux},
+		{Service: "Standard Agent Windows" + suffix, Category: category, Count: agents.Standard.Windows},
+		{Service: "Standard Agent Other" + suffix, Category: category, Count: agents.Standard.Other},
+		{Service: "Enterprise Agent Linux" + suffix, Category: category, Count: agents.Enterprise.Linux},
+		{Service: "Enterprise Agent Windows" + suffix, Category: category, Count: agents.Enterprise.Windows},
+		{Service: "Enterprise Agent Other" + suffix, Category: category, Count: agents.Enterprise.Other},
+	}
+
+	var rows []ServiceCount
+	for _, c := range counts {
+		if c.Count > 0 {
+			rows = append(rows, c)
+		}
+	}
+	return rows
+}
+
+// stateRows turns the VM lifecycle states into rows, leaving out states
+// without VMs
+func stateRows(states StateCounts) []ServiceCount {
+	var rows []ServiceCount
+	if states.Running > 0 {
+		rows = append(rows, ServiceCount{Service: "Running VMs", Category: CategoryState, Count: states.Running})
+	}
+	if states.Stopped > 0 {
+		rows = append(rows, ServiceCount{Service: "Stopped VMs", Category: CategoryState, Count: states.Stopped})
+	}
+	return rows
+}
+
+// tagGroupRows turns tag groups into rows named after the key and value of
+// the group, leaving out zero counts
+func tagGroupRows(key string, groups []TagGroup) []ServiceCount {
+	var rows []ServiceCount
+	for _, g := range groups {
+		prefix := key + "=" + g.Value + " "
+		for _, c := range []ServiceCount{
+			{Service: prefix + "Standard Agent VMs", Count: g.StandardVMs},
+			{Service: prefix + "Enterprise Agent VMs", Count: g.EnterpriseVMs},
+			{Service: prefix + "Standard Agent vCPUs", Count: g.StandardVCPUs},
+			{Service: prefix + "Enterprise Agent vCPUs", Count: g.EnterpriseVCPUs},
+			{Service: prefix + "Agentless Resources", Count: g.AgentlessResources},
+		} {
+			if c.Count > 0 {
+				c.Category = CategoryTag
+				rows = append(rows, c)
+			}
+		}
+	}
+	return rows
+}
+
+// volumeRows turns the volumes and snapshots of a region or the totals into
+// rows, KMS Keys is the number of distinct keys. None when volumes weren't
+// scanned
+func volumeRows(volumes *Volumes) []ServiceCount {
+	if volumes == nil {
+		return nil
+	}
+	rows := []ServiceCount{
+		{Service: "Attached Volumes", Category: CategoryVolume, Count: volumes.Count},
+		{Service: "Attached Volume GiB", Category: CategoryVolume, Count: volumes.SizeGiB},
+		{Service: "Encrypted Volumes", Category: CategoryVolume, Count: volumes.Encrypted},
+		{Service: "KMS Keys", Category: CategoryVolume, Count: len(volumes.KMSKeys)},
+	}
+	var types []string
+	for t := range volumes.Types {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	for _, t := range types {
+		rows = append(rows, ServiceCount{Service: t + " Volumes", Category: CategoryVolume, Count: volumes.Types[t]})
+	}
+	return append(rows,
+		ServiceCount{Service: "Snapshots", Category: CategoryVolume, Count: volumes.Snapshots},
+		ServiceCount{Service: "Snapshot GiB", Category: CategoryVolume, Count: volumes.SnapshotGiB},
+	)
+}
+
+// repositoryRows turns repositories into image and tag rows named after the
+// registry and repository
+func repositoryRows(repositories []Repository) []ServiceCount {
+	var rows []ServiceCount
+	for _, repo := range repositories {
+		prefix := repo.Registry + "/" + repo.Name + " "
+		rows = append(rows,
+			ServiceCount{Service: prefix + "Images", Category: CategoryImage, Count: repo.Images},
+			ServiceCount{Service: prefix + "Image Tags", Category: CategoryImage, Count: repo.Tags},
+		)
+	}
+	return rows
+}
+
+// supportRows turns the agent support check of hosts into rows, none when
+// the report wasn't checked
+func supportRows(support *SupportCounts) []ServiceCount {
+	if support == nil {
+		return nil
+	}
+	return []ServiceCount{
+		{Service: "Agent Supported Hosts", Category: CategorySupport, Count: support.Supported},
+		{Service: "Agent Unsupported Hosts", Category: CategorySupport, Count: support.Unsupported},
+		{Service: "Agent Unknown Hosts", Category: CategorySupport, Count: support.Unknown},
+	}
+}
+
+func categoryOf(r *Report, service string) string {
+	for _, a := range r.Accounts {
+		for _, region := range a.Regions {
+			for _, s := range region.Services {
+				if s.Service == service {
+					return s.Category
+				}
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,76 @@
+package report
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteCSV(t *testing.T) {
+	r := testReport()
+	r.Total()
+
+	var buf bytes.Buffer
+	if err := writeCSV(&buf, r); err != nil {
+		t.Fatalf("writeCSV() error = %v", err)
+	}
+	want := `cloud,account_id,account_name,region,service,category,count
+aws,111111111111,prod,eu-west-1,EC2,agent,1
+aws,111111111111,prod,eu-west-1,Standard Agent Linux,agent_os,1
+aws,111111111111,prod,eu-west-1,Standard Agent Linux vCPUs,agent_vcpu,2
+aws,111111111111,prod,eu-west-1,Stopped VMs,vm_state,1
+aws,111111111111,prod,us-east-1,EC2,agent,3
+aws,111111111111,prod,us-east-1,RDS,agentless,2
+aws,111111111111,prod,us-east-1,Standard Agent Linux,agent_os,2
+aws,111111111111,prod,us-east-1,Enterprise Agent Windows,agent_os,1
+aws,111111111111,prod,us-east-1,Standard Agent Linux vCPUs,agent_vcpu,4
+aws,111111111111,prod,us-east-1,Enterprise Agent Windows vCPUs,agent_vcpu,8
+aws,111111111111,prod,us-east-1,Running VMs,vm_state,3
+aws,222222222222,dev,us-east-1,ECS Fargate Running Tasks,container,4
+aws,222222222222,dev,us-east-1,222222222222/web Images,container_image,3
+aws,222222222222,dev,us-east-1,222222222222/web Image Tags,container_image,5
+aws,222222222222,,eu-west-1,Lambda,failed,1
+aws,TOTAL,,ALL,EC2,agent,4
+aws,TOTAL,,ALL,ECS Fargate Running Tasks,container,4
+aws,TOTAL,,ALL,RDS,agentless,2
+aws,TOTAL,,ALL,Standard Agent Linux,agent_os,3
+aws,TOTAL,,ALL,Enterprise Agent Windows,agent_os,1
+aws,TOTAL,,ALL,Standard Agent Linux vCPUs,agent_vcpu,6
+aws,TOTAL,,ALL,Enterprise Agent Windows vCPUs,agent_vcpu,8
+aws,TOTAL,,ALL,Running VMs,vm_state,3
+aws,TOTAL,,ALL,Stopped VMs,vm_state,1
+aws,TOTAL,,ALL,Repositories,container_image,1
+aws,TOTAL,,ALL,Images,container_image,3
+aws,TOTAL,,ALL,Image Tags,container_image,5
+aws,TOTAL,,ALL,Resources,total,6
+aws,TOTAL,,ALL,Accounts,total,2
+aws,TOTAL,,ALL,Failures,failed,1
+`
+	if got := buf.String(); got != want {
+		t.Errorf("writeCSV() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestWriteCSVHeader(t *testing.T) {
+	for _, cloud := range []string{"aws", "gcp", "azure"} {
+		r := New(cloud)
+		r.Account("project", "").Region("us-central1").AddService("SQL Instance", CategoryAgentless, 1)
+		r.Total()
+
+		var buf bytes.Buffer
+		if err := writeCSV(&buf, r); err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(buf.String(), "\n")
+		if lines[0] != "cloud,account_id,account_name,region,service,category,count" {
+			t.Errorf("%s header = %q", cloud, lines[0])
+		}
+		if lines[1] != cloud+",project,,us-central1,SQL Instance,agentless,1" {
+			t.Errorf("%s first row = %q", cloud, lines[1])
+		}
+		//complete reports have no failure rows
+		if strings.Contains(buf.String(), CategoryFailed) {
+			t.Errorf("%s report without failures has failure rows", cloud)
+		}
+	}
+}
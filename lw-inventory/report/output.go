@@ -0,0 +1,91 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	FormatTable = "table"
+	FormatJSON  = "json"
+	FormatCSV   = "csv"
+)
+
+// Output writes a finished report in the requested format, either to a file
+// or to stdout
+type Output struct {
+	Format string
+	File   string
+}
+
+// NewOutput validates the format and the output file
+func NewOutput(format string, file string) (*Output, error) {
+	switch format {
+	case FormatTable:
+		if file != "" {
+			return nil, fmt.Errorf("--output-file requires --output %s or %s", FormatJSON, FormatCSV)
+		}
+	case FormatJSON, FormatCSV:
+	default:
+		return nil, fmt.Errorf("unknown output format %q, valid formats: %s, %s, %s", format, FormatTable, FormatJSON, FormatCSV)
+	}
+
+	return &Output{Format: format, File: file}, nil
+}
+
+// Progress is where the human readable progress and summary text goes. That
+// is stderr when a machine readable report is going to stdout, so stdout only
+// carries the report
+func (o *Output) Progress() io.Writer {
+	if o.Format != FormatTable && o.File == "" {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// Write writes the report, a file that can't be closed is an error as the
+// report may not have been written in full
+func (o *Output) Write(r *Report) (err error) {
+	if o.Format == FormatTable {
+		return nil
+	}
+
+	var w io.Writer = os.Stdout
+	if o.File != "" {
+		f, err := os.Create(o.File)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+		}()
+		w = f
+	}
+
+	if o.Format == FormatCSV {
+		return writeCSV(w, r)
+	}
+	return writeJSON(w, r)
+}
+
+// ReadJSON decodes a report written with --output json
+func ReadJSON(r io.Reader) (*Report, error) {
+	report := &Report{}
+	if err := json.NewDecoder(r).Decode(report); err != nil {
+		return nil, err
+	}
+	if report.Metadata.Options == nil {
+		report.Metadata.Options = map[string]string{}
+	}
+	return report, nil
+}
+
+func writeJSON(w io.Writer, r *Report) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
@@ -0,0 +1,69 @@
+package report
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOutputProgress(t *testing.T) {
+	tests := []struct {
+		format string
+		file   string
+		want   *os.File
+	}{
+		{FormatTable, "", os.Stdout},
+		{FormatJSON, "", os.Stderr},
+		{FormatCSV, "", os.Stderr},
+		{FormatJSON, "report.json", os.Stdout},
+	}
+	for _, tt := range tests {
+		o, err := NewOutput(tt.format, tt.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := o.Progress(); got != tt.want {
+			t.Errorf("Progress() of %s %q = %v, want %v", tt.format, tt.file, got, tt.want)
+		}
+	}
+}
+
+func TestNewOutputInvalid(t *testing.T) {
+	if _, err := NewOutput("xml", ""); err == nil {
+		t.Error("NewOutput accepted an unknown format")
+	}
+	if _, err := NewOutput(FormatTable, "report.txt"); err == nil {
+		t.Error("NewOutput accepted --output-file with the table format")
+	}
+}
+
+func TestOutputWriteFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "report.json")
+	o, err := NewOutput(FormatJSON, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := New("aws")
+	r.Account("123456789012", "prod").Region("us-east-1").AddService("EC2", CategoryAgent, 2)
+	r.Finish()
+	if err := o.Write(r); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got Report
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("report file isn't JSON: %v", err)
+	}
+	if got.Cloud != "aws" || got.Totals.Accounts != 1 {
+		t.Errorf("got cloud %q with %d accounts, want the aws report", got.Cloud, got.Totals.Accounts)
+	}
+
+	if err := (&Output{Format: FormatJSON, File: filepath.Join(t.TempDir(), "missing", "report.json")}).Write(r); err == nil {
+		t.Error("Write() to a missing directory succeeded")
+	}
+}
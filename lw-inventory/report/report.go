@@ -0,0 +1,515 @@
+package report
+
+import (
+	"sort"
+	"time"
+)
+
+const (
+	CategoryAgentless = "agentless"
+	CategoryAgent     = "agent"
+	CategoryContainer = "container"
+	//serverless functions are inventoried but not counted as resources
+	CategoryServerless = "serverless"
+
+	//lifecycle states of VMs, StatesAll selects both
+	StateRunning = "running"
+	StateStopped = "stopped"
+	StatesAll    = "all"
+
+	//tag group of resources without the --group-by-tag key
+	Untagged = "untagged"
+
+	SupportSupported   = "supported"
+	SupportUnsupported = "unsupported"
+	SupportUnknown     = "unknown"
+)
+
+// Report is the provider independent result of an inventory scan, one per
+// cloud, broken down by account (AWS account, GCP project or Azure
+// subscription) and region
+type Report struct {
+	Cloud      string     `json:"cloud"`
+	Metadata   Metadata   `json:"metadata"`
+	Accounts   []*Account `json:"accounts"`
+	Skipped    []Skipped  `json:"skipped,omitempty"`
+	Failures   []Failure  `json:"failures,omitempty"`
+	Incomplete bool       `json:"incomplete"`
+	Totals     Totals     `json:"totals"`
+}
+
+type Metadata struct {
+	Tool       string            `json:"tool"`
+	StartedAt  time.Time         `json:"started_at"`
+	FinishedAt time.Time         `json:"finished_at"`
+	Duration   string            `json:"duration"`
+	Options    map[string]string `json:"options,omitempty"`
+}
+
+type Account struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+	//Partition is the AWS partition of the account, aws, aws-us-gov or aws-cn
+	Partition string `json:"partition,omitempty"`
+	//RegionsScanned is the region set chosen for the account, when the
+	//provider scans by region
+	RegionsScanned []string  `json:"regions_scanned,omitempty"`
+	Regions        []*Region `json:"regions"`
+	Totals         Totals    `json:"totals"`
+}
+
+// Skipped is an account that was found but could not be inventoried
+type Skipped struct {
+	ID     string `json:"id"`
+	Name   string `json:"name,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// Failure is a scan unit that returned an error, whatever it would have
+// counted is missing from the totals
+type Failure struct {
+	Account string `json:"account"`
+	Region  string `json:"region,omitempty"`
+	Service string `json:"service,omitempty"`
+	Error   string `json:"error"`
+}
+
+type Region struct {
+	Name                 string              `json:"name"`
+	Services             []ServiceCount      `json:"services"`
+	Agents               AgentCounts         `json:"agents"`
+	VCPUs                AgentCounts         `json:"vcpus"`
+	States               StateCounts         `json:"vm_states"`
+	Functions            *Functions          `json:"functions,omitempty"`
+	Volumes              *Volumes            `json:"volumes,omitempty"`
+	Repositories         []Repository        `json:"repositories,omitempty"`
+	ServerlessContainers []ContainerSizing   `json:"serverless_containers,omitempty"`
+	KubernetesClusters   []KubernetesCluster `json:"kubernetes_clusters,omitempty"`
+	Platforms            []PlatformCount     `json:"platforms,omitempty"`
+	Hosts                []Host              `json:"hosts,omitempty"`
+	TagGroups            []TagGroup          `json:"tag_groups,omitempty"`
+}
+
+// TagGroup is what was inventoried for one value of the --group-by-tag key,
+// Value is Untagged for resources without the key
+type TagGroup struct {
+	Value              string `json:"value"`
+	StandardVMs        int    `json:"standard_vms"`
+	EnterpriseVMs      int    `json:"enterprise_vms"`
+	StandardVCPUs      int    `json:"standard_vcpus"`
+	EnterpriseVCPUs    int    `json:"enterprise_vcpus"`
+	AgentlessResources int    `json:"agentless_resources"`
+}
+
+// Host is an agent VM and the image it runs, only recorded when the scan is
+// asked for hosts. OS and Support are set by the agent support check
+type Host struct {
+	ID        string `json:"id"`
+	AgentType string `json:"agent_type"`
+	Family    string `json:"family"`
+	//Image is the AMI name, the GCP boot disk licenses or the Azure image
+	//publisher:offer:sku:version
+	Image   string `json:"image,omitempty"`
+	OS      string `json:"os,omitempty"`
+	Support string `json:"support,omitempty"`
+}
+
+// SupportCounts is the number of hosts whose OS the agent supports, doesn't
+// support, or that the support matrix has no entry for
+type SupportCounts struct {
+	Supported   int `json:"supported"`
+	Unsupported int `json:"unsupported"`
+	Unknown     int `json:"unknown"`
+}
+
+// KubernetesCluster is a managed Kubernetes cluster with its node groups.
+// SelfManagedNodes are VMs tagged for the cluster outside any node group
+type KubernetesCluster struct {
+	Name             string      `json:"name"`
+	Version          string      `json:"version"`
+	PlatformVersion  string      `json:"platform_version,omitempty"`
+	PublicEndpoint   bool        `json:"public_endpoint"`
+	PrivateEndpoint  bool        `json:"private_endpoint"`
+	NodeGroups       []NodeGroup `json:"node_groups"`
+	SelfManagedNodes int         `json:"self_managed_nodes"`
+}
+
+type NodeGroup struct {
+	Name          string   `json:"name"`
+	DesiredSize   int      `json:"desired_size"`
+	MinSize       int      `json:"min_size"`
+	MaxSize       int      `json:"max_size"`
+	AMIType       string   `json:"ami_type"`
+	CapacityType  string   `json:"capacity_type"`
+	InstanceTypes []string `json:"instance_types"`
+	Nodes         int      `json:"nodes"`
+}
+
+// ContainerSizing is the cpu and memory requested by the running serverless
+// container tasks of a cluster, such as ECS tasks on Fargate
+type ContainerSizing struct {
+	Cluster  string  `json:"cluster"`
+	Tasks    int     `json:"tasks"`
+	VCPUs    float64 `json:"vcpus"`
+	MemoryMB int     `json:"memory_mb"`
+}
+
+// Functions summarises the serverless functions of a region. VCPUs is an
+// estimate from configured memory, only set when it was asked for
+type Functions struct {
+	Count         int            `json:"count"`
+	MemoryMB      int            `json:"memory_mb"`
+	VCPUs         float64        `json:"vcpu_equivalent,omitempty"`
+	Runtimes      map[string]int `json:"runtimes"`
+	Architectures map[string]int `json:"architectures"`
+	PackageTypes  map[string]int `json:"package_types"`
+}
+
+// Repository is a container image repository of a registry. Images and Tags
+// count the images pushed within the lookback window and their tags
+type Repository struct {
+	Registry string `json:"registry"`
+	Name     string `json:"name"`
+	Images   int    `json:"images"`
+	Tags     int    `json:"tags"`
+}
+
+// Volumes summarises the block storage agentless workload scanning reads in a
+// region, the volumes attached to running VMs and the snapshots
+type Volumes struct {
+	Count     int `json:"count"`
+	SizeGiB   int `json:"size_gib"`
+	Encrypted int `json:"encrypted"`
+	//volumes per volume type and per KMS key of encrypted volumes, scanning
+	//volumes encrypted with a customer managed key needs a grant on the key
+	Types       map[string]int `json:"types"`
+	KMSKeys     map[string]int `json:"kms_keys"`
+	Snapshots   int            `json:"snapshots"`
+	SnapshotGiB int            `json:"snapshot_size_gib"`
+}
+
+func NewVolumes() *Volumes {
+	return &Volumes{Types: map[string]int{}, KMSKeys: map[string]int{}}
+}
+
+func (v *Volumes) Add(o *Volumes) {
+	v.Count += o.Count
+	v.SizeGiB += o.SizeGiB
+	v.Encrypted += o.Encrypted
+	for k, n := range o.Types {
+		v.Types[k] += n
+	}
+	for k, n := range o.KMSKeys {
+		v.KMSKeys[k] += n
+	}
+	v.Snapshots += o.Snapshots
+	v.SnapshotGiB += o.SnapshotGiB
+}
+
+func NewFunctions() *Functions {
+	return &Functions{Runtimes: map[string]int{}, Architectures: map[string]int{}, PackageTypes: map[string]int{}}
+}
+
+func (f *Functions) add(o *Functions) {
+	f.Count += o.Count
+	f.MemoryMB += o.MemoryMB
+	f.VCPUs += o.VCPUs
+	for k, v := range o.Runtimes {
+		f.Runtimes[k] += v
+	}
+	for k, v := range o.Architectures {
+		f.Architectures[k] += v
+	}
+	for k, v := range o.PackageTypes {
+		f.PackageTypes[k] += v
+	}
+}
+
+type ServiceCount struct {
+	Service  string `json:"service"`
+	Category string `json:"category"`
+	Count    int    `json:"count"`
+}
+
+type OSCounts struct {
+	Linux   int `json:"linux"`
+	Windows int `json:"windows"`
+	Other   int `json:"other"`
+}
+
+func (c OSCounts) Total() int {
+	return c.Linux + c.Windows + c.Other
+}
+
+// PlatformCount is the number of agent VMs, and their vCPUs, of one agent
+// type, OS family, distribution and architecture
+type PlatformCount struct {
+	AgentType    string `json:"agent_type"`
+	Family       string `json:"family"`
+	Distribution string `json:"distribution"`
+	Architecture string `json:"architecture"`
+	Count        int    `json:"count"`
+	VCPUs        int    `json:"vcpus"`
+}
+
+// StateCounts is the number of VMs inventoried in each lifecycle state,
+// starting VMs count as running and stopping or deallocated ones as stopped
+type StateCounts struct {
+	Running int `json:"running"`
+	Stopped int `json:"stopped"`
+}
+
+func (c *StateCounts) Add(state string) {
+	switch state {
+	case StateRunning:
+		c.Running++
+	case StateStopped:
+		c.Stopped++
+	}
+}
+
+type AgentCounts struct {
+	Standard   OSCounts `json:"standard"`
+	Enterprise OSCounts `json:"enterprise"`
+}
+
+type Totals struct {
+	Accounts         int             `json:"accounts,omitempty"`
+	Resources        int             `json:"resources"`
+	StandardAgents   int             `json:"standard_agents"`
+	EnterpriseAgents int             `json:"enterprise_agents"`
+	Agents           AgentCounts     `json:"agents"`
+	StandardVCPUs    int             `json:"standard_vcpus"`
+	EnterpriseVCPUs  int             `json:"enterprise_vcpus"`
+	VCPUs            AgentCounts     `json:"vcpus"`
+	States           StateCounts     `json:"vm_states"`
+	Platforms        []PlatformCount `json:"platforms,omitempty"`
+	TagGroups        []TagGroup      `json:"tag_groups,omitempty"`
+	Functions        *Functions      `json:"functions,omitempty"`
+	Volumes          *Volumes        `json:"volumes,omitempty"`
+	Repositories     int             `json:"repositories,omitempty"`
+	Images           int             `json:"images,omitempty"`
+	ImageTags        int             `json:"image_tags,omitempty"`
+	ContainerVCPUs   float64         `json:"serverless_container_vcpus"`
+	ContainerMemory  int             `json:"serverless_container_memory_mb"`
+	AgentSupport     *SupportCounts  `json:"agent_support,omitempty"`
+	Services         map[string]int  `json:"services"`
+}
+
+func New(cloud string) *Report {
+	return &Report{
+		Cloud: cloud,
+		Metadata: Metadata{
+			Tool:      "lw-inventory",
+			StartedAt: time.Now().UTC(),
+			Options:   map[string]string{},
+		},
+	}
+}
+
+// Account returns the account with the given ID, adding it to the report the
+// first time it is seen
+func (r *Report) Account(id string, name string) *Account {
+	for _, a := range r.Accounts {
+		if a.ID == id {
+			return a
+		}
+	}
+	a := &Account{ID: id, Name: name}
+	r.Accounts = append(r.Accounts, a)
+	return a
+}
+
+// Region returns the named region of the account, adding it the first time
+// it is seen
+func (a *Account) Region(name string) *Region {
+	for _, r := range a.Regions {
+		if r.Name == name {
+			return r
+		}
+	}
+	r := &Region{Name: name}
+	a.Regions = append(a.Regions, r)
+	return r
+}
+
+func (r *Report) AddFailure(account string, region string, service string, err error) {
+	r.Failures = append(r.Failures, Failure{Account: account, Region: region, Service: service, Error: err.Error()})
+}
+
+func (r *Region) AddService(service string, category string, count int) {
+	for i, s := range r.Services {
+		if s.Service == service {
+			r.Services[i].Count += count
+			return
+		}
+	}
+	r.Services = append(r.Services, ServiceCount{Service: service, Category: category, Count: count})
+}
+
+// AddPlatform adds the VMs of p to the matching platform of the region
+func (r *Region) AddPlatform(p PlatformCount) {
+	r.Platforms = AddPlatform(r.Platforms, p)
+}
+
+// AddPlatform adds p to the matching entry of platforms, or appends it
+func AddPlatform(platforms []PlatformCount, p PlatformCount) []PlatformCount {
+	for i, c := range platforms {
+		if c.AgentType == p.AgentType && c.Family == p.Family && c.Distribution == p.Distribution && c.Architecture == p.Architecture {
+			platforms[i].Count += p.Count
+			platforms[i].VCPUs += p.VCPUs
+			return platforms
+		}
+	}
+	return append(platforms, p)
+}
+
+// AddTagGroup adds the counts of g to the group of the region with the same
+// value
+func (r *Region) AddTagGroup(g TagGroup) {
+	r.TagGroups = AddTagGroup(r.TagGroups, g)
+}
+
+// AddTagGroup adds g to the entry of groups with the same value, or appends it
+func AddTagGroup(groups []TagGroup, g TagGroup) []TagGroup {
+	for i, c := range groups {
+		if c.Value == g.Value {
+			groups[i].StandardVMs += g.StandardVMs
+			groups[i].EnterpriseVMs += g.EnterpriseVMs
+			groups[i].StandardVCPUs += g.StandardVCPUs
+			groups[i].EnterpriseVCPUs += g.EnterpriseVCPUs
+			groups[i].AgentlessResources += g.AgentlessResources
+			return groups
+		}
+	}
+	return append(groups, g)
+}
+
+func (c AgentCounts) plus(o AgentCounts) AgentCounts {
+	c.Standard.Linux += o.Standard.Linux
+	c.Standard.Windows += o.Standard.Windows
+	c.Standard.Other += o.Standard.Other
+	c.Enterprise.Linux += o.Enterprise.Linux
+	c.Enterprise.Windows += o.Enterprise.Windows
+	c.Enterprise.Other += o.Enterprise.Other
+	return c
+}
+
+func (t *Totals) add(o Totals) {
+	t.Resources += o.Resources
+	t.Agents = t.Agents.plus(o.Agents)
+	t.StandardAgents = t.Agents.Standard.Total()
+	t.EnterpriseAgents = t.Agents.Enterprise.Total()
+	t.VCPUs = t.VCPUs.plus(o.VCPUs)
+	t.StandardVCPUs = t.VCPUs.Standard.Total()
+	t.EnterpriseVCPUs = t.VCPUs.Enterprise.Total()
+	t.States.Running += o.States.Running
+	t.States.Stopped += o.States.Stopped
+	for _, p := range o.Platforms {
+		t.Platforms = AddPlatform(t.Platforms, p)
+	}
+	for _, g := range o.TagGroups {
+		t.TagGroups = AddTagGroup(t.TagGroups, g)
+	}
+	if o.Functions != nil {
+		if t.Functions == nil {
+			t.Functions = NewFunctions()
+		}
+		t.Functions.add(o.Functions)
+	}
+	if o.Volumes != nil {
+		if t.Volumes == nil {
+			t.Volumes = NewVolumes()
+		}
+		t.Volumes.Add(o.Volumes)
+	}
+	t.Repositories += o.Repositories
+	t.Images += o.Images
+	t.ImageTags += o.ImageTags
+	t.ContainerVCPUs += o.ContainerVCPUs
+	t.ContainerMemory += o.ContainerMemory
+	if o.AgentSupport != nil {
+		if t.AgentSupport == nil {
+			t.AgentSupport = &SupportCounts{}
+		}
+		t.AgentSupport.Supported += o.AgentSupport.Supported
+		t.AgentSupport.Unsupported += o.AgentSupport.Unsupported
+		t.AgentSupport.Unknown += o.AgentSupport.Unknown
+	}
+	for s, c := range o.Services {
+		t.Services[s] += c
+	}
+}
+
+func (r *Region) totals() Totals {
+	t := Totals{Services: map[string]int{}}
+	for _, s := range r.Services {
+		t.Services[s.Service] += s.Count
+		//VMs are resources too, containers are counted separately
+		if s.Category == CategoryAgentless || s.Category == CategoryAgent {
+			t.Resources += s.Count
+		}
+	}
+	t.Agents = r.Agents
+	t.StandardAgents = t.Agents.Standard.Total()
+	t.EnterpriseAgents = t.Agents.Enterprise.Total()
+	t.VCPUs = r.VCPUs
+	t.StandardVCPUs = t.VCPUs.Standard.Total()
+	t.EnterpriseVCPUs = t.VCPUs.Enterprise.Total()
+	t.States = r.States
+	t.Platforms = append([]PlatformCount{}, r.Platforms...)
+	t.TagGroups = append([]TagGroup{}, r.TagGroups...)
+	t.Functions = r.Functions
+	t.Volumes = r.Volumes
+	t.Repositories = len(r.Repositories)
+	for _, repo := range r.Repositories {
+		t.Images += repo.Images
+		t.ImageTags += repo.Tags
+	}
+	for _, c := range r.ServerlessContainers {
+		t.ContainerVCPUs += c.VCPUs
+		t.ContainerMemory += c.MemoryMB
+	}
+	for _, h := range r.Hosts {
+		if h.Support == "" {
+			continue
+		}
+		if t.AgentSupport == nil {
+			t.AgentSupport = &SupportCounts{}
+		}
+		switch h.Support {
+		case SupportSupported:
+			t.AgentSupport.Supported++
+		case SupportUnsupported:
+			t.AgentSupport.Unsupported++
+		default:
+			t.AgentSupport.Unknown++
+		}
+	}
+	return t
+}
+
+// Finish stamps the end of the scan and totals the report
+func (r *Report) Finish() {
+	r.Metadata.FinishedAt = time.Now().UTC()
+	r.Metadata.Duration = r.Metadata.FinishedAt.Sub(r.Metadata.StartedAt).Round(time.Second).String()
+	r.Total()
+}
+
+// Total rolls region counts up into the account and report totals, again
+// after the regions of a finished report changed
+func (r *Report) Total() {
+	r.Totals = Totals{Services: map[string]int{}}
+	for _, a := range r.Accounts {
+		sort.Slice(a.Regions, func(i, j int) bool { return a.Regions[i].Name < a.Regions[j].Name })
+		a.Totals = Totals{Services: map[string]int{}}
+		for _, region := range a.Regions {
+			sort.Slice(region.Services, func(i, j int) bool { return region.Services[i].Service < region.Services[j].Service })
+			a.Totals.add(region.totals())
+		}
+		r.Totals.add(a.Totals)
+	}
+	sort.Slice(r.Totals.TagGroups, func(i, j int) bool { return r.Totals.TagGroups[i].Value < r.Totals.TagGroups[j].Value })
+	r.Totals.Accounts = len(r.Accounts)
+	r.Incomplete = len(r.Failures) > 0
+}
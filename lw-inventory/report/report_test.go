@@ -0,0 +1,83 @@
+package report
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// testReport is two AWS accounts, one with regions added out of order and
+// one with a failed scan
+func testReport() *Report {
+	r := New("aws")
+	prod := r.Account("111111111111", "prod")
+	us := prod.Region("us-east-1")
+	us.AddService("RDS", CategoryAgentless, 2)
+	us.AddService("EC2", CategoryAgent, 3)
+	us.Agents.Standard.Linux = 2
+	us.Agents.Enterprise.Windows = 1
+	us.VCPUs.Standard.Linux = 4
+	us.VCPUs.Enterprise.Windows = 8
+	us.States.Running = 3
+	eu := prod.Region("eu-west-1")
+	eu.AddService("EC2", CategoryAgent, 1)
+	eu.Agents.Standard.Linux = 1
+	eu.VCPUs.Standard.Linux = 2
+	eu.States.Stopped = 1
+
+	dev := r.Account("222222222222", "dev")
+	dev.Region("us-east-1").AddService("ECS Fargate Running Tasks", CategoryContainer, 4)
+	dev.Region("us-east-1").Repositories = []Repository{{Registry: "222222222222", Name: "web", Images: 3, Tags: 5}}
+	r.AddFailure("222222222222", "eu-west-1", "Lambda", errors.New("AccessDenied"))
+	return r
+}
+
+func TestTotal(t *testing.T) {
+	r := testReport()
+	r.Total()
+
+	prod := r.Account("111111111111", "")
+	if prod.Regions[0].Name != "eu-west-1" || prod.Regions[1].Name != "us-east-1" {
+		t.Errorf("regions not sorted: %s, %s", prod.Regions[0].Name, prod.Regions[1].Name)
+	}
+	if prod.Totals.Resources != 6 || prod.Totals.StandardAgents != 3 || prod.Totals.EnterpriseAgents != 1 || prod.Totals.StandardVCPUs != 6 {
+		t.Errorf("prod totals = %+v", prod.Totals)
+	}
+
+	want := Totals{
+		Accounts:         2,
+		Resources:        6,
+		StandardAgents:   3,
+		EnterpriseAgents: 1,
+		Agents:           AgentCounts{Standard: OSCounts{Linux: 3}, Enterprise: OSCounts{Windows: 1}},
+		StandardVCPUs:    6,
+		EnterpriseVCPUs:  8,
+		VCPUs:            AgentCounts{Standard: OSCounts{Linux: 6}, Enterprise: OSCounts{Windows: 8}},
+		States:           StateCounts{Running: 3, Stopped: 1},
+		Repositories:     1,
+		Images:           3,
+		ImageTags:        5,
+		Services:         map[string]int{"EC2": 4, "RDS": 2, "ECS Fargate Running Tasks": 4},
+	}
+	if !reflect.DeepEqual(r.Totals, want) {
+		t.Errorf("Totals = %+v\nwant %+v", r.Totals, want)
+	}
+	if !r.Incomplete {
+		t.Error("report with a failure isn't incomplete")
+	}
+}
+
+func TestTotalAgain(t *testing.T) {
+	r := testReport()
+	r.Failures = nil
+	r.Total()
+	r.Total()
+
+	//totals are rebuilt, not added to
+	if r.Totals.Resources != 6 || r.Totals.Services["EC2"] != 4 || r.Totals.Accounts != 2 {
+		t.Errorf("Totals after a second Total() = %+v", r.Totals)
+	}
+	if r.Incomplete {
+		t.Error("report without failures is incomplete")
+	}
+}